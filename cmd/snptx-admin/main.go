@@ -4,12 +4,17 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/tullo/conf"
+	"github.com/tullo/snptx/internal/export"
+	"github.com/tullo/snptx/internal/models"
 	"github.com/tullo/snptx/internal/platform/database"
+	"github.com/tullo/snptx/internal/platform/sec"
 	"github.com/tullo/snptx/internal/schema"
 )
 
@@ -33,6 +38,11 @@ func run() error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:false"`
 		}
+		// the parameters snptx hashes with, compared by hash-report
+		Aragon  sec.Config
+		Migrate struct {
+			SnippetOwner string `conf:"help:email of the user taking over the snippets created before ownership was tracked, required when migrating a database with such snippets"`
+		}
 		Args conf.Args
 	}
 
@@ -60,9 +70,19 @@ func run() error {
 	var err error
 	switch cfg.Args.Num(0) {
 	case "migrate":
-		err = migrate(dbConfig)
+		err = migrate(dbConfig, cfg.Migrate.SnippetOwner)
 	case "seed":
 		err = seed(dbConfig)
+	case "activate":
+		err = setActive(dbConfig, cfg.Args.Num(1), true)
+	case "deactivate":
+		err = setActive(dbConfig, cfg.Args.Num(1), false)
+	case "reset-2fa":
+		err = resetTwoFactor(dbConfig, cfg.Args.Num(1))
+	case "export-user":
+		err = exportUser(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2))
+	case "hash-report":
+		err = hashReport(dbConfig, cfg.Aragon.HashParams())
 	default:
 		err = errors.New("Must specify a command")
 	}
@@ -74,18 +94,40 @@ func run() error {
 	return nil
 }
 
-func migrate(cfg database.Config) error {
+func migrate(cfg database.Config, owner string) error {
 	dbaddr, ok := os.LookupEnv("DATABASE_URL")
 	if !ok {
 		log.Println("DATABASE_URL env var not defined")
 	}
 
-	if err := schema.Migrate(dbaddr); err != nil {
+	// the snippets created before ownership was tracked go to the owner
+	backfill := func() error {
+		deadline := time.Now().Add(time.Second * 15)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+
+		pool, err := database.Connect(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer pool.Close()
+
+		db := database.DB{Pool: pool}
+		n, err := schema.BackfillOwner(ctx, &db, owner)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			fmt.Printf("Reassigned %d snippets to %s\n", n, owner)
+		}
+		return nil
+	}
+
+	if err := schema.Migrate(dbaddr, backfill); err != nil {
 		return err
 	}
 
 	fmt.Println("Migrations complete")
-
 	return nil
 }
 
@@ -108,3 +150,178 @@ func seed(cfg database.Config) error {
 	fmt.Println("Seed data complete")
 	return nil
 }
+
+// setActive reactivates or deactivates the user with the email. Deactivated
+// users are logged out of all their sessions.
+func setActive(cfg database.Config, email string, active bool) error {
+	if email == "" {
+		return errors.New("Must specify the email of the user")
+	}
+
+	deadline := time.Now().Add(time.Second * 15)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	pool, err := database.Connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	db := database.DB{Pool: pool}
+	users := models.NewUserStore(&db, nil)
+
+	usr, err := users.QueryByEmail(ctx, email)
+	if err != nil {
+		return errors.Wrapf(err, "finding user %s", email)
+	}
+
+	if err := users.Update(ctx, usr.ID, models.UpdateUser{Active: &active}, time.Now()); err != nil {
+		return err
+	}
+
+	if active {
+		fmt.Printf("User %s activated\n", email)
+	} else {
+		fmt.Printf("User %s deactivated\n", email)
+	}
+	return nil
+}
+
+// resetTwoFactor disables the two-factor authentication of the user with the
+// email, e.g. after they lost their authenticator app and recovery codes.
+func resetTwoFactor(cfg database.Config, email string) error {
+	if email == "" {
+		return errors.New("Must specify the email of the user")
+	}
+
+	deadline := time.Now().Add(time.Second * 15)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	pool, err := database.Connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	db := database.DB{Pool: pool}
+	users := models.NewUserStore(&db, nil)
+
+	usr, err := users.QueryByEmail(ctx, email)
+	if err != nil {
+		return errors.Wrapf(err, "finding user %s", email)
+	}
+
+	// the secrets are not decrypted, no key is needed
+	twoFactor := models.NewTwoFactorStore(&db, nil)
+	if err := twoFactor.Disable(ctx, usr.ID); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			fmt.Printf("User %s has not enabled two-factor authentication\n", email)
+			return nil
+		}
+		return err
+	}
+
+	fmt.Printf("Two-factor authentication of user %s reset\n", email)
+	return nil
+}
+
+// exportUser writes the archive of the data held about the user with the
+// email to the file, or to a file named after the date if it is empty. The
+// archive is the one users download from their profile.
+func exportUser(cfg database.Config, email, file string) error {
+	if email == "" {
+		return errors.New("Must specify the email of the user")
+	}
+
+	deadline := time.Now().Add(time.Minute * 10)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	pool, err := database.Connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	db := database.DB{Pool: pool}
+	usr, err := models.NewUserStore(&db, nil).QueryByEmail(ctx, email)
+	if err != nil {
+		return errors.Wrapf(err, "finding user %s", email)
+	}
+
+	now := time.Now()
+	if file == "" {
+		file = export.Filename(now)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, "creating archive")
+	}
+
+	stores := export.Stores{
+		Snippets: models.NewSnippetStore(&db),
+		Audit:    models.NewAuditStore(&db, 0),
+		Sessions: models.NewSessionsStore(&db),
+	}
+	err = export.Write(ctx, f, stores, usr, now)
+	if cerr := f.Close(); err == nil {
+		err = errors.Wrap(cerr, "closing archive")
+	}
+	if err != nil {
+		// an incomplete archive must not be handed out
+		os.Remove(file)
+		return err
+	}
+
+	fmt.Printf("Data of user %s exported to %s\n", email, file)
+	return nil
+}
+
+// hashReport counts the users whose password hashes were created with
+// parameters other than the configured ones. Their passwords are hashed again
+// on their next login.
+func hashReport(cfg database.Config, hp sec.HashParams) error {
+	deadline := time.Now().Add(time.Second * 15)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	pool, err := database.Connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	db := database.DB{Pool: pool}
+	users, err := models.NewUserStore(&db, nil).List(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := sec.Params(hp)
+	var outdated, invalid int
+	counts := make(map[string]int)
+	for _, u := range users {
+		p, err := sec.HashParamsOf(u.HashedPassword)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if *p != *current {
+			outdated++
+			counts[sec.FormatParams(p)]++
+		}
+	}
+
+	fmt.Printf("Configured parameters: %s\n", sec.FormatParams(current))
+	fmt.Printf("%d of %d users on the configured parameters\n", len(users)-outdated-invalid, len(users))
+	fmt.Printf("%d users on outdated parameters\n", outdated)
+	for _, p := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Printf("  %s: %d\n", p, counts[p])
+	}
+	if invalid > 0 {
+		fmt.Printf("%d users with unreadable hashes\n", invalid)
+	}
+	return nil
+}
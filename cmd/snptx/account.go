@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/tullo/snptx/internal/export"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/mail"
+	"github.com/tullo/snptx/internal/validator"
+)
+
+// exportTimeout is how long the download of an export may take, it outlasts
+// the write timeout of the server.
+const exportTimeout = 10 * time.Minute
+
+type profileForm struct {
+	Name                string `form:"name"`
+	Email               string `form:"email"`
+	CurrentPassword     string `form:"currentPassword"`
+	validator.Validator `form:"-"`
+}
+
+func (a *app) profileEditForm(w http.ResponseWriter, r *http.Request) {
+	usr, err := a.users.QueryByID(r.Context(), a.authenticatedUserID(r))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.renderProfileEdit(w, r, http.StatusOK, usr, profileForm{Name: usr.Name, Email: usr.Email})
+}
+
+func (a *app) renderProfileEdit(w http.ResponseWriter, r *http.Request, status int, usr *models.User, form profileForm) {
+	data := a.newTemplateData(r)
+	data.Form = form
+	data.User = usr
+	data.Reauthenticated = a.reauthenticated(r)
+	a.render(w, r, status, "profile_edit.tmpl", data)
+}
+
+// profileEditPost changes the name and email address of the user. A new
+// email address requires the user to confirm their identity and has to be
+// verified again, the old address is told about the change.
+func (a *app) profileEditPost(w http.ResponseWriter, r *http.Request) {
+	var form profileForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := a.authenticatedUserID(r)
+	usr, err := a.users.QueryByID(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	emailChanged := form.Email != usr.Email
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	if emailChanged {
+		if err := a.confirmIdentity(r, &form.Validator, usr, form.CurrentPassword); err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	if form.Valid() {
+		upd := models.UpdateUser{Name: &form.Name, Email: &form.Email}
+		err := a.users.Update(r.Context(), userID, upd, time.Now())
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			form.AddFieldError("email", "Email address is already in use")
+		case err != nil:
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		a.renderProfileEdit(w, r, http.StatusUnprocessableEntity, usr, form)
+		return
+	}
+
+	msg := "Your profile has been updated!"
+	if emailChanged {
+		a.recordAudit(r, models.AuditEmailChange, userID, userID, map[string]string{"from": usr.Email, "to": form.Email})
+		a.sendMail(mail.Message{
+			To:      usr.Email,
+			Subject: "Your Snippetbox email address has been changed",
+			Body: fmt.Sprintf("Hi %s,\n\n"+
+				"the email address of your Snippetbox account has been changed to %s.\n\n"+
+				"If you did not change it, please contact us right away.\n",
+				form.Name, form.Email),
+		})
+
+		changed := *usr
+		changed.Name, changed.Email, changed.Verified = form.Name, form.Email, false
+		if err := a.sendVerification(r.Context(), &changed); err != nil && !errors.Is(err, models.ErrRateLimited) {
+			a.serverError(w, r, err)
+			return
+		}
+		msg = "Your profile has been updated! We have sent a link to verify your new email address."
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// confirmIdentity checks that the user making a sensitive change owns the
+// account: by the current password, or by a recent re-authentication at the
+// provider for an account provisioned by single sign-on. A failed check is
+// added to v.
+func (a *app) confirmIdentity(r *http.Request, v *validator.Validator, usr *models.User, password string) error {
+	if usr.SSOOnly {
+		if !a.reauthenticated(r) {
+			v.AddNonFieldError("Please confirm your identity with single sign-on first.")
+		}
+		return nil
+	}
+
+	v.CheckField(validator.NotBlank(password), "currentPassword", "This field cannot be blank")
+	if !v.Valid() {
+		return nil
+	}
+	err := a.users.CheckPassword(r.Context(), usr.ID, password)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		v.AddFieldError("currentPassword", "Current password is incorrect")
+		return nil
+	}
+	return err
+}
+
+// userExport streams a ZIP archive of the data held about the user. The
+// archive is written while the data is read, a failure midway leaves the
+// download incomplete.
+func (a *app) userExport(w http.ResponseWriter, r *http.Request) {
+	userID := a.authenticatedUserID(r)
+	usr, err := a.users.QueryByID(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	a.recordAudit(r, models.AuditDataExport, userID, userID, nil)
+
+	cd := mime.FormatMediaType("attachment", map[string]string{"filename": export.Filename(now)})
+	w.Header().Set("Content-Disposition", cd)
+	w.Header().Set("Content-Type", "application/zip")
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(now.Add(exportTimeout)); err != nil {
+		a.log.Printf("export: extending write deadline: %v", err)
+	}
+
+	stores := export.Stores{Snippets: a.snippets, Audit: a.audit, Sessions: a.sessions}
+	if err := export.Write(r.Context(), w, stores, usr, now); err != nil {
+		// the archive is sent in part already, the status cannot change
+		a.log.Printf("export: user %s: %v", userID, err)
+	}
+}
+
+// lookupSuccessor returns the ID of the user with the email, who takes over
+// the snippets of deleted accounts, or an empty ID if the email is empty. It
+// is called at startup, so a mistyped email stops snptx instead of failing
+// every account deletion.
+func lookupSuccessor(ctx context.Context, users models.UserModelInterface, email string) (string, error) {
+	if email == "" {
+		return "", nil
+	}
+
+	usr, err := users.QueryByEmail(ctx, email)
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		return "", fmt.Errorf("snippet successor %q does not exist", email)
+	case err != nil:
+		return "", fmt.Errorf("looking up snippet successor %q: %w", email, err)
+	}
+	return usr.ID, nil
+}
+
+type deleteAccountForm struct {
+	CurrentPassword     string `form:"currentPassword"`
+	validator.Validator `form:"-"`
+}
+
+func (a *app) deleteAccountForm(w http.ResponseWriter, r *http.Request) {
+	usr, err := a.users.QueryByID(r.Context(), a.authenticatedUserID(r))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.renderDeleteAccount(w, r, http.StatusOK, usr, deleteAccountForm{})
+}
+
+func (a *app) renderDeleteAccount(w http.ResponseWriter, r *http.Request, status int, usr *models.User, form deleteAccountForm) {
+	data := a.newTemplateData(r)
+	data.Form = form
+	data.User = usr
+	data.Reauthenticated = a.reauthenticated(r)
+	data.ReassignSnippets = a.successor != ""
+	a.render(w, r, status, "account_delete.tmpl", data)
+}
+
+// deleteAccountPost deletes the account of the user after confirming their
+// identity. The snippets of the user are handed over to the configured
+// successor, or deleted with the account. All sessions of the user end.
+func (a *app) deleteAccountPost(w http.ResponseWriter, r *http.Request) {
+	var form deleteAccountForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := a.authenticatedUserID(r)
+	usr, err := a.users.QueryByID(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	if err := a.confirmIdentity(r, &form.Validator, usr, form.CurrentPassword); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	successorID := a.successor
+	if successorID == userID {
+		form.AddNonFieldError("This account takes over the snippets of deleted accounts and cannot be deleted.")
+	}
+
+	if !form.Valid() {
+		a.renderDeleteAccount(w, r, http.StatusUnprocessableEntity, usr, form)
+		return
+	}
+
+	// the sessions of the user are deleted with the account
+	if err := a.users.Delete(r.Context(), userID, successorID); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	snippets := "deleted"
+	if successorID != "" {
+		snippets = "reassigned"
+	}
+	a.recordAudit(r, models.AuditAccountDelete, userID, userID, map[string]string{"email": usr.Email, "snippets": snippets})
+
+	// any further use of the session starts a new one
+	if err := a.sessionManager.Destroy(r.Context()); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Your account has been deleted.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"html"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/tullo/snptx/internal/assert"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/models/mock"
+)
+
+func TestProfileEdit(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "erin@example.com")
+
+	code, _, body := ts.get(t, "/user/profile/edit")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "<input type='email' name='email' value='erin@example.com'>")
+
+	tests := []struct {
+		name            string
+		userName        string
+		email           string
+		currentPassword string
+		wantCode        int
+		wantBody        string
+	}{
+		{"Blank Name", "", "erin@example.com", "", http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"Invalid Email", "Erin", "erin@example.", "validPa$$word", http.StatusUnprocessableEntity, "This field must be a valid email address"},
+		{"Email Without Password", "Erin", "erin@example.org", "", http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"Invalid Current Password", "Erin", "erin@example.org", "GophersAreCute", http.StatusUnprocessableEntity, "Current password is incorrect"},
+		{"Duplicate Email", "Erin", "dupe@example.com", "validPa$$word", http.StatusUnprocessableEntity, "Email address is already in use"},
+		{"Name Only", "Erin E.", "erin@example.com", "", http.StatusSeeOther, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("email", tt.email)
+			form.Add("currentPassword", tt.currentPassword)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/profile/edit", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, string(body), tt.wantBody)
+			}
+		})
+	}
+	assert.Equal(t, len(sentMail(app)), 0)
+	assert.Equal(t, len(app.audit.(*mock.AuditStore).Events()), 1) // the login
+
+	// a new email address has to be verified again
+	form := url.Values{}
+	form.Add("name", "Erin")
+	form.Add("email", "erin@example.org")
+	form.Add("currentPassword", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, headers, _ := ts.postForm(t, "/user/profile/edit", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/profile")
+
+	_, _, body = ts.get(t, "/user/profile")
+	assert.StringContains(t, string(body), "We have sent a link to verify your new email address.")
+
+	sent := sentMail(app)
+	assert.Equal(t, len(sent), 2)
+	for _, m := range sent {
+		switch m.To {
+		case "erin@example.com":
+			assert.StringContains(t, m.Body, "has been changed to erin@example.org")
+		case "erin@example.org":
+			assert.StringContains(t, m.Body, app.baseURL+"/user/verify/")
+		default:
+			t.Errorf("unexpected mail to %q", m.To)
+		}
+	}
+
+	events := app.audit.(*mock.AuditStore).Events()
+	last := events[len(events)-1]
+	assert.Equal(t, last.Type, models.AuditEmailChange)
+	assert.Equal(t, last.Details["from"], "erin@example.com")
+	assert.Equal(t, last.Details["to"], "erin@example.org")
+}
+
+func TestDeleteAccount(t *testing.T) {
+	tests := []struct {
+		name         string
+		successor    string
+		email        string
+		password     string
+		wantCode     int
+		wantBody     string
+		wantSnippets string
+	}{
+		{"Blank Password", "", "alice@example.com", "", http.StatusUnprocessableEntity, "This field cannot be blank", ""},
+		{"Invalid Password", "", "alice@example.com", "GophersAreCute", http.StatusUnprocessableEntity, "Current password is incorrect", ""},
+		{"Successor", "9", "carol@example.com", "validPa$$word", http.StatusUnprocessableEntity, "cannot be deleted", ""},
+		{"Delete Snippets", "", "alice@example.com", "validPa$$word", http.StatusSeeOther, "", "deleted"},
+		{"Reassign Snippets", "9", "alice@example.com", "validPa$$word", http.StatusSeeOther, "", "reassigned"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.successor = tt.successor
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t, tt.email)
+
+			_, _, body := ts.get(t, "/user/delete")
+			if tt.successor != "" {
+				assert.StringContains(t, string(body), "Your snippets will be kept")
+			} else {
+				assert.StringContains(t, string(body), "Your account and all of your snippets will be deleted")
+			}
+
+			form := url.Values{}
+			form.Add("currentPassword", tt.password)
+			form.Add("csrf_token", csrfToken)
+			code, headers, body := ts.postForm(t, "/user/delete", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, string(body), html.EscapeString(tt.wantBody))
+			}
+			if tt.wantSnippets == "" {
+				return
+			}
+			assert.Equal(t, headers.Get("Location"), "/")
+
+			_, _, body = ts.get(t, "/")
+			assert.StringContains(t, string(body), "Your account has been deleted.")
+
+			// the user is logged out
+			code, _, _ = ts.get(t, "/user/profile")
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			events := app.audit.(*mock.AuditStore).Events()
+			last := events[len(events)-1]
+			assert.Equal(t, last.Type, models.AuditAccountDelete)
+			assert.Equal(t, last.TargetID, "1")
+			assert.Equal(t, last.Details["snippets"], tt.wantSnippets)
+		})
+	}
+}
+
+func TestLookupSuccessor(t *testing.T) {
+	users := mock.NewUserStore()
+
+	id, err := lookupSuccessor(t.Context(), users, "carol@example.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, id, "9")
+
+	id, err = lookupSuccessor(t.Context(), users, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, id, "")
+
+	_, err = lookupSuccessor(t.Context(), users, "carol@example.org")
+	if err == nil {
+		t.Error("want error for an unknown successor")
+	}
+}
+
+func TestUserExport(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/user/export")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	ts.login(t, "alice@example.com")
+
+	code, headers, body := ts.get(t, "/user/export")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "application/zip")
+	assert.StringContains(t, headers.Get("Content-Disposition"), `attachment; filename=snptx-export-`)
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	want := []string{"profile.json", "snippets.json", "snippets/1.txt", "snippets/6.txt", "audit_events.json", "sessions.json"}
+	if !slices.Equal(names, want) {
+		t.Errorf("want files %v; got %v", want, names)
+	}
+
+	events := app.audit.(*mock.AuditStore).Events()
+	last := events[len(events)-1]
+	assert.Equal(t, last.Type, models.AuditDataExport)
+	assert.Equal(t, last.TargetID, "1")
+}
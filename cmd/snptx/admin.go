@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/auth"
+	"github.com/tullo/snptx/internal/validator"
+)
+
+// adminUsers renders the users with the controls to change their status
+// and roles.
+func (a *app) adminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := a.users.List(r.Context())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(r)
+	data.Users = users
+
+	a.render(w, r, http.StatusOK, "admin_users.tmpl", data)
+}
+
+// adminUserActivatePost reactivates a deactivated user.
+func (a *app) adminUserActivatePost(w http.ResponseWriter, r *http.Request) {
+	a.setUserActive(w, r, true)
+}
+
+// adminUserDeactivatePost deactivates a user. Admins may not deactivate
+// themselves, there would be nobody left to undo it.
+func (a *app) adminUserDeactivatePost(w http.ResponseWriter, r *http.Request) {
+	a.setUserActive(w, r, false)
+}
+
+func (a *app) setUserActive(w http.ResponseWriter, r *http.Request, active bool) {
+	id := r.PathValue("id")
+	if !active && id == a.authenticatedUserID(r) {
+		a.sessionManager.Put(r.Context(), "flash", "You cannot deactivate your own account!")
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	if !a.updateUser(w, r, id, models.UpdateUser{Active: &active}) {
+		return
+	}
+
+	eventType, msg := models.AuditUserDeactivate, "User deactivated!"
+	if active {
+		eventType, msg = models.AuditUserActivate, "User reactivated!"
+	}
+	a.recordAudit(r, eventType, a.authenticatedUserID(r), id, nil)
+	a.sessionManager.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+type adminRolesForm struct {
+	Roles               []string `form:"roles"`
+	validator.Validator `form:"-"`
+}
+
+// adminUserRolesPost replaces the roles of a user. Admins may not revoke
+// their own admin role.
+func (a *app) adminUserRolesPost(w http.ResponseWriter, r *http.Request) {
+	var form adminRolesForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	form.CheckField(len(form.Roles) > 0, "roles", "Select at least one role")
+	for _, role := range form.Roles {
+		form.CheckField(validator.PermittedValue(role, auth.RoleAdmin, auth.RoleUser), "roles", fmt.Sprintf("This field must equal %s or %s", auth.RoleAdmin, auth.RoleUser))
+	}
+	if id == a.authenticatedUserID(r) {
+		form.CheckField(slices.Contains(form.Roles, auth.RoleAdmin), "roles", "You cannot revoke your own admin role")
+	}
+
+	if !form.Valid() {
+		users, err := a.users.List(r.Context())
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		data := a.newTemplateData(r)
+		data.Users = users
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "admin_users.tmpl", data)
+		return
+	}
+
+	if !a.updateUser(w, r, id, models.UpdateUser{Roles: form.Roles}) {
+		return
+	}
+	a.recordAudit(r, models.AuditRoleChange, a.authenticatedUserID(r), id, map[string]string{"roles": strings.Join(form.Roles, ",")})
+
+	a.sessionManager.Put(r.Context(), "flash", "Roles updated!")
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// adminUserResetTwoFactorPost disables the two-factor authentication of a
+// user who has lost their authenticator app and their recovery codes.
+func (a *app) adminUserResetTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := a.twoFactor.Disable(r.Context(), id)
+	switch {
+	case errors.Is(err, models.ErrInvalidID):
+		a.notFound(w)
+		return
+	case errors.Is(err, models.ErrNoRecord):
+		a.sessionManager.Put(r.Context(), "flash", "The user has not enabled two-factor authentication.")
+	case err != nil:
+		a.serverError(w, r, err)
+		return
+	default:
+		a.recordAudit(r, models.AuditTwoFactorReset, a.authenticatedUserID(r), id, nil)
+		a.sessionManager.Put(r.Context(), "flash", "Two-factor authentication reset!")
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// updateUser applies the update to the user. It writes a response and
+// returns false if the update fails.
+func (a *app) updateUser(w http.ResponseWriter, r *http.Request, id string, upd models.UpdateUser) bool {
+	err := a.users.Update(r.Context(), id, upd, time.Now())
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidID) || errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return false
+	}
+	return true
+}
+
+// adminSnippets renders the snippets of all users, including the unlisted,
+// private and expired ones.
+func (a *app) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	cursor := r.URL.Query().Get("cursor")
+
+	p, err := a.snippets.List(r.Context(), models.SnippetFilter{
+		All:            true,
+		IncludeExpired: true,
+		Cursor:         cursor,
+	})
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			a.clientError(w, http.StatusBadRequest)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	users, err := a.users.List(r.Context())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// owners are shown by their email
+	owners := make(map[string]string, len(users))
+	for _, u := range users {
+		owners[u.ID] = u.Email
+	}
+
+	data := a.newTemplateData(r)
+	data.Snippets = p.Snippets
+	data.Owners = owners
+	if p.Next != "" {
+		data.NextPage = "/admin/snippets?cursor=" + p.Next
+	}
+	if p.Prev != "" {
+		data.PrevPage = "/admin/snippets?cursor=" + p.Prev
+	}
+
+	a.render(w, r, http.StatusOK, "admin_snippets.tmpl", data)
+}
+
+type adminVisibilityForm struct {
+	Visibility string `form:"visibility"`
+}
+
+// adminSnippetVisibilityPost changes the visibility of any snippet, e.g. to
+// take an inappropriate snippet off the public listings. The change is
+// recorded as a revision authored by the admin and in the audit log.
+func (a *app) adminSnippetVisibilityPost(w http.ResponseWriter, r *http.Request) {
+	var form adminVisibilityForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if !validator.PermittedValue(form.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate) {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, ok := a.adminSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	up := models.UpdateSnippet{Visibility: &form.Visibility}
+	err = a.snippets.Update(r.Context(), s.ID, up, a.authenticatedUserID(r), time.Now().Local())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.recordAudit(r, models.AuditSnippetVisible, a.authenticatedUserID(r), s.ID, map[string]string{
+		"title":    s.Title,
+		"owner_id": s.OwnerID,
+		"from":     s.Visibility,
+		"to":       form.Visibility,
+	})
+
+	a.sessionManager.Put(r.Context(), "flash", "Snippet visibility changed!")
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+// adminSnippetDeletePost deletes any snippet.
+func (a *app) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.adminSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.snippets.Delete(r.Context(), s.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.recordSnippetDelete(r, s)
+
+	a.sessionManager.Put(r.Context(), "flash", "Snippet successfully deleted!")
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+// adminSnippet retrieves the snippet identified by the id path value
+// regardless of its visibility and expiry. It writes a 404 response and
+// returns false if there is no such snippet.
+func (a *app) adminSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, err := a.snippets.Retrieve(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidID) || errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return s, true
+}
+
+// adminAuditPageSize is the number of audit events listed per page.
+const adminAuditPageSize = 50
+
+type adminAuditForm struct {
+	Type string
+	User string
+}
+
+// adminAudit renders the audit events, the latest first. They may be
+// filtered by type and by the user causing or affected by them.
+func (a *app) adminAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	form := adminAuditForm{Type: q.Get("type"), User: q.Get("user")}
+	if form.Type != "" && !slices.Contains(models.AuditEventTypes, form.Type) {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	f := models.AuditFilter{Type: form.Type, UserID: form.User, Limit: adminAuditPageSize}
+	if before := q.Get("before"); before != "" {
+		t, err := time.Parse(time.RFC3339Nano, before)
+		if err != nil {
+			a.clientError(w, http.StatusBadRequest)
+			return
+		}
+		f.Before, f.BeforeID = t, q.Get("before_id")
+	}
+
+	events, err := a.audit.List(r.Context(), f)
+	switch {
+	case errors.Is(err, models.ErrInvalidID):
+		a.clientError(w, http.StatusBadRequest)
+		return
+	case err != nil:
+		a.serverError(w, r, err)
+		return
+	}
+
+	users, err := a.users.List(r.Context())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// users are shown by their email
+	emails := make(map[string]string, len(users))
+	for _, u := range users {
+		emails[u.ID] = u.Email
+	}
+
+	data := a.newTemplateData(r)
+	data.AuditEvents = events
+	data.AuditEventTypes = models.AuditEventTypes
+	data.Owners = emails
+	data.Form = form
+	if len(events) == adminAuditPageSize {
+		next := url.Values{}
+		if form.Type != "" {
+			next.Set("type", form.Type)
+		}
+		if form.User != "" {
+			next.Set("user", form.User)
+		}
+		last := events[len(events)-1]
+		next.Set("before", last.DateCreated.Format(time.RFC3339Nano))
+		next.Set("before_id", last.ID)
+		data.NextPage = "/admin/audit?" + next.Encode()
+	}
+
+	a.render(w, r, http.StatusOK, "admin_audit.tmpl", data)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tullo/snptx/internal/assert"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/models/mock"
+)
+
+func TestAdminRequireRole(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// anonymous users are sent to the login page
+	code, headers, _ := ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	// users without the admin role are forbidden
+	ts.login(t, "alice@example.com")
+	code, _, _ = ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	_, _, body := ts.get(t, "/")
+	if strings.Contains(string(body), "href='/admin'") {
+		t.Error("want no admin link for users")
+	}
+}
+
+func TestAdminDebugVars(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com")
+	code, _, _ := ts.get(t, "/admin/debug/vars")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	ts.login(t, "carol@example.com")
+	code, headers, body := ts.get(t, "/admin/debug/vars")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, headers.Get("Content-Type"), "application/json")
+	assert.StringContains(t, string(body), `"reaper": {`)
+}
+
+func TestAdminUsers(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "carol@example.com")
+
+	code, _, body := ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "alice@example.com")
+	assert.StringContains(t, string(body), "/admin/user/deactivate/1")
+
+	_, _, body = ts.get(t, "/")
+	assert.StringContains(t, string(body), "href='/admin'")
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		roles        []string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{"Deactivate", "/admin/user/deactivate/1", nil, http.StatusSeeOther, "/admin", ""},
+		{"Reactivate", "/admin/user/activate/1", nil, http.StatusSeeOther, "/admin", ""},
+		{"Deactivate Unknown", "/admin/user/deactivate/2", nil, http.StatusNotFound, "", ""},
+		{"Grant Admin", "/admin/user/roles/1", []string{"ADMIN", "USER"}, http.StatusSeeOther, "/admin", ""},
+		{"No Roles", "/admin/user/roles/1", nil, http.StatusUnprocessableEntity, "", "Select at least one role"},
+		{"Invalid Role", "/admin/user/roles/1", []string{"ROOT"}, http.StatusUnprocessableEntity, "", "This field must equal ADMIN or USER"},
+		{"Revoke Own Admin", "/admin/user/roles/9", []string{"USER"}, http.StatusUnprocessableEntity, "", "You cannot revoke your own admin role"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			for _, role := range tt.roles {
+				form.Add("roles", role)
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+
+	// the own account is not deactivated
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/admin/user/deactivate/9", form)
+	_, _, body = ts.get(t, "/admin")
+	assert.StringContains(t, string(body), "You cannot deactivate your own account!")
+
+	// the changes are audited, the rejected ones are not
+	want := []struct {
+		eventType string
+		details   string
+	}{
+		{models.AuditLogin, ""},
+		{models.AuditUserDeactivate, ""},
+		{models.AuditUserActivate, ""},
+		{models.AuditRoleChange, "ADMIN,USER"},
+	}
+	events := app.audit.(*mock.AuditStore).Events()
+	assert.Equal(t, len(events), len(want))
+	for i, e := range events {
+		if i >= len(want) {
+			break
+		}
+		assert.Equal(t, e.Type, want[i].eventType)
+		assert.Equal(t, e.Details["roles"], want[i].details)
+		if e.Type != models.AuditLogin {
+			assert.Equal(t, e.ActorID, "9")
+			assert.Equal(t, e.TargetID, "1")
+		}
+	}
+}
+
+func TestAdminSnippets(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "carol@example.com")
+
+	// private snippets of other users are listed too
+	code, _, body := ts.get(t, "/admin/snippets")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "/admin/snippet/delete/4")
+	assert.StringContains(t, string(body), "alice@example.com")
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		visibility string
+		wantCode   int
+	}{
+		{"Unlist", "/admin/snippet/visibility/1", "unlisted", http.StatusSeeOther},
+		{"Invalid Visibility", "/admin/snippet/visibility/1", "hidden", http.StatusBadRequest},
+		{"Visibility Unknown", "/admin/snippet/visibility/99", "private", http.StatusNotFound},
+		{"Delete Foreign", "/admin/snippet/delete/3", "", http.StatusSeeOther},
+		{"Delete Unknown", "/admin/snippet/delete/99", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("visibility", tt.visibility)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	// the changes are audited, the rejected ones are not
+	var visibility []models.AuditEvent
+	for _, e := range app.audit.(*mock.AuditStore).Events() {
+		if e.Type == models.AuditSnippetVisible {
+			visibility = append(visibility, e)
+		}
+	}
+	assert.Equal(t, len(visibility), 1)
+	e := visibility[0]
+	assert.Equal(t, e.ActorID, "9")
+	assert.Equal(t, e.TargetID, "1")
+	assert.Equal(t, e.Details["owner_id"], "1")
+	assert.Equal(t, e.Details["from"], models.VisibilityPublic)
+	assert.Equal(t, e.Details["to"], models.VisibilityUnlisted)
+}
+
+func TestAdminAudit(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com")
+	code, _, _ := ts.get(t, "/admin/audit")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	ts.login(t, "carol@example.com")
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantBody string
+		dontWant string
+	}{
+		{"All", "", http.StatusOK, "<a href='/admin/audit?user=1'>alice@example.com</a>", ""},
+		{"By Type", "?type=user.login", http.StatusOK, "<a href='/admin/audit?user=9'>carol@example.com</a>", ""},
+		{"By User", "?user=9", http.StatusOK, "<a href='/admin/audit?user=9'>carol@example.com</a>", "<a href='/admin/audit?user=1'>"},
+		{"No Events", "?type=snippet.delete", http.StatusOK, "There are no audit events.", ""},
+		{"Unknown Type", "?type=unknown", http.StatusBadRequest, "", ""},
+		{"Invalid Before", "?before=yesterday", http.StatusBadRequest, "", ""},
+		{"Invalid Before ID", "?before=2020-01-01T00:00:00Z&before_id=latest", http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, "/admin/audit"+tt.query)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+			if tt.dontWant != "" && strings.Contains(string(body), tt.dontWant) {
+				t.Errorf("want body without %q", tt.dontWant)
+			}
+		})
+	}
+}
+
+func TestAdminAuditPaging(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "carol@example.com")
+
+	// one event more than fits on a page, all recorded at the same time
+	now := time.Now()
+	for i := range adminAuditPageSize + 1 {
+		e := models.AuditEvent{Type: models.AuditDataExport, ActorID: "1", TargetID: "1", IP: fmt.Sprintf("192.0.2.%d", i)}
+		if err := app.audit.Record(t.Context(), e, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, _, body := ts.get(t, "/admin/audit?user=1")
+	assert.StringContains(t, string(body), "192.0.2.50<")
+	if strings.Contains(string(body), "192.0.2.0<") {
+		t.Error("want the oldest event on the next page")
+	}
+
+	m := regexp.MustCompile(`<a href='([^']+)' class='next'>`).FindStringSubmatch(string(body))
+	if m == nil {
+		t.Fatal("want a link to the next page")
+	}
+	next := html.UnescapeString(m[1])
+	assert.StringContains(t, next, "before_id=")
+
+	code, _, body := ts.get(t, next)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "192.0.2.0<")
+	if strings.Contains(string(body), "192.0.2.1<") {
+		t.Error("want the events of the first page left out")
+	}
+}
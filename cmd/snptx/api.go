@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/highlight"
+	"github.com/tullo/snptx/internal/validator"
+)
+
+// apiMaxBodyBytes limits the size of API request bodies.
+const apiMaxBodyBytes = 1 << 20
+
+// apiError is the body of every API error response. Fields holds the
+// validation errors per field of the request.
+type apiError struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// apiSnippetPage is a page of a snippet listing. Next and Prev hold the
+// cursors of the adjacent pages.
+type apiSnippetPage struct {
+	Snippets []models.Snippet `json:"snippets"`
+	Next     string           `json:"next,omitempty"`
+	Prev     string           `json:"prev,omitempty"`
+}
+
+// apiTokenResponse holds a token issued by the API.
+type apiTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// apiOTPHeader carries the two-factor code of users who enabled two-factor
+// authentication when requesting a token.
+const apiOTPHeader = "X-Snptx-OTP"
+
+// apiToken issues a token for the user identified by the email and password
+// given with basic authentication. Users who enabled two-factor
+// authentication pass a code in the apiOTPHeader too. Other API requests are
+// authenticated by passing the token as bearer token.
+func (a *app) apiToken(w http.ResponseWriter, r *http.Request) {
+	email, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="snptx", charset="UTF-8"`)
+		a.apiClientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	claims, needsCode, retryAfter, err := a.checkLogin(r, email, password)
+	if err != nil {
+		if errors.Is(err, models.ErrRateLimited) {
+			setRetryAfter(w, retryAfter)
+			a.apiError(w, http.StatusTooManyRequests, "too many failed login attempts")
+		} else if errors.Is(err, models.ErrAuthenticationFailure) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="snptx", charset="UTF-8"`)
+			a.apiClientError(w, http.StatusUnauthorized)
+		} else if errors.Is(err, models.ErrInactiveUser) {
+			a.apiError(w, http.StatusForbidden, "account deactivated")
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	if needsCode {
+		code := r.Header.Get(apiOTPHeader)
+		if code == "" {
+			a.apiError(w, http.StatusUnauthorized, "two-factor code required")
+			return
+		}
+		retryAfter, err := a.checkSecondFactor(r, claims.Subject, code)
+		if err != nil {
+			if errors.Is(err, models.ErrRateLimited) {
+				setRetryAfter(w, retryAfter)
+				a.apiError(w, http.StatusTooManyRequests, "too many failed login attempts")
+			} else if errors.Is(err, models.ErrInvalidCode) {
+				a.apiError(w, http.StatusUnauthorized, "invalid two-factor code")
+			} else {
+				a.apiServerError(w, r, err)
+			}
+			return
+		}
+	}
+
+	tkn, err := a.auth.GenerateToken(claims)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+	a.recordAudit(r, models.AuditLogin, claims.Subject, claims.Subject, map[string]string{"via": "api"})
+
+	// tokens must not be stored by intermediaries
+	w.Header().Set("Cache-Control", "no-store")
+	a.writeJSON(w, r, http.StatusOK, apiTokenResponse{Token: tkn, ExpiresAt: claims.ExpiresAt.Time})
+}
+
+// apiSnippetList responds with a page of the snippets selected by the query
+// parameters, see snippetListForm.
+func (a *app) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	var form snippetListForm
+
+	err := a.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		a.apiClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	f := a.snippetFilter(r, &form)
+	if !form.Valid() {
+		a.apiValidationError(w, form.FieldErrors)
+		return
+	}
+
+	p, err := a.snippets.List(r.Context(), f)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			a.apiError(w, http.StatusBadRequest, "invalid cursor")
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	page := apiSnippetPage{Snippets: p.Snippets, Next: p.Next, Prev: p.Prev}
+	if page.Snippets == nil {
+		page.Snippets = []models.Snippet{}
+	}
+
+	a.writeJSON(w, r, http.StatusOK, page)
+}
+
+// apiSnippetView responds with the snippet identified by the id path value.
+func (a *app) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.apiViewableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	a.writeJSON(w, r, http.StatusOK, s)
+}
+
+// apiSnippetCreate creates a snippet owned by the authenticated user. The
+// language is detected when it is empty or "auto" and snippets expire after
+// a year unless date_expires is given. Snippets are public by default, or
+// unlisted if the user has not verified their email address. The owner_id is
+// ignored.
+func (a *app) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var ns models.NewSnippet
+	if !a.readJSON(w, r, &ns) {
+		return
+	}
+
+	verified, err := a.isVerified(r)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	if ns.Visibility == "" {
+		ns.Visibility = defaultVisibility(verified)
+	}
+	if ns.DateExpires.IsZero() {
+		ns.DateExpires = now.AddDate(1, 0, 0)
+	}
+	ns.Tags = models.NormalizeTags(strings.Join(ns.Tags, ","))
+
+	var v validator.Validator
+	checkSnippet(&v, ns.Title, ns.Content, ns.Visibility, ns.Language, ns.Tags, ns.DateExpires, now)
+	v.CheckField(mayPublish(verified, ns.Visibility, ""), "visibility", unverifiedPublishError)
+	if !v.Valid() {
+		a.apiValidationError(w, v.FieldErrors)
+		return
+	}
+
+	if ns.Language == "" || ns.Language == languageAuto {
+		ns.Language = highlight.Detect(ns.Title, ns.Content)
+	}
+	ns.OwnerID = a.authenticatedUserID(r)
+
+	s, err := a.snippets.Create(r.Context(), ns, now)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/snippets/%s", s.ID))
+	a.writeJSON(w, r, http.StatusCreated, s)
+}
+
+// apiSnippetUpdate changes the fields of the snippet given in the request
+// body and responds with the updated snippet.
+func (a *app) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.apiModifiableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var up models.UpdateSnippet
+	if !a.readJSON(w, r, &up) {
+		return
+	}
+
+	// validate the snippet as it will be after the update
+	upd := *s
+	if up.Title != nil {
+		upd.Title = *up.Title
+	}
+	if up.Content != nil {
+		upd.Content = *up.Content
+	}
+	if up.Visibility != nil {
+		upd.Visibility = *up.Visibility
+	}
+	if up.Language != nil {
+		upd.Language = *up.Language
+	}
+	if up.Tags != nil {
+		tags := models.NormalizeTags(strings.Join(*up.Tags, ","))
+		up.Tags = &tags
+		upd.Tags = tags
+	}
+	if up.DateExpires != nil {
+		upd.DateExpires = *up.DateExpires
+	}
+
+	verified, err := a.isVerified(r)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	var v validator.Validator
+	checkSnippet(&v, upd.Title, upd.Content, upd.Visibility, upd.Language, upd.Tags, upd.DateExpires, now)
+	v.CheckField(mayPublish(verified, upd.Visibility, s.Visibility), "visibility", unverifiedPublishError)
+	if !v.Valid() {
+		a.apiValidationError(w, v.FieldErrors)
+		return
+	}
+
+	if upd.Language == "" || upd.Language == languageAuto {
+		upd.Language = highlight.Detect(upd.Title, upd.Content)
+		up.Language = &upd.Language
+	}
+
+	err = a.snippets.Update(r.Context(), s.ID, up, a.authenticatedUserID(r), now)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	upd.DateUpdated = now
+	a.writeJSON(w, r, http.StatusOK, upd)
+}
+
+// apiSnippetDelete deletes the snippet identified by the id path value.
+func (a *app) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.apiModifiableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.snippets.Delete(r.Context(), s.ID); err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+	a.recordSnippetDelete(r, s)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkSnippet validates the fields of a snippet submitted to the API. The
+// language may be empty or "auto" to have it detected.
+func checkSnippet(v *validator.Validator, title, content, visibility, language string, tags []string, expires, now time.Time) {
+	v.CheckField(validator.NotBlank(title), "title", "This field cannot be blank")
+	v.CheckField(validator.MaxChars(title, 100), "title", "This field cannot be more than 100 characters long")
+	v.CheckField(validator.NotBlank(content), "content", "This field cannot be blank")
+	v.CheckField(validator.PermittedValue(visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must equal public, unlisted or private")
+	v.CheckField(language == "" || language == languageAuto || highlight.Supported(language), "language", "This field must be a supported language")
+	v.CheckField(expires.After(now) && !expires.After(now.AddDate(1, 0, 0)), "date_expires", "This field must be a time within the next year")
+	checkTags(v, tags)
+}
+
+// apiViewableSnippet is the API variant of viewableSnippet.
+func (a *app) apiViewableSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := a.apiRetrieveSnippet(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	canView, err := a.canView(r, s)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return nil, false
+	}
+	if !canView {
+		// do not reveal the existence of private snippets
+		a.apiNotFound(w)
+		return nil, false
+	}
+	if !s.DateExpires.After(time.Now()) {
+		a.apiClientError(w, http.StatusGone)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// apiModifiableSnippet is the API variant of modifiableSnippet.
+func (a *app) apiModifiableSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := a.apiRetrieveSnippet(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	canModify, err := a.canModify(r, s)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return nil, false
+	}
+	if !canModify {
+		canView, err := a.canView(r, s)
+		if err != nil {
+			a.apiServerError(w, r, err)
+		} else if canView {
+			a.apiClientError(w, http.StatusForbidden)
+		} else {
+			a.apiNotFound(w)
+		}
+		return nil, false
+	}
+
+	return s, true
+}
+
+// apiRetrieveSnippet retrieves the snippet identified by the id path value.
+func (a *app) apiRetrieveSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, err := a.snippets.Retrieve(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrInvalidID) {
+			a.apiNotFound(w)
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return s, true
+}
+
+// readJSON decodes the request body holding a single JSON object into dst.
+// Unknown fields and bodies larger than apiMaxBodyBytes are rejected. If
+// decoding fails the error response has already been written when ok is
+// false.
+func (a *app) readJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		// the body must only contain a single JSON value
+		if err = dec.Decode(&struct{}{}); err == io.EOF {
+			return true
+		}
+		err = errors.New("body must only contain a single JSON object")
+	}
+
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		a.apiError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit))
+	case errors.As(err, &syntaxError):
+		a.apiError(w, http.StatusBadRequest, fmt.Sprintf("body contains malformed JSON at offset %d", syntaxError.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		a.apiError(w, http.StatusBadRequest, "body contains malformed JSON")
+	case errors.As(err, &typeError):
+		a.apiError(w, http.StatusBadRequest, fmt.Sprintf("body contains an invalid value for the field %q", typeError.Field))
+	case errors.Is(err, io.EOF):
+		a.apiError(w, http.StatusBadRequest, "body must not be empty")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		a.apiError(w, http.StatusBadRequest, "body contains the unknown field "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		a.apiError(w, http.StatusBadRequest, err.Error())
+	}
+
+	return false
+}
+
+// writeJSON writes v as the JSON response body.
+func (a *app) writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// apiError writes an error envelope with the given status and message.
+func (a *app) apiError(w http.ResponseWriter, status int, message string) {
+	a.writeError(w, status, apiError{Error: message})
+}
+
+// apiClientError writes an error envelope holding the status text.
+func (a *app) apiClientError(w http.ResponseWriter, status int) {
+	a.apiError(w, status, strings.ToLower(http.StatusText(status)))
+}
+
+// apiUnauthorized asks the client to authenticate with a bearer token.
+func (a *app) apiUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="snptx"`)
+	a.apiClientError(w, http.StatusUnauthorized)
+}
+
+func (a *app) apiNotFound(w http.ResponseWriter) {
+	a.apiClientError(w, http.StatusNotFound)
+}
+
+// apiValidationError writes an error envelope holding the field errors.
+func (a *app) apiValidationError(w http.ResponseWriter, fields map[string]string) {
+	a.writeError(w, http.StatusUnprocessableEntity, apiError{Error: "validation failed", Fields: fields})
+}
+
+// apiServerError logs err and writes an error envelope. The details of the
+// error are only revealed in debug mode.
+func (a *app) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	a.log.Output(2, err.Error())
+
+	msg := "internal server error"
+	if a.debug {
+		msg = err.Error()
+	}
+	a.apiError(w, http.StatusInternalServerError, msg)
+}
+
+func (a *app) writeError(w http.ResponseWriter, status int, e apiError) {
+	js, _ := json.Marshal(e)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tullo/snptx/internal/assert"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/auth"
+)
+
+func TestAPISnippetList(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.apiRequest(t, http.MethodGet, "/api/v1/snippets?limit=1", "", "")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+	var page apiSnippetPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(page.Snippets), 1)
+	assert.Equal(t, page.Snippets[0].Title, "An old silent pond")
+	assert.Equal(t, page.Next, "page2")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{"Invalid Cursor", "/api/v1/snippets?cursor=bogus", http.StatusBadRequest, `{"error":"invalid cursor"}`},
+		{"Invalid Sort", "/api/v1/snippets?sort=size", http.StatusUnprocessableEntity, `"sort":"This field must equal created, updated, title or expires"`},
+		{"Invalid Limit", "/api/v1/snippets?limit=1000", http.StatusUnprocessableEntity, `"limit":"This field must be between 0 and 100"`},
+		{"Invalid Owner", "/api/v1/snippets?owner=abc", http.StatusUnprocessableEntity, `"owner":"This field must be a valid ID"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.apiRequest(t, http.MethodGet, tt.urlPath, "", "")
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetView(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{"Valid ID", "/api/v1/snippets/1", http.StatusOK, `"content":"An old silent pond..."`},
+		{"Private ID", "/api/v1/snippets/4", http.StatusNotFound, `{"error":"not found"}`},
+		{"Expired ID", "/api/v1/snippets/6", http.StatusGone, `{"error":"gone"}`},
+		{"Non-existent ID", "/api/v1/snippets/2", http.StatusNotFound, `{"error":"not found"}`},
+		{"Server Error", "/api/v1/snippets/66", http.StatusInternalServerError, `{"error":"internal server error"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.apiRequest(t, http.MethodGet, tt.urlPath, "", "")
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetCreate(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	alice := testToken(t, app, "1", auth.RoleUser)
+
+	t.Run("Valid", func(t *testing.T) {
+		code, header, body := ts.apiRequest(t, http.MethodPost, "/api/v1/snippets", alice,
+			`{"title":"main.go","content":"package main","tags":["Go","CLI tools"]}`)
+
+		assert.Equal(t, code, http.StatusCreated)
+		assert.Equal(t, header.Get("Location"), "/api/v1/snippets/2")
+
+		var s models.Snippet
+		if err := json.Unmarshal(body, &s); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, s.OwnerID, "1")
+		assert.Equal(t, s.Language, "go")
+		assert.Equal(t, s.Visibility, models.VisibilityPublic)
+		assert.Equal(t, strings.Join(s.Tags, ","), "cli-tools,go")
+		assert.Equal(t, s.DateExpires.After(time.Now().AddDate(0, 11, 0)), true)
+	})
+
+	erin := testToken(t, app, "12", auth.RoleUser)
+
+	t.Run("Unverified", func(t *testing.T) {
+		code, _, body := ts.apiRequest(t, http.MethodPost, "/api/v1/snippets", erin,
+			`{"title":"main.go","content":"package main"}`)
+
+		assert.Equal(t, code, http.StatusCreated)
+
+		var s models.Snippet
+		if err := json.Unmarshal(body, &s); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, s.Visibility, models.VisibilityUnlisted)
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{"Anonymous", `{"title":"a","content":"b"}`, "", http.StatusUnauthorized, `{"error":"unauthorized"}`},
+		{"Blank Title", `{"title":"","content":"b"}`, alice, http.StatusUnprocessableEntity, `"title":"This field cannot be blank"`},
+		{"Invalid Visibility", `{"title":"a","content":"b","visibility":"secret"}`, alice, http.StatusUnprocessableEntity, `"visibility":"This field must equal public, unlisted or private"`},
+		{"Unverified Public", `{"title":"a","content":"b","visibility":"public"}`, erin, http.StatusUnprocessableEntity, `"visibility":"Verify your email address to publish public snippets"`},
+		{"Expired", `{"title":"a","content":"b","date_expires":"2001-01-01T00:00:00Z"}`, alice, http.StatusUnprocessableEntity, `"date_expires":"This field must be a time within the next year"`},
+		{"Malformed JSON", `{"title":`, alice, http.StatusBadRequest, `{"error":"body contains malformed JSON"}`},
+		{"Unknown Field", `{"name":"a"}`, alice, http.StatusBadRequest, `{"error":"body contains the unknown field \"name\""}`},
+		{"Wrong Type", `{"title":1}`, alice, http.StatusBadRequest, `{"error":"body contains an invalid value for the field \"title\""}`},
+		{"Two Objects", `{"title":"a"}{}`, alice, http.StatusBadRequest, `{"error":"body must only contain a single JSON object"}`},
+		{"Empty Body", ``, alice, http.StatusBadRequest, `{"error":"body must not be empty"}`},
+		{"Too Large", `{"title":"a","content":"` + strings.Repeat("x", apiMaxBodyBytes) + `"}`, alice, http.StatusRequestEntityTooLarge, `{"error":"body must not be larger than 1048576 bytes"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.apiRequest(t, http.MethodPost, "/api/v1/snippets", tt.token, tt.body)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetUpdate(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	alice := testToken(t, app, "1", auth.RoleUser)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"Valid", "/api/v1/snippets/1", `{"title":"A frog jumps"}`, http.StatusOK, `"title":"A frog jumps","content":"An old silent pond..."`},
+		{"Blank Content", "/api/v1/snippets/1", `{"content":" "}`, http.StatusUnprocessableEntity, `"content":"This field cannot be blank"`},
+		{"Foreign", "/api/v1/snippets/3", `{"title":"mine"}`, http.StatusForbidden, `{"error":"forbidden"}`},
+		{"Private", "/api/v1/snippets/4", `{"title":"mine"}`, http.StatusNotFound, `{"error":"not found"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.apiRequest(t, http.MethodPatch, tt.urlPath, alice, tt.body)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetDelete(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	alice := testToken(t, app, "1", auth.RoleUser)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		token    string
+		wantCode int
+	}{
+		{"Owner", "/api/v1/snippets/1", alice, http.StatusNoContent},
+		{"Anonymous", "/api/v1/snippets/1", "", http.StatusUnauthorized},
+		{"Foreign", "/api/v1/snippets/5", alice, http.StatusForbidden},
+		{"Invalid ID", "/api/v1/snippets/foo", alice, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.apiRequest(t, http.MethodDelete, tt.urlPath, tt.token, "")
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	token := func(t *testing.T, email, password string) (int, http.Header, []byte) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(email, password)
+
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+
+		body, err := io.ReadAll(rs.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode, rs.Header, body
+	}
+
+	t.Run("Valid Credentials", func(t *testing.T) {
+		code, header, body := token(t, "alice@example.com", "validPa$$word")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Cache-Control"), "no-store")
+
+		var tr apiTokenResponse
+		if err := json.Unmarshal(body, &tr); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tr.ExpiresAt.After(time.Now()), true)
+
+		// the token authenticates API requests
+		code, _, _ = ts.apiRequest(t, http.MethodDelete, "/api/v1/snippets/1", tr.Token, "")
+		assert.Equal(t, code, http.StatusNoContent)
+	})
+
+	t.Run("Invalid Credentials", func(t *testing.T) {
+		code, header, body := token(t, "alice@example.com", "wrong")
+		assert.Equal(t, code, http.StatusUnauthorized)
+		assert.StringContains(t, header.Get("WWW-Authenticate"), "Basic")
+		assert.Equal(t, string(body), "{\"error\":\"unauthorized\"}\n")
+	})
+
+	t.Run("Deactivated", func(t *testing.T) {
+		code, _, body := token(t, "dave@example.com", "validPa$$word")
+		assert.Equal(t, code, http.StatusForbidden)
+		assert.Equal(t, string(body), "{\"error\":\"account deactivated\"}\n")
+	})
+
+	t.Run("Two-Factor", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			otp      string
+			wantCode int
+			wantBody string
+		}{
+			{"Missing Code", "", http.StatusUnauthorized, "{\"error\":\"two-factor code required\"}\n"},
+			{"Wrong Code", "654321", http.StatusUnauthorized, "{\"error\":\"invalid two-factor code\"}\n"},
+			{"Valid Code", "123456", http.StatusOK, ""},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/token", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.SetBasicAuth("gina@example.com", "validPa$$word")
+				if tt.otp != "" {
+					req.Header.Set(apiOTPHeader, tt.otp)
+				}
+
+				rs, err := ts.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rs.Body.Close()
+
+				body, err := io.ReadAll(rs.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, rs.StatusCode, tt.wantCode)
+				if tt.wantBody != "" {
+					assert.Equal(t, string(body), tt.wantBody)
+				}
+			})
+		}
+	})
+}
+
+func TestAPITokenLockout(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	token := func(t *testing.T, email, password, otp string) (int, http.Header, []byte) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(email, password)
+		if otp != "" {
+			req.Header.Set(apiOTPHeader, otp)
+		}
+
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+
+		body, err := io.ReadAll(rs.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode, rs.Header, body
+	}
+
+	for range 5 {
+		code, _, _ := token(t, "alice@example.com", "FooBarBaz", "")
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	code, header, body := token(t, "alice@example.com", "validPa$$word", "")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "60")
+	assert.Equal(t, string(body), "{\"error\":\"too many failed login attempts\"}\n")
+
+	// wrong two-factor codes lock the account too, the correct password
+	// does not reset their count
+	for range 5 {
+		code, _, _ := token(t, "gina@example.com", "validPa$$word", "654321")
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	code, header, _ = token(t, "gina@example.com", "validPa$$word", "123456")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "60")
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	expired, err := app.auth.GenerateToken(auth.NewClaims("1", []string{auth.RoleUser}, time.Now().Add(-2*time.Hour), time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		authz    string
+		wantCode int
+	}{
+		{"Valid", "Bearer " + testToken(t, app, "1", auth.RoleUser), http.StatusNoContent},
+		{"Lowercase Scheme", "bearer " + testToken(t, app, "1", auth.RoleUser), http.StatusNoContent},
+		{"Basic", "Basic YWxpY2VAZXhhbXBsZS5jb206dmFsaWRQYSQkd29yZA==", http.StatusUnauthorized},
+		{"Malformed", "Bearer", http.StatusUnauthorized},
+		{"Garbage", "Bearer not.a.token", http.StatusUnauthorized},
+		{"Expired", "Bearer " + expired, http.StatusUnauthorized},
+		{"Deactivated", "Bearer " + testToken(t, app, "4", auth.RoleUser), http.StatusUnauthorized},
+		{"Unknown User", "Bearer " + testToken(t, app, "5", auth.RoleUser), http.StatusUnauthorized},
+		// admins may delete the snippets of other users
+		{"Admin", "Bearer " + testToken(t, app, "9", auth.RoleAdmin), http.StatusNoContent},
+		{"Access Token", "Bearer snptx_pat_valid", http.StatusNoContent},
+		{"Unknown Access Token", "Bearer snptx_pat_unknown", http.StatusUnauthorized},
+		{"Read-Only Access Token", "Bearer snptx_pat_readonly", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/snippets/1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", tt.authz)
+
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+			if tt.wantCode == http.StatusUnauthorized {
+				assert.Equal(t, rs.Header.Get("WWW-Authenticate"), `Bearer realm="snptx"`)
+			}
+		})
+	}
+}
+
+func TestAPIAccessTokenScopes(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		token    string
+		body     string
+		wantCode int
+	}{
+		{"Read", http.MethodGet, "/api/v1/snippets/1", "snptx_pat_readonly", "", http.StatusOK},
+		{"List", http.MethodGet, "/api/v1/snippets", "snptx_pat_readonly", "", http.StatusOK},
+		{"Create", http.MethodPost, "/api/v1/snippets", "snptx_pat_readonly", `{"title":"t","content":"c"}`, http.StatusForbidden},
+		{"Update", http.MethodPatch, "/api/v1/snippets/1", "snptx_pat_readonly", `{"title":"t"}`, http.StatusForbidden},
+		{"Create Write", http.MethodPost, "/api/v1/snippets", "snptx_pat_valid", `{"title":"t","content":"c"}`, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.apiRequest(t, tt.method, tt.urlPath, tt.token, tt.body)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
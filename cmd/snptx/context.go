@@ -4,3 +4,10 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+// userRolesContextKey holds the roles of the user authenticated by the session.
+const userRolesContextKey = contextKey("userRoles")
+
+// isVerifiedContextKey tells whether the user authenticated by the session
+// has verified their email address.
+const isVerifiedContextKey = contextKey("isVerified")
@@ -1,98 +1,403 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/auth"
+	"github.com/tullo/snptx/internal/platform/diff"
+	"github.com/tullo/snptx/internal/platform/highlight"
+	"github.com/tullo/snptx/internal/platform/mail"
+	"github.com/tullo/snptx/internal/platform/verify"
 	"github.com/tullo/snptx/internal/validator"
 )
 
 func (a *app) home(w http.ResponseWriter, r *http.Request) {
-	ss, err := a.snippets.Latest(r.Context())
+	a.listSnippets(w, r, snippetListForm{Sort: models.SortCreated})
+}
+
+// maxPageSize is the largest number of snippets listed per page.
+const maxPageSize = 100
+
+type snippetListForm struct {
+	Sort                string `form:"sort"`
+	Owner               string `form:"owner"`
+	Tag                 string `form:"tag"`
+	From                string `form:"from"`
+	To                  string `form:"to"`
+	Expired             bool   `form:"expired"`
+	Cursor              string `form:"cursor"`
+	Limit               int    `form:"limit"`
+	validator.Validator `form:"-"`
+}
+
+// snippetList renders a page of snippets selected by the query parameters.
+func (a *app) snippetList(w http.ResponseWriter, r *http.Request) {
+	var form snippetListForm
+
+	err := a.formDecoder.Decode(&form, r.URL.Query())
 	if err != nil {
-		a.serverError(w, r, err)
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	a.listSnippets(w, r, form)
+}
+
+func (a *app) listSnippets(w http.ResponseWriter, r *http.Request, form snippetListForm) {
+	f := a.snippetFilter(r, &form)
+	if !form.Valid() {
+		data := a.newTemplateData(r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "home.tmpl", data)
+		return
+	}
+
+	p, err := a.snippets.List(r.Context(), f)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			a.clientError(w, http.StatusBadRequest)
+		} else {
+			a.serverError(w, r, err)
+		}
 		return
 	}
 
+	// page links keep the filter and only replace the cursor
+	pageURL := func(cursor string) string {
+		q := r.URL.Query()
+		q.Set("cursor", cursor)
+		if form.Tag != "" {
+			q.Set("tag", form.Tag)
+		}
+		return "/snippets?" + q.Encode()
+	}
+
 	data := a.newTemplateData(r)
-	data.Snippets = ss
+	data.Form = form
+	data.Snippets = p.Snippets
+	if p.Next != "" {
+		data.NextPage = pageURL(p.Next)
+	}
+	if p.Prev != "" {
+		data.PrevPage = pageURL(p.Prev)
+	}
 
 	a.render(w, r, http.StatusOK, "home.tmpl", data)
 }
 
+// snippetFilter validates the list form and returns the filter it selects.
+// The filter is only meaningful if the form is valid afterwards.
+func (a *app) snippetFilter(r *http.Request, form *snippetListForm) models.SnippetFilter {
+	form.CheckField(validator.PermittedValue(form.Sort, "", models.SortCreated, models.SortUpdated, models.SortTitle, models.SortExpires), "sort", "This field must equal created, updated, title or expires")
+	form.CheckField(form.From == "" || validator.IsDate(form.From), "from", "This field must be a valid date")
+	form.CheckField(form.To == "" || validator.IsDate(form.To), "to", "This field must be a valid date")
+	form.CheckField(form.Tag == "" || validator.Matches(form.Tag, validator.TagRX), "tag", "This field must be a valid tag")
+	form.CheckField(form.Owner == "" || validator.IsUUID(form.Owner), "owner", "This field must be a valid ID")
+	form.CheckField(form.Limit >= 0 && form.Limit <= maxPageSize, "limit", fmt.Sprintf("This field must be between 0 and %d", maxPageSize))
+
+	f := models.SnippetFilter{
+		ViewerID:       a.authenticatedUserID(r),
+		OwnerID:        form.Owner,
+		Tag:            form.Tag,
+		IncludeExpired: form.Expired,
+		Sort:           form.Sort,
+		Cursor:         form.Cursor,
+		Limit:          form.Limit,
+	}
+	if form.From != "" {
+		f.CreatedAfter, _ = time.ParseInLocation(validator.DateLayout, form.From, time.Local)
+	}
+	if form.To != "" {
+		// include the whole day
+		to, _ := time.ParseInLocation(validator.DateLayout, form.To, time.Local)
+		f.CreatedBefore = to.AddDate(0, 0, 1)
+	}
+
+	return f
+}
+
+// tagView renders the snippets tagged with the name path value.
+func (a *app) tagView(w http.ResponseWriter, r *http.Request) {
+	tag := models.NormalizeTag(r.PathValue("name"))
+	if !validator.Matches(tag, validator.TagRX) {
+		a.notFound(w)
+		return
+	}
+
+	a.listSnippets(w, r, snippetListForm{Sort: models.SortCreated, Tag: tag})
+}
+
+// tagSuggestions responds with a JSON array of the tags starting with the
+// q query parameter. It backs the autocompletion of the tag inputs.
+func (a *app) tagSuggestions(w http.ResponseWriter, r *http.Request) {
+	var tags []string
+
+	prefix := models.NormalizeTag(r.URL.Query().Get("q"))
+	if prefix != "" {
+		var err error
+		tags, err = a.snippets.SuggestTags(r.Context(), a.authenticatedUserID(r), prefix)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+	}
+	if tags == nil {
+		// encode an empty array rather than null
+		tags = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		a.serverError(w, r, err)
+	}
+}
+
+type searchForm struct {
+	Query               string `form:"q"`
+	validator.Validator `form:"-"`
+}
+
+// snippetSearch renders the snippets matching the search query.
+func (a *app) snippetSearch(w http.ResponseWriter, r *http.Request) {
+	var form searchForm
+
+	err := a.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.MaxChars(form.Query, 200), "q", "This field cannot be more than 200 characters long")
+
+	data := a.newTemplateData(r)
+	data.Form = form
+
+	if !form.Valid() {
+		a.render(w, r, http.StatusUnprocessableEntity, "search.tmpl", data)
+		return
+	}
+
+	if validator.NotBlank(form.Query) {
+		data.SearchResults, err = a.snippets.Search(r.Context(), models.SnippetSearch{
+			ViewerID: a.authenticatedUserID(r),
+			Query:    form.Query,
+		})
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	a.render(w, r, http.StatusOK, "search.tmpl", data)
+}
+
 func (a *app) about(w http.ResponseWriter, r *http.Request) {
 	data := a.newTemplateData(r)
 	a.render(w, r, http.StatusOK, "about.tmpl", data)
 }
 
 func (a *app) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
-	//id := r.URL.Query().Get(":id")
-	id := r.PathValue("id")
-	err := a.snippets.Delete(r.Context(), id)
+	s, ok := a.modifiableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.snippets.Delete(r.Context(), s.ID)
 	if err != nil {
 		a.serverError(w, r, err)
 		return
 	}
+	a.recordSnippetDelete(r, s)
 	a.sessionManager.Put(r.Context(), "flash", "Snippet successfully deleted!")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (a *app) snippetView(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	canModify, err := a.canModify(r, s)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(r)
+	data.Snippet = s
+	data.CanModify = canModify
+
+	// markdown is rendered unless the source is requested
+	data.Markdown = s.Language == languageMarkdown && r.URL.Query().Get("source") == ""
+	if data.Markdown {
+		data.Rendered, err = a.markdown.Render(s.Content)
+	} else {
+		data.Rendered, err = a.highlighter.HTML(s.Content, s.Language)
+	}
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.render(w, r, http.StatusOK, "view.tmpl", data)
+}
+
+// snippetRaw serves the content of a snippet as plain text.
+func (a *app) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	a.serveSnippetContent(w, r, s)
+}
+
+// snippetDownload serves the content of a snippet as an attachment named
+// after its title and language.
+func (a *app) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	cd := mime.FormatMediaType("attachment", map[string]string{"filename": snippetFilename(s)})
+	w.Header().Set("Content-Disposition", cd)
+
+	a.serveSnippetContent(w, r, s)
+}
+
+// serveSnippetContent writes the content of a snippet as plain text. The
+// ETag and Last-Modified headers allow clients to revalidate cheaply,
+// conditional and range requests are handled by http.ServeContent.
+func (a *app) serveSnippetContent(w http.ResponseWriter, r *http.Request, s *models.Snippet) {
+	sum := sha256.Sum256([]byte(s.Content))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+	if s.Visibility == models.VisibilityPublic {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	http.ServeContent(w, r, "", s.DateUpdated, strings.NewReader(s.Content))
+}
+
+// highlightCSS serves the style sheet of highlighted snippets.
+func (a *app) highlightCSS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if err := a.highlighter.CSS(w); err != nil {
+		a.serverError(w, r, err)
+	}
+}
+
+// viewableSnippet retrieves the snippet identified by the id path value and
+// checks that the user is allowed to read it. Expired snippets are gone, even
+// if they have not been purged yet. If not, the error response has already
+// been written when ok is false.
+func (a *app) viewableSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
 	// pat does not strip the colon from the named capture key,
 	// get the value of ":id" from the query string instead of "id"
 	//id := r.URL.Query().Get(":id")
-	id := r.PathValue("id")
-	s, err := a.snippets.Retrieve(r.Context(), id)
+	s, err := a.snippets.Retrieve(r.Context(), r.PathValue("id"))
 	if err != nil {
 		// unwrapping errors
-		if errors.Is(err, models.ErrNoRecord) {
+		if errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrInvalidID) {
 			a.notFound(w)
 		} else {
 			a.serverError(w, r, err)
 		}
-		return
+		return nil, false
 	}
 
-	data := a.newTemplateData(r)
-	data.Snippet = s
+	canView, err := a.canView(r, s)
+	if err != nil {
+		a.serverError(w, r, err)
+		return nil, false
+	}
+	if !canView {
+		// do not reveal the existence of private snippets
+		a.notFound(w)
+		return nil, false
+	}
+	if !s.DateExpires.After(time.Now()) {
+		a.clientError(w, http.StatusGone)
+		return nil, false
+	}
 
-	a.render(w, r, http.StatusOK, "view.tmpl", data)
+	return s, true
+}
+
+// modifiableSnippet retrieves the snippet identified by the id path value and
+// checks that the authenticated user is allowed to change it. If not, the
+// error response has already been written when ok is false.
+func (a *app) modifiableSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, err := a.snippets.Retrieve(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrInvalidID) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return nil, false
+	}
+
+	canModify, err := a.canModify(r, s)
+	if err != nil {
+		a.serverError(w, r, err)
+		return nil, false
+	}
+	if !canModify {
+		a.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+
+	return s, true
 }
 
 type snippetEditForm struct {
 	Title               string `form:"title"`
 	Content             string `form:"content"`
+	Visibility          string `form:"visibility"`
+	Language            string `form:"language"`
+	Tags                string `form:"tags"`
 	validator.Validator `form:"-"`
 }
 
 func (a *app) updateSnippetForm(w http.ResponseWriter, r *http.Request) {
-	//id := r.URL.Query().Get(":id")
-	id := r.PathValue("id")
-	s, err := a.snippets.Retrieve(r.Context(), id)
-	if err != nil {
-		// unwrapping errors
-		if errors.Is(err, models.ErrNoRecord) {
-			a.notFound(w)
-		} else {
-			a.serverError(w, r, err)
-		}
+	s, ok := a.modifiableSnippet(w, r)
+	if !ok {
 		return
 	}
 
 	data := a.newTemplateData(r)
 	data.Snippet = s
 	data.Form = snippetEditForm{
-		Title:   s.Title,
-		Content: s.Content,
+		Title:      s.Title,
+		Content:    s.Content,
+		Visibility: s.Visibility,
+		Language:   s.Language,
+		Tags:       strings.Join(s.Tags, ", "),
 	}
 
 	a.render(w, r, http.StatusOK, "edit.tmpl", data)
 }
 
 func (a *app) updateSnippetPost(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.modifiableSnippet(w, r)
+	if !ok {
+		return
+	}
 
 	var form snippetEditForm
 
@@ -105,43 +410,223 @@ func (a *app) updateSnippetPost(w http.ResponseWriter, r *http.Request) {
 	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
 	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
 	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validator.PermittedValue(form.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must equal public, unlisted or private")
+	form.CheckField(form.Language == languageAuto || highlight.Supported(form.Language), "language", "This field must be a supported language")
+	tags := models.NormalizeTags(form.Tags)
+	checkTags(&form.Validator, tags)
+
+	verified, err := a.isVerified(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	form.CheckField(mayPublish(verified, form.Visibility, s.Visibility), "visibility", unverifiedPublishError)
 
 	if !form.Valid() {
 		data := a.newTemplateData(r)
+		data.Snippet = s
 		data.Form = form
 		a.render(w, r, http.StatusUnprocessableEntity, "edit.tmpl", data)
 		return
 	}
 
+	if form.Language == languageAuto {
+		form.Language = highlight.Detect(form.Title, form.Content)
+	}
+
 	// update snippet record in the database using the form data
 	up := models.UpdateSnippet{
-		Title:   &form.Title,
-		Content: &form.Content,
+		Title:      &form.Title,
+		Content:    &form.Content,
+		Visibility: &form.Visibility,
+		Language:   &form.Language,
+		Tags:       &tags,
 	}
 
-	id := r.PathValue("id")
-	err = a.snippets.Update(r.Context(), id, up, time.Now().Local())
+	userID := a.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	err = a.snippets.Update(r.Context(), s.ID, up, userID, time.Now().Local())
 	if err != nil {
 		a.serverError(w, r, err)
 		return
 	}
 	a.sessionManager.Put(r.Context(), "flash", "Snippet successfully updated!")
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", s.ID), http.StatusSeeOther)
+}
+
+func (a *app) snippetRevisions(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	revs, err := a.snippets.Revisions(r.Context(), s.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	canModify, err := a.canModify(r, s)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(r)
+	data.Snippet = s
+	data.Revisions = revs
+	data.CanModify = canModify
+
+	a.render(w, r, http.StatusOK, "revisions.tmpl", data)
+}
+
+// snippetDiff renders the changes between the revisions given by the from
+// and to query parameters. Without parameters the latest revision is compared
+// with its predecessor.
+func (a *app) snippetDiff(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// revisions are ordered newest first
+	revs, err := a.snippets.Revisions(r.Context(), s.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if len(revs) == 0 {
+		a.notFound(w)
+		return
+	}
+
+	find := func(id string) int {
+		for i, rev := range revs {
+			if rev.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	to := 0
+	if id := r.URL.Query().Get("to"); id != "" {
+		to = find(id)
+	}
+	from := min(to+1, len(revs)-1)
+	if id := r.URL.Query().Get("from"); id != "" {
+		from = find(id)
+	}
+	if to < 0 || from < 0 {
+		a.notFound(w)
+		return
+	}
+
+	data := a.newTemplateData(r)
+	data.Snippet = s
+	data.DiffFrom = &revs[from]
+	data.DiffTo = &revs[to]
+	data.Diff = diff.Unified(revs[from].Content, revs[to].Content, 3)
+
+	a.render(w, r, http.StatusOK, "diff.tmpl", data)
+}
+
+type snippetRestoreForm struct {
+	Revision string `form:"revision"`
+}
+
+// snippetRestorePost restores the content of an earlier revision. The restored
+// content is recorded as a new revision, the history is never rewritten.
+func (a *app) snippetRestorePost(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.modifiableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var form snippetRestoreForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	rev, err := a.snippets.Revision(r.Context(), s.ID, form.Revision)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrInvalidID) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	up := models.UpdateSnippet{
+		Title:   &rev.Title,
+		Content: &rev.Content,
+	}
+
+	userID := a.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	err = a.snippets.Update(r.Context(), s.ID, up, userID, time.Now().Local())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.sessionManager.Put(r.Context(), "flash", "Snippet revision successfully restored!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", s.ID), http.StatusSeeOther)
+}
+
+// languageAuto is the language option which detects the language of a
+// snippet from its title and content.
+const languageAuto = "auto"
+
+// languageMarkdown is the language of snippets rendered as Markdown.
+const languageMarkdown = "markdown"
+
+// unverifiedPublishError is the validation error of users who have not
+// verified their email address trying to publish a snippet.
+const unverifiedPublishError = "Verify your email address to publish public snippets"
+
+// defaultVisibility is the visibility preselected for new snippets.
+func defaultVisibility(verified bool) string {
+	if verified {
+		return models.VisibilityPublic
+	}
+	return models.VisibilityUnlisted
+}
+
+// checkTags validates normalized tags entered into the tags field.
+func checkTags(v *validator.Validator, tags []string) {
+	v.CheckField(len(tags) <= models.MaxTags, "tags", fmt.Sprintf("This field cannot contain more than %d tags", models.MaxTags))
+	for _, t := range tags {
+		v.CheckField(validator.MaxChars(t, models.MaxTagLength), "tags", fmt.Sprintf("Tags cannot be more than %d characters long", models.MaxTagLength))
+		v.CheckField(validator.Matches(t, validator.TagRX), "tags", "Tags may only contain letters, digits, '.', '_', '+' and '-'")
+	}
 }
 
 type snippetCreateForm struct {
 	Title               string `form:"title"`
 	Content             string `form:"content"`
+	Visibility          string `form:"visibility"`
+	Language            string `form:"language"`
+	Tags                string `form:"tags"`
 	Expires             int    `form:"expires"`
 	validator.Validator `form:"-"`
 }
 
 func (a *app) snippetCreateForm(w http.ResponseWriter, r *http.Request) {
+	verified, err := a.isVerified(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
 	// render the form using an empty forms.Form struct
 	data := a.newTemplateData(r)
 
 	data.Form = snippetCreateForm{
-		Expires: 365,
+		Visibility: defaultVisibility(verified),
+		Language:   languageAuto,
+		Expires:    365,
 	}
 
 	a.render(w, r, http.StatusOK, "create.tmpl", data)
@@ -159,7 +644,18 @@ func (a *app) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
 	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
 	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
 	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validator.PermittedValue(form.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must equal public, unlisted or private")
 	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+	form.CheckField(form.Language == languageAuto || highlight.Supported(form.Language), "language", "This field must be a supported language")
+	tags := models.NormalizeTags(form.Tags)
+	checkTags(&form.Validator, tags)
+
+	verified, err := a.isVerified(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	form.CheckField(mayPublish(verified, form.Visibility, ""), "visibility", unverifiedPublishError)
 
 	if !form.Valid() {
 		data := a.newTemplateData(r)
@@ -178,9 +674,17 @@ func (a *app) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
 	case 1:
 		exp = now.AddDate(0, 0, 1)
 	}
+	if form.Language == languageAuto {
+		form.Language = highlight.Detect(form.Title, form.Content)
+	}
+
 	ns := models.NewSnippet{
+		OwnerID:     a.sessionManager.GetString(r.Context(), "authenticatedUserID"),
 		Title:       form.Title,
 		Content:     form.Content,
+		Visibility:  form.Visibility,
+		Language:    form.Language,
+		Tags:        tags,
 		DateExpires: exp,
 	}
 
@@ -238,7 +742,7 @@ func (a *app) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		Name:     form.Name,
 		Password: form.Password,
 	}
-	_, err = a.users.Create(r.Context(), nu, time.Now())
+	usr, err := a.users.Create(r.Context(), nu, time.Now())
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email address is already in use")
@@ -252,8 +756,14 @@ func (a *app) userSignupPost(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
+	a.recordAudit(r, models.AuditSignup, usr.ID, usr.ID, map[string]string{"email": usr.Email})
+
+	if err := a.sendVerification(r.Context(), usr); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
 
-	a.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
+	a.sessionManager.Put(r.Context(), "flash", "Your signup was successful. We have sent you a link to verify your email address. Please log in.")
 
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
@@ -294,10 +804,21 @@ func (a *app) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, err := a.users.Authenticate(r.Context(), time.Now(), form.Email, form.Password)
+	claims, needsCode, retryAfter, err := a.checkLogin(r, form.Email, form.Password)
 	if err != nil {
-		if errors.Is(err, models.ErrAuthenticationFailure) {
-			form.AddNonFieldError("Email or password is incorrect")
+		if errors.Is(err, models.ErrRateLimited) {
+			form.AddNonFieldError("Too many failed login attempts, please try again later")
+
+			data := a.newTemplateData(r)
+			data.Form = form
+			setRetryAfter(w, retryAfter)
+			a.render(w, r, http.StatusTooManyRequests, "login.tmpl", data)
+		} else if errors.Is(err, models.ErrAuthenticationFailure) || errors.Is(err, models.ErrInactiveUser) {
+			if errors.Is(err, models.ErrInactiveUser) {
+				form.AddNonFieldError("Your account has been deactivated")
+			} else {
+				form.AddNonFieldError("Email or password is incorrect")
+			}
 
 			data := a.newTemplateData(r)
 			data.Form = form
@@ -308,14 +829,28 @@ func (a *app) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.sessionManager.RenewToken(r.Context())
+	if needsCode {
+		a.startTwoFactor(w, r, claims.Subject, "web")
+		return
+	}
+
+	a.completeLogin(w, r, claims.Subject, "web")
+}
+
+// completeLogin authenticates the session as the user and redirects the
+// client to the requested path. via tells how the user logged in.
+func (a *app) completeLogin(w http.ResponseWriter, r *http.Request, userID, via string) {
+	err := a.sessionManager.RenewToken(r.Context())
 	if err != nil {
 		a.serverError(w, r, err)
 		return
 	}
 
 	// Add the ID of the current user to the session data (user loged in)
-	a.sessionManager.Put(r.Context(), "authenticatedUserID", claims.Subject)
+	a.sessionManager.Put(r.Context(), "authenticatedUserID", userID)
+	a.sessionManager.Put(r.Context(), models.SessionCreatedKey, time.Now().Unix())
+	a.touchSession(r)
+	a.recordAudit(r, models.AuditLogin, userID, userID, map[string]string{"via": via})
 
 	// pop the captured path from the session data
 	path := a.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
@@ -329,14 +864,38 @@ func (a *app) userLoginPost(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *app) logoutUserPost(w http.ResponseWriter, r *http.Request) {
+	// the session is no longer listed among the sessions of the user
+	err := a.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
 	// remove authenticatedUserID from the session data (user logged out)
 	a.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	for _, key := range []string{models.SessionCreatedKey, models.SessionLastSeenKey, models.SessionIPKey, models.SessionUserAgentKey} {
+		a.sessionManager.Remove(r.Context(), key)
+	}
 	// add flash message to the user session
 	a.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// profileAuditEvents is the number of recent audit events shown on the
+// profile page.
+const profileAuditEvents = 10
+
 func (a *app) userProfile(w http.ResponseWriter, r *http.Request) {
+	form := accessTokenForm{
+		Scopes:  []string{auth.ScopeSnippetsRead},
+		Expires: 90,
+	}
+	a.renderProfile(w, r, http.StatusOK, form, "")
+}
+
+// renderProfile renders the profile page of the authenticated user with the
+// access token form. A newly created token is shown this one time only.
+func (a *app) renderProfile(w http.ResponseWriter, r *http.Request, status int, form accessTokenForm, token string) {
 	// get user ID from session data
 	userID := a.sessionManager.GetString(r.Context(), "authenticatedUserID")
 
@@ -346,12 +905,146 @@ func (a *app) userProfile(w http.ResponseWriter, r *http.Request) {
 		a.serverError(w, r, err)
 		return
 	}
-	//fmt.Fprintf(w, "%+v", user)
+
+	tokens, err := a.tokens.List(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	sessions, err := a.sessions.List(r.Context(), userID, a.sessionManager.Token(r.Context()))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	events, err := a.audit.List(r.Context(), models.AuditFilter{UserID: userID, Limit: profileAuditEvents})
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
 
 	data := a.newTemplateData(r)
 	data.User = usr
+	data.AccessTokens = tokens
+	data.Sessions = sessions
+	data.AuditEvents = events
+	data.NewAccessToken = token
+	data.Form = form
+
+	if token != "" {
+		// the page holds the token in plain text
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	a.render(w, r, status, "profile.tmpl", data)
+}
+
+type accessTokenForm struct {
+	Name                string   `form:"name"`
+	Scopes              []string `form:"scopes"`
+	Expires             int      `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
+// accessTokenCreatePost creates a personal access token for the API. The
+// token is shown on the rendered profile page and cannot be retrieved again,
+// hence there is no redirect.
+func (a *app) accessTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form accessTokenForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(len(form.Scopes) > 0, "scopes", "Select at least one scope")
+	for _, s := range form.Scopes {
+		form.CheckField(validator.PermittedValue(s, auth.Scopes...), "scopes", "This field must equal snippets:read or snippets:write")
+	}
+	form.CheckField(validator.PermittedValue(form.Expires, 0, 30, 90, 365), "expires", "This field must equal 0, 30, 90 or 365")
+
+	if !form.Valid() {
+		a.renderProfile(w, r, http.StatusUnprocessableEntity, form, "")
+		return
+	}
+
+	now := time.Now()
+	nt := models.NewAccessToken{
+		UserID: a.sessionManager.GetString(r.Context(), "authenticatedUserID"),
+		Name:   form.Name,
+		Scopes: form.Scopes,
+	}
+	if form.Expires > 0 {
+		nt.DateExpires = now.AddDate(0, 0, form.Expires)
+	}
 
-	a.render(w, r, http.StatusOK, "profile.tmpl", data)
+	_, token, err := a.tokens.Create(r.Context(), nt, now)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.renderProfile(w, r, http.StatusOK, accessTokenForm{Scopes: form.Scopes, Expires: form.Expires}, token)
+}
+
+// accessTokenRevokePost deletes a personal access token of the user.
+func (a *app) accessTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	userID := a.sessionManager.GetString(r.Context(), "authenticatedUserID")
+
+	err := a.tokens.Revoke(r.Context(), userID, r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidID) || errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w)
+			return
+		}
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Access token revoked!")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// sessionRevokePost logs out one of the other sessions of the user, e.g. on
+// a lost device. The current session is ended by logging out.
+func (a *app) sessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	userID := a.sessionManager.GetString(r.Context(), "authenticatedUserID")
+
+	id := r.PathValue("id")
+	if id == models.SessionID(a.sessionManager.Token(r.Context())) {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err := a.sessions.Revoke(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidID) || errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w)
+			return
+		}
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Session logged out!")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// sessionRevokeOthersPost logs out all sessions of the user but the current one.
+func (a *app) sessionRevokeOthersPost(w http.ResponseWriter, r *http.Request) {
+	userID := a.sessionManager.GetString(r.Context(), "authenticatedUserID")
+
+	_, err := a.sessions.RevokeOthers(r.Context(), userID, a.sessionManager.Token(r.Context()))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Logged out of all other sessions!")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
 
 type changePasswordForm struct {
@@ -362,6 +1055,16 @@ type changePasswordForm struct {
 }
 
 func (a *app) changePasswordForm(w http.ResponseWriter, r *http.Request) {
+	usr, err := a.users.QueryByID(r.Context(), a.authenticatedUserID(r))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if usr.SSOOnly {
+		a.noPassword(w, r)
+		return
+	}
+
 	data := a.newTemplateData(r)
 	data.Form = changePasswordForm{}
 	a.render(w, r, http.StatusOK, "password.tmpl", data)
@@ -377,11 +1080,7 @@ func (a *app) changePasswordPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.NewPassword, 10), "newPassword", "This field must be at least 10 characters long")
-	form.CheckField(validator.MinChars(form.NewPasswordConfirmation, 10), "newPasswordConfirmation", "This field must be at least 10 characters long")
-	form.CheckField(validator.Equals(form.NewPassword, form.NewPasswordConfirmation), "newPassword", "This field must be equal to the new password confirmation")
+	checkNewPassword(&form.Validator, form.NewPassword, form.NewPasswordConfirmation)
 	form.CheckField(!validator.Equals(form.NewPassword, form.CurrentPassword), "newPassword", "This field cannot be equal to the current password")
 
 	if !form.Valid() {
@@ -397,20 +1096,247 @@ func (a *app) changePasswordPost(w http.ResponseWriter, r *http.Request) {
 	// persist the password to the database
 	err = a.users.ChangePassword(r.Context(), userID, form.CurrentPassword, form.NewPassword)
 	if err != nil {
+		if errors.Is(err, models.ErrNoPassword) {
+			a.noPassword(w, r)
+			return
+		}
 		if errors.Is(err, models.ErrInvalidCredentials) {
 			form.AddFieldError("currentPassword", "Current password is incorrect")
 
 			data := a.newTemplateData(r)
 			data.Form = form
 			a.render(w, r, http.StatusUnprocessableEntity, "password.tmpl", data)
+			return
 		}
 
 		a.serverError(w, r, err)
 		return
 	}
 
+	// whoever knew the old password is logged out
+	_, err = a.sessions.RevokeOthers(r.Context(), userID, a.sessionManager.Token(r.Context()))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.recordAudit(r, models.AuditPasswordChange, userID, userID, nil)
+
 	// add flash message to the session data
-	a.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+	a.sessionManager.Put(r.Context(), "flash", "Your password has been updated! Your other sessions have been logged out.")
 	// redirect browser to the users profile page
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
+
+// checkNewPassword applies the rules for choosing a new password.
+func checkNewPassword(v *validator.Validator, password, confirmation string) {
+	v.CheckField(validator.NotBlank(password), "newPassword", "This field cannot be blank")
+	v.CheckField(validator.NotBlank(confirmation), "newPasswordConfirmation", "This field cannot be blank")
+	v.CheckField(validator.MinChars(password, 10), "newPassword", "This field must be at least 10 characters long")
+	v.CheckField(validator.MinChars(confirmation, 10), "newPasswordConfirmation", "This field must be at least 10 characters long")
+	v.CheckField(validator.Equals(password, confirmation), "newPassword", "This field must be equal to the new password confirmation")
+}
+
+// passwordResetTTL is how long the link sent to reset a password is valid.
+const passwordResetTTL = time.Hour
+
+type forgotPasswordForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+func (a *app) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	data := a.newTemplateData(r)
+	data.Form = forgotPasswordForm{}
+	a.render(w, r, http.StatusOK, "forgot.tmpl", data)
+}
+
+// forgotPasswordPost mails a link to reset the password. The response is the
+// same whether or not there is an account for the email.
+func (a *app) forgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form forgotPasswordForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := a.newTemplateData(r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "forgot.tmpl", data)
+		return
+	}
+
+	token, usr, err := a.resets.Create(r.Context(), form.Email, time.Now(), passwordResetTTL)
+	switch {
+	case errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrInactiveUser):
+		// do not reveal whether the account exists
+	case errors.Is(err, models.ErrNoPassword):
+		a.sendMail(mail.Message{
+			To:      usr.Email,
+			Subject: "Your Snippetbox account has no password",
+			Body: fmt.Sprintf("Hi %s,\n\n"+
+				"somebody asked to reset the password of your Snippetbox account. "+
+				"Your account has no password, please log in with single sign-on:\n\n"+
+				"%s/user/login\n\n"+
+				"If you did not ask for it, you can ignore this email.\n",
+				usr.Name, a.baseURL),
+		})
+	case err != nil:
+		a.serverError(w, r, err)
+		return
+	default:
+		a.sendMail(mail.Message{
+			To:      usr.Email,
+			Subject: "Reset your Snippetbox password",
+			Body: fmt.Sprintf("Hi %s,\n\n"+
+				"somebody asked to reset the password of your Snippetbox account. "+
+				"If it was you, follow this link within the next hour to choose a new password:\n\n"+
+				"%s/user/reset-password/%s\n\n"+
+				"If you did not ask for it, you can ignore this email.\n",
+				usr.Name, a.baseURL, token),
+		})
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "If there is an account for this email, we have sent you a link to reset its password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+type resetPasswordForm struct {
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+func (a *app) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	err := a.resets.Check(r.Context(), token, time.Now())
+	if err != nil {
+		a.invalidResetToken(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(r)
+	data.Form = resetPasswordForm{}
+	data.Token = token
+	a.render(w, r, http.StatusOK, "reset.tmpl", data)
+}
+
+// resetPasswordPost sets the new password. All sessions of the user are
+// logged out, the user has to log in with the new password.
+func (a *app) resetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form resetPasswordForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	token := r.PathValue("token")
+	checkNewPassword(&form.Validator, form.NewPassword, form.NewPasswordConfirmation)
+
+	if !form.Valid() {
+		data := a.newTemplateData(r)
+		data.Form = form
+		data.Token = token
+		a.render(w, r, http.StatusUnprocessableEntity, "reset.tmpl", data)
+		return
+	}
+
+	userID, err := a.resets.Reset(r.Context(), token, form.NewPassword, time.Now())
+	if err != nil {
+		a.invalidResetToken(w, r, err)
+		return
+	}
+	a.recordAudit(r, models.AuditPasswordChange, userID, userID, map[string]string{"via": "reset"})
+
+	// the current session may be one of the deleted ones,
+	// it must not be stored again
+	a.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	if err := a.sessionManager.RenewToken(r.Context()); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Your password has been reset, please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// invalidResetToken sends the user back to ask for a new link if the reset
+// token cannot be used.
+func (a *app) invalidResetToken(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, models.ErrInvalidToken) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "This password reset link is invalid or has expired.")
+	http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+}
+
+// verificationTTL is how long the link sent to verify an email address is
+// valid.
+const verificationTTL = 48 * time.Hour
+
+// verificationInterval is how long users have to wait before they can ask
+// for another verification link.
+const verificationInterval = 5 * time.Minute
+
+// verifyEmail marks the email address in the signed link as verified. The
+// user does not need to be logged in.
+func (a *app) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	userID, email, err := a.verifier.Verify(r.PathValue("token"), now)
+	if err == nil {
+		err = a.users.VerifyEmail(r.Context(), userID, email, now)
+	}
+
+	switch {
+	case errors.Is(err, verify.ErrInvalidToken) || errors.Is(err, models.ErrInvalidToken) ||
+		errors.Is(err, models.ErrInvalidID) || errors.Is(err, models.ErrNoRecord):
+		a.sessionManager.Put(r.Context(), "flash", "This verification link is invalid or has expired.")
+	case err != nil:
+		a.serverError(w, r, err)
+		return
+	default:
+		a.sessionManager.Put(r.Context(), "flash", "Your email address has been verified!")
+	}
+
+	if a.isAuthenticated(r) {
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// verifyEmailResendPost sends another verification link to the authenticated
+// user, at most one every verificationInterval.
+func (a *app) verifyEmailResendPost(w http.ResponseWriter, r *http.Request) {
+	usr, err := a.users.QueryByID(r.Context(), a.authenticatedUserID(r))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	msg := "Your email address is verified already."
+	if !usr.Verified {
+		switch err := a.sendVerification(r.Context(), usr); {
+		case errors.Is(err, models.ErrRateLimited):
+			msg = "A verification link has been sent to you a moment ago, please check your inbox."
+		case err != nil:
+			a.serverError(w, r, err)
+			return
+		default:
+			msg = "We have sent you a new verification link."
+		}
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
@@ -2,11 +2,23 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"html"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/tullo/snptx/internal/assert"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/models/mock"
+	"github.com/tullo/snptx/internal/platform/mail"
+	"github.com/tullo/snptx/internal/platform/mail/smtptest"
 )
 
 func TestPing(t *testing.T) {
@@ -49,6 +61,40 @@ func TestShowSnippet(t *testing.T) {
 			wantCode: http.StatusOK,
 			wantBody: "An old silent pond...",
 		},
+		{
+			name:     "Unlisted ID",
+			urlPath:  "/snippet/view/3",
+			wantCode: http.StatusOK,
+			wantBody: "Over the wintry forest...",
+		},
+		{
+			name:     "Line Anchors",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: `<span class="ln" id="L1"><a class="lnlinks" href="#L1">1</a></span>`,
+		},
+		{
+			name:     "Markdown",
+			urlPath:  "/snippet/view/5",
+			wantCode: http.StatusOK,
+			wantBody: `<div class='markdown'><h1 id="runbook"><a class="anchor" href="#runbook" rel="nofollow">#</a>Runbook</h1>`,
+		},
+		{
+			name:     "Markdown Source",
+			urlPath:  "/snippet/view/5?source=1",
+			wantCode: http.StatusOK,
+			wantBody: `<span class="cl">&lt;script&gt;alert(1)&lt;/script&gt;`,
+		},
+		{
+			name:     "Expired ID",
+			urlPath:  "/snippet/view/6",
+			wantCode: http.StatusGone,
+		},
+		{
+			name:     "Private ID",
+			urlPath:  "/snippet/view/4",
+			wantCode: http.StatusNotFound,
+		},
 		{
 			name:     "Non-existent ID",
 			urlPath:  "/snippet/view/2",
@@ -114,6 +160,9 @@ func TestLoginUser(t *testing.T) {
 		{"Empty Email", "", "validPa$$word", csrfToken, http.StatusUnprocessableEntity, []byte("This field cannot be blank")},
 		{"Empty Password", "alice@example.com", "", csrfToken, http.StatusUnprocessableEntity, []byte("This field cannot be blank")},
 		{"Invalid Password", "alice@example.com", "FooBarBaz", csrfToken, http.StatusUnprocessableEntity, []byte("Email or password is incorrect")},
+		{"Deactivated", "dave@example.com", "validPa$$word", csrfToken, http.StatusUnprocessableEntity, []byte("Your account has been deactivated")},
+		// the deactivation is not revealed without the password
+		{"Deactivated Invalid Password", "dave@example.com", "FooBarBaz", csrfToken, http.StatusUnprocessableEntity, []byte("Email or password is incorrect")},
 		{"Invalid CSRF Token", "", "", "wrongToken", http.StatusBadRequest, nil},
 	}
 
@@ -137,6 +186,80 @@ func TestLoginUser(t *testing.T) {
 	}
 }
 
+func TestLoginLockout(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, string(body))
+
+	login := func(t *testing.T, email, password string) (int, http.Header, []byte) {
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+		return ts.postForm(t, "/user/login", form)
+	}
+
+	// a successful login forgets the failures of the account
+	for range 4 {
+		code, _, _ := login(t, "alice@example.com", "FooBarBaz")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+	code, _, _ := login(t, "alice@example.com", "validPa$$word")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	for range 5 {
+		code, _, _ := login(t, "alice@example.com", "FooBarBaz")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	// even the valid password is rejected now
+	code, headers, body := login(t, "Alice@example.com", "validPa$$word")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "60")
+	assert.StringContains(t, string(body), "Too many failed login attempts, please try again later")
+
+	// other accounts are not locked
+	code, _, _ = login(t, "carol@example.com", "validPa$$word")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// until the IP address is locked too, after 20 failures in total
+	for i := range 11 {
+		code, _, _ := login(t, fmt.Sprintf("nobody%d@example.com", i), "FooBarBaz")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+	code, _, _ = login(t, "carol@example.com", "validPa$$word")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+}
+
+func TestLoginLockoutConcurrent(t *testing.T) {
+	app := newTestApp(t)
+
+	// concurrent guesses cannot all pass before the first failure is counted
+	var failed, limited atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			r := httptest.NewRequest(http.MethodPost, "/user/login", nil)
+			_, _, _, err := app.checkLogin(r, "alice@example.com", "FooBarBaz")
+			switch {
+			case errors.Is(err, models.ErrAuthenticationFailure):
+				failed.Add(1)
+			case errors.Is(err, models.ErrRateLimited):
+				limited.Add(1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	assert.Equal(t, failed.Load(), int32(models.DefaultLockoutPolicy.AccountThreshold))
+	assert.Equal(t, limited.Load(), int32(20-models.DefaultLockoutPolicy.AccountThreshold))
+}
+
 func TestChangePassword(t *testing.T) {
 	app := newTestApp(t)
 
@@ -401,6 +524,12 @@ func TestSignupUser(t *testing.T) {
 			}
 		})
 	}
+
+	// only the valid submission is sent a verification link
+	sent := sentMail(app)
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, validEmail)
+	assert.StringContains(t, sent[0].Body, "https://snptx.test/user/verify/")
 }
 
 // TestCreateSnippetForm checks that:
@@ -463,7 +592,8 @@ func TestCreateSnippetForm(t *testing.T) {
 
 // TestDeleteSnippet checks that:
 // - Unauthenticated users are redirected to the login form.
-// - Authenticated users can delete snippets.
+// - Authenticated users can delete snippets they own.
+// - Authenticated users can not delete snippets owned by others.
 func TestDeleteSnippet(t *testing.T) {
 
 	app := newTestApp(t)
@@ -519,5 +649,779 @@ func TestDeleteSnippet(t *testing.T) {
 		if headers.Get("Location") != "/" {
 			t.Errorf("want %s; got %s", "/", headers.Get("Location"))
 		}
+
+		// the delete controls are hidden on snippets owned by others
+		code, _, body = ts.get(t, "/snippet/view/3")
+		assert.Equal(t, code, http.StatusOK)
+		if bytes.Contains(body, []byte("/snippet/delete/3")) {
+			t.Errorf("want body %s to not contain the delete form", body)
+		}
+
+		// snippets owned by others may not be deleted
+		code, _, _ = ts.postForm(t, "/snippet/delete/3", form)
+		assert.Equal(t, code, http.StatusForbidden)
 	})
 }
+
+func TestSnippetRevisions(t *testing.T) {
+	app := newTestApp(t)
+
+	// start up a https test server
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{"Revisions", "/snippet/1/revisions", http.StatusOK, "Alice"},
+		{"Private Revisions", "/snippet/4/revisions", http.StatusNotFound, ""},
+		{"Latest Diff", "/snippet/1/diff", http.StatusOK, "<span class='diff-insert'>&#43;A frog jumps into the pond,</span>"},
+		{"Selected Diff", "/snippet/1/diff?from=12&to=11", http.StatusOK, "<span class='diff-delete'>-A frog jumps into the pond,</span>"},
+		{"Unknown Revision", "/snippet/1/diff?from=99", http.StatusNotFound, ""},
+		{"Private Diff", "/snippet/4/diff", http.StatusNotFound, ""},
+		{"Unknown Page", "/snippet/1/unknown", http.StatusNotFound, ""},
+		{"Trailing Path", "/snippet/1/revisions/2", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, string(body), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRestoreSnippetRevision(t *testing.T) {
+	app := newTestApp(t)
+
+	// start up a https test server
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// mimic the workflow of logging in as a user
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, string(body))
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/login", form)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		revision string
+		wantCode int
+	}{
+		{"Valid Revision", "/snippet/1/restore", "11", http.StatusSeeOther},
+		{"Unknown Revision", "/snippet/1/restore", "99", http.StatusNotFound},
+		{"Not Owner", "/snippet/3/restore", "11", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("revision", tt.revision)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestSnippetList(t *testing.T) {
+	app := newTestApp(t)
+
+	// start up a https test server
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{"Home", "/", http.StatusOK, "An old silent pond"},
+		{"First Page", "/snippets?limit=1&sort=title", http.StatusOK, "<a href='/snippets?cursor=page2&amp;limit=1&amp;sort=title' class='next'>"},
+		{"Second Page", "/snippets?cursor=page2&limit=1", http.StatusOK, "<a href='/snippets?cursor=page1&amp;limit=1' class='prev'>"},
+		{"Invalid Cursor", "/snippets?cursor=foo", http.StatusBadRequest, ""},
+		{"Invalid Sort", "/snippets?sort=foo", http.StatusUnprocessableEntity, "This field must equal created, updated, title or expires"},
+		{"Invalid Date", "/snippets?from=yesterday", http.StatusUnprocessableEntity, "This field must be a valid date"},
+		{"Invalid Owner", "/snippets?owner=abc", http.StatusUnprocessableEntity, "This field must be a valid ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, string(body), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetSearch(t *testing.T) {
+	app := newTestApp(t)
+
+	// start up a https test server
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{"Empty", "/search", http.StatusOK, "<input type='text' name='q' value=''"},
+		{"Word", "/search?q=pond", http.StatusOK, "<p class='excerpt'>An old silent <mark>pond</mark>...</p>"},
+		{"Prefix", "/search?q=sil*", http.StatusOK, "An old <mark>silent</mark> pond</a>"},
+		{"Phrase", "/search?q=%22silent+pond%22", http.StatusOK, "An old <mark>silent pond</mark></a>"},
+		{"No Match", "/search?q=%22pond+silent%22", http.StatusOK, "No snippets match your search."},
+		{"Unlisted", "/search?q=wintry", http.StatusOK, "No snippets match your search."},
+		{"Private", "/search?q=autumn", http.StatusOK, "No snippets match your search."},
+		{"Too Long", "/search?q=" + strings.Repeat("a", 201), http.StatusUnprocessableEntity, "This field cannot be more than 200 characters long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestTagView(t *testing.T) {
+	app := newTestApp(t)
+
+	// start up a https test server
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{"Tag", "/tag/haiku", http.StatusOK, "<a href='/snippet/view/1'>An old silent pond</a>"},
+		{"Normalized", "/tag/Nature", http.StatusOK, "<h2>Snippets tagged <span class='tag'>nature</span></h2>"},
+		{"Private", "/tag/private-notes", http.StatusOK, "There's nothing to see here... yet!"},
+		{"Invalid", "/tag/no$tag", http.StatusNotFound, ""},
+		{"Suggestions", "/tags?q=Ha", http.StatusOK, `["haiku"]`},
+		{"Private Suggestions", "/tags?q=priv", http.StatusOK, `[]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestCreateSnippetPost(t *testing.T) {
+	app := newTestApp(t)
+
+	// start up a https test server
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// mimic the workflow of logging in as a user
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, string(body))
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/login", form)
+
+	tests := []struct {
+		name     string
+		tags     string
+		wantCode int
+		wantBody string
+	}{
+		{"Valid", " SQL, k8s ,, Getting Started, sql", http.StatusSeeOther, ""},
+		{"Invalid Characters", "sql, <script>", http.StatusUnprocessableEntity, "Tags may only contain letters, digits"},
+		{"Too Long", strings.Repeat("a", 33), http.StatusUnprocessableEntity, "Tags cannot be more than 32 characters long"},
+		{"Too Many", "a,b,c,d,e,f,g,h,i,j,k", http.StatusUnprocessableEntity, "This field cannot contain more than 10 tags"},
+	}
+
+	t.Run("Invalid Language", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("title", "Program")
+		form.Add("content", "IDENTIFICATION DIVISION.")
+		form.Add("visibility", "public")
+		form.Add("language", "cobol")
+		form.Add("expires", "7")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.postForm(t, "/snippet/create", form)
+
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, string(body), "This field must be a supported language")
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Tagged")
+			form.Add("content", "Tagged snippet")
+			form.Add("visibility", "public")
+			form.Add("language", "auto")
+			form.Add("tags", tt.tags)
+			form.Add("expires", "7")
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestSnippetRaw(t *testing.T) {
+	app := newTestApp(t)
+
+	// start up a https test server
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Content", func(t *testing.T) {
+		code, header, body := ts.get(t, "/snippet/raw/1")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "text/plain; charset=utf-8")
+		assert.Equal(t, string(body), "An old silent pond...")
+
+		// revalidating with the ETag yields no content
+		code, _, body = ts.getWithHeader(t, "/snippet/raw/1", http.Header{
+			"If-None-Match": {header.Get("ETag")},
+		})
+		assert.Equal(t, code, http.StatusNotModified)
+		assert.Equal(t, len(body), 0)
+	})
+
+	t.Run("Download", func(t *testing.T) {
+		code, header, body := ts.get(t, "/snippet/download/5")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename=runbook.md`)
+		assert.StringContains(t, string(body), "<script>alert(1)</script>")
+	})
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{"Private", "/snippet/raw/4", http.StatusNotFound},
+		{"Private Download", "/snippet/download/4", http.StatusNotFound},
+		{"Expired", "/snippet/raw/6", http.StatusGone},
+		{"Non-existent", "/snippet/raw/2", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com")
+
+	code, _, body := ts.get(t, "/user/profile")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "/user/token/revoke/7")
+
+	tests := []struct {
+		name     string
+		tokName  string
+		scopes   []string
+		expires  string
+		wantCode int
+		wantBody string
+	}{
+		{"Valid", "deploy", []string{"snippets:read", "snippets:write"}, "30", http.StatusOK, "snptx_pat_created"},
+		{"Never Expires", "deploy", []string{"snippets:read"}, "0", http.StatusOK, "snptx_pat_created"},
+		{"Empty Name", "", []string{"snippets:read"}, "30", http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"No Scopes", "deploy", nil, "30", http.StatusUnprocessableEntity, "Select at least one scope"},
+		{"Invalid Scope", "deploy", []string{"users:write"}, "30", http.StatusUnprocessableEntity, "This field must equal snippets:read or snippets:write"},
+		{"Invalid Expiry", "deploy", []string{"snippets:read"}, "7", http.StatusUnprocessableEntity, "This field must equal 0, 30, 90 or 365"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.tokName)
+			for _, s := range tt.scopes {
+				form.Add("scopes", s)
+			}
+			form.Add("expires", tt.expires)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/user/token/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+			assert.Equal(t, headers.Get("Cache-Control"), "no-store")
+		})
+	}
+
+	t.Run("Revoke", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, headers, _ := ts.postForm(t, "/user/token/revoke/7", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/profile")
+
+		code, _, _ = ts.postForm(t, "/user/token/revoke/8", form)
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com")
+
+	code, _, body := ts.get(t, "/user/profile")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "<h2>Sessions</h2>")
+	assert.StringContains(t, string(body), "This session")
+	assert.StringContains(t, string(body), "192.0.2.10")
+	assert.StringContains(t, string(body), "<form action='/user/session/revoke/0123456789abcdef0123456789abcdef' method='POST'>")
+	assert.StringContains(t, string(body), "<input type='submit' value='Log out everywhere else'>")
+
+	// the session cookie holds the token of the current session
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var currentID string
+	for _, c := range ts.Client().Jar.Cookies(u) {
+		if c.Name == app.sessionManager.Cookie.Name {
+			currentID = models.SessionID(c.Value)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantCode  int
+		wantFlash string
+	}{
+		{"Revoke", "/user/session/revoke/0123456789abcdef0123456789abcdef", http.StatusSeeOther, "Session logged out!"},
+		{"Revoke Unknown", "/user/session/revoke/fedcba9876543210fedcba9876543210", http.StatusNotFound, ""},
+		{"Revoke Current", "/user/session/revoke/" + currentID, http.StatusBadRequest, ""},
+		{"Revoke Others", "/user/sessions/revoke-others", http.StatusSeeOther, "Logged out of all other sessions!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantFlash != "" {
+				_, _, body := ts.get(t, "/user/profile")
+				assert.StringContains(t, string(body), tt.wantFlash)
+			}
+		})
+	}
+
+	// changing the password logs out the other sessions
+	form := url.Values{}
+	form.Add("currentPassword", "validPa$$word")
+	form.Add("newPassword", "sup3rs3cr3t")
+	form.Add("newPasswordConfirmation", "sup3rs3cr3t")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/user/change-password", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	_, _, body = ts.get(t, "/user/profile")
+	assert.StringContains(t, string(body), "Your password has been updated! Your other sessions have been logged out.")
+
+	// logging out keeps the session for the flash but not the user
+	code, _, _ = ts.postForm(t, "/user/logout", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = ts.get(t, "/user/profile")
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestAuditEvents(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	csrfToken := extractCSRFToken(t, string(body))
+
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	form = url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "FooBarBaz")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	csrfToken = ts.login(t, "alice@example.com")
+
+	form = url.Values{}
+	form.Add("currentPassword", "validPa$$word")
+	form.Add("newPassword", "sup3rs3cr3t")
+	form.Add("newPasswordConfirmation", "sup3rs3cr3t")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/user/change-password", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/snippet/delete/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	want := []struct {
+		eventType string
+		actorID   string
+		targetID  string
+	}{
+		{models.AuditSignup, "100", "100"},
+		{models.AuditLoginFailed, "", "1"},
+		{models.AuditLogin, "1", "1"},
+		{models.AuditPasswordChange, "1", "1"},
+		{models.AuditSnippetDelete, "1", "1"},
+	}
+
+	events := app.audit.(*mock.AuditStore).Events()
+	assert.Equal(t, len(events), len(want))
+	for i, e := range events {
+		if i >= len(want) {
+			break
+		}
+		assert.Equal(t, e.Type, want[i].eventType)
+		assert.Equal(t, e.ActorID, want[i].actorID)
+		assert.Equal(t, e.TargetID, want[i].targetID)
+		assert.Equal(t, e.IP, "127.0.0.1")
+	}
+	assert.Equal(t, events[1].Details["email"], "alice@example.com")
+
+	// alice sees the activity of her account, the latest first
+	_, _, body = ts.get(t, "/user/profile")
+	assert.StringContains(t, string(body), "<h2>Recent Security Activity</h2>")
+	assert.StringContains(t, string(body), "<td>Changed password</td>")
+	assert.StringContains(t, string(body), "<td>Failed login</td>")
+	if strings.Contains(string(body), "<td>Signed up</td>") {
+		t.Error("want no activity of other users")
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/forgot-password")
+	csrfToken := extractCSRFToken(t, string(body))
+
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+		wantBody string
+	}{
+		{"Known Email", "alice@example.com", http.StatusSeeOther, ""},
+		{"Unknown Email", "nobody@example.com", http.StatusSeeOther, ""},
+		{"Inactive User", "dave@example.com", http.StatusSeeOther, ""},
+		{"No Password", "hank@example.com", http.StatusSeeOther, ""},
+		{"Empty Email", "", http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"Invalid Email", "alice@", http.StatusUnprocessableEntity, "This field must be a valid email address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/user/forgot-password", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+			if code != http.StatusSeeOther {
+				return
+			}
+
+			// the response does not tell whether the account exists
+			assert.Equal(t, headers.Get("Location"), "/user/login")
+			_, _, body = ts.get(t, "/user/login")
+			assert.StringContains(t, string(body), "If there is an account for this email, we have sent you a link")
+		})
+	}
+
+	sent := sentMail(app)
+	assert.Equal(t, len(sent), 2)
+	assert.Equal(t, sent[0].To, "alice@example.com")
+	assert.StringContains(t, sent[0].Body, "https://snptx.test/user/reset-password/valid-reset-token")
+	// an account provisioned by single sign-on has no password to reset
+	assert.Equal(t, sent[1].To, "hank@example.com")
+	assert.StringContains(t, sent[1].Body, "Your account has no password, please log in with single sign-on")
+}
+
+func TestResetPassword(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/user/reset-password/invalid-token")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/forgot-password")
+
+	_, _, body := ts.get(t, "/user/forgot-password")
+	assert.StringContains(t, string(body), "This password reset link is invalid or has expired.")
+
+	code, _, body = ts.get(t, "/user/reset-password/valid-reset-token")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "<form action='/user/reset-password/valid-reset-token' method='POST' novalidate>")
+	csrfToken := extractCSRFToken(t, string(body))
+
+	tests := []struct {
+		name                    string
+		token                   string
+		newPassword             string
+		newPasswordConfirmation string
+		wantCode                int
+		wantLocation            string
+		wantBody                string
+	}{
+		{"Blank Password", "valid-reset-token", "", "", http.StatusUnprocessableEntity, "", "This field cannot be blank"},
+		{"Short Password", "valid-reset-token", "short", "short", http.StatusUnprocessableEntity, "", "This field must be at least 10 characters long"},
+		{"Mismatch", "valid-reset-token", "newPa$$word1", "newPa$$word2", http.StatusUnprocessableEntity, "", "This field must be equal to the new password confirmation"},
+		{"Invalid Token", "invalid-token", "newPa$$word1", "newPa$$word1", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"Valid", "valid-reset-token", "newPa$$word1", "newPa$$word1", http.StatusSeeOther, "/user/login", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("newPassword", tt.newPassword)
+			form.Add("newPasswordConfirmation", tt.newPasswordConfirmation)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/user/reset-password/"+tt.token, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+
+	_, _, body = ts.get(t, "/user/login")
+	assert.StringContains(t, string(body), "Your password has been reset, please log in.")
+
+	events := app.audit.(*mock.AuditStore).Events()
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Type, models.AuditPasswordChange)
+	assert.Equal(t, events[0].TargetID, "1")
+	assert.Equal(t, events[0].Details["via"], "reset")
+}
+
+func TestVerifyEmail(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	exp := time.Now().Add(time.Hour)
+	valid := app.verifier.Sign("12", "erin@example.com", exp)
+
+	tests := []struct {
+		name      string
+		token     string
+		wantFlash string
+	}{
+		{"Valid", valid, "Your email address has been verified!"},
+		{"Tampered", valid + "x", "This verification link is invalid or has expired."},
+		{"Expired", app.verifier.Sign("12", "erin@example.com", time.Now().Add(-time.Minute)), "This verification link is invalid or has expired."},
+		{"Changed Email", app.verifier.Sign("12", "erin@example.org", exp), "This verification link is invalid or has expired."},
+		{"Unknown User", app.verifier.Sign("99", "nobody@example.com", exp), "This verification link is invalid or has expired."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.get(t, "/user/verify/"+tt.token)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/login")
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, string(body), tt.wantFlash)
+		})
+	}
+
+	t.Run("Authenticated", func(t *testing.T) {
+		ts.login(t, "erin@example.com")
+
+		code, headers, _ := ts.get(t, "/user/verify/"+valid)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/profile")
+	})
+}
+
+func TestVerifyEmailResend(t *testing.T) {
+	tests := []struct {
+		name      string
+		email     string
+		wantFlash string
+		wantMail  int
+	}{
+		{"Unverified", "erin@example.com", "We have sent you a new verification link.", 1},
+		{"Sent Recently", "frank@example.com", "A verification link has been sent to you a moment ago, please check your inbox.", 0},
+		{"Verified", "alice@example.com", "Your email address is verified already.", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t, tt.email)
+
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, headers, _ := ts.postForm(t, "/user/verify/resend", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/profile")
+
+			_, _, body := ts.get(t, "/user/profile")
+			assert.StringContains(t, string(body), html.EscapeString(tt.wantFlash))
+
+			sent := sentMail(app)
+			assert.Equal(t, len(sent), tt.wantMail)
+			if tt.wantMail > 0 {
+				assert.Equal(t, sent[0].To, tt.email)
+			}
+		})
+	}
+}
+
+// TestVerificationMail sends the verification mail through the SMTP mailer
+// and follows the link it holds.
+func TestVerificationMail(t *testing.T) {
+	srv := smtptest.NewServer(t)
+
+	app := newTestApp(t)
+	app.mailer = mail.NewSMTP(srv.Host, srv.Port, "", "", "Snippetbox <no-reply@snptx.test>")
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "erin@example.com")
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/verify/resend", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	app.wg.Wait()
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("want 1 message; got %d", len(msgs))
+	}
+	assert.Equal(t, msgs[0].From, "no-reply@snptx.test")
+	assert.Equal(t, strings.Join(msgs[0].To, ","), "erin@example.com")
+
+	data := string(msgs[0].Data)
+	assert.StringContains(t, data, "Subject: Verify your Snippetbox email address\r\n")
+
+	prefix := app.baseURL + "/user/verify/"
+	i := strings.Index(data, prefix)
+	if i < 0 {
+		t.Fatalf("want a verification link; got %q", data)
+	}
+	token, _, _ := strings.Cut(data[i+len(prefix):], "\r\n")
+
+	code, headers, _ := ts.get(t, "/user/verify/"+token)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/profile")
+
+	_, _, body := ts.get(t, "/user/profile")
+	assert.StringContains(t, string(body), "Your email address has been verified!")
+}
+
+func TestUnverifiedPublish(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "erin@example.com")
+
+	_, _, body := ts.get(t, "/user/profile")
+	assert.StringContains(t, string(body), "Resend verification link")
+
+	// new snippets are unlisted by default
+	_, _, body = ts.get(t, "/snippet/create")
+	assert.StringContains(t, string(body), "<option value='unlisted' selected>")
+
+	tests := []struct {
+		name       string
+		path       string
+		visibility string
+		wantCode   int
+	}{
+		{"Create Public", "/snippet/create", "public", http.StatusUnprocessableEntity},
+		{"Create Unlisted", "/snippet/create", "unlisted", http.StatusSeeOther},
+		{"Create Private", "/snippet/create", "private", http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Draft")
+			form.Add("content", "Not ready for everyone")
+			form.Add("visibility", tt.visibility)
+			form.Add("language", "auto")
+			form.Add("expires", "7")
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, tt.path, form)
+			assert.Equal(t, code, tt.wantCode)
+			if code == http.StatusUnprocessableEntity {
+				assert.StringContains(t, string(body), unverifiedPublishError)
+			}
+		})
+	}
+}
@@ -2,15 +2,26 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/auth"
+	"github.com/tullo/snptx/internal/platform/highlight"
+	"github.com/tullo/snptx/internal/platform/mail"
 )
 
 func ping(w http.ResponseWriter, r *http.Request) {
@@ -43,6 +54,11 @@ func (a *app) notFound(w http.ResponseWriter) {
 }
 
 func (a *app) newTemplateData(r *http.Request) templateData {
+	var ssoName string
+	if a.sso != nil {
+		ssoName = a.sso.name
+	}
+
 	return templateData{
 		Version:     a.version[:7],
 		CurrentYear: time.Now().Year(),
@@ -53,11 +69,51 @@ func (a *app) newTemplateData(r *http.Request) templateData {
 		Flash: a.sessionManager.PopString(r.Context(), "flash"),
 
 		// add authentication status to the template data
-		IsAuthenticated: a.isAuthenticated(r),
+		IsAuthenticated:     a.isAuthenticated(r),
+		AuthenticatedUserID: a.authenticatedUserID(r),
+		IsAdmin:             a.hasUserRole(r, auth.RoleAdmin),
 
 		// add CSRF token to the template data
 		CSRFToken: nosurf.Token(r),
+
+		// offer single sign-on if it is configured
+		SSOName: ssoName,
+	}
+}
+
+// authenticatedUserID returns the ID of the authenticated user,
+// or an empty string for anonymous requests
+func (a *app) authenticatedUserID(r *http.Request) string {
+	// API requests are authenticated without a session
+	if claims, ok := requestClaims(r); ok {
+		return claims.Subject
 	}
+	if !a.isAuthenticated(r) {
+		return ""
+	}
+	return a.sessionManager.GetString(r.Context(), "authenticatedUserID")
+}
+
+// requestClaims returns the claims of a request authenticated by a token.
+func requestClaims(r *http.Request) (auth.Claims, bool) {
+	claims, ok := r.Context().Value(auth.Key).(auth.Claims)
+	return claims, ok
+}
+
+// hasRole checks if the request is authenticated by a token granting at
+// least one of the roles.
+func hasRole(r *http.Request, roles ...string) bool {
+	claims, ok := requestClaims(r)
+	return ok && claims.HasRole(roles...)
+}
+
+// hasUserRole checks if the user authenticated by the session has at least
+// one of the roles.
+func (a *app) hasUserRole(r *http.Request, roles ...string) bool {
+	userRoles, _ := r.Context().Value(userRolesContextKey).([]string)
+	return slices.ContainsFunc(roles, func(role string) bool {
+		return slices.Contains(userRoles, role)
+	})
 }
 
 // isAuthenticated checks if the request is from an authenticated user
@@ -70,6 +126,94 @@ func (a *app) isAuthenticated(r *http.Request) bool {
 	return isAuthenticated
 }
 
+// isVerified checks if the authenticated user has verified their email
+// address.
+func (a *app) isVerified(r *http.Request) (bool, error) {
+	if verified, ok := r.Context().Value(isVerifiedContextKey).(bool); ok {
+		return verified, nil
+	}
+
+	// API requests are authenticated without a session
+	id := a.authenticatedUserID(r)
+	if id == "" {
+		return false, nil
+	}
+	usr, err := a.users.QueryByID(r.Context(), id)
+	if err != nil {
+		return false, err
+	}
+	return usr.Verified, nil
+}
+
+// mayPublish checks if a snippet may get the visibility. Users who have not
+// verified their email address may not make snippets public, current is the
+// visibility of the snippet before an update.
+func mayPublish(verified bool, visibility, current string) bool {
+	return verified || visibility != models.VisibilityPublic || current == models.VisibilityPublic
+}
+
+// canModify checks if the authenticated user owns the snippet or is an admin
+func (a *app) canModify(r *http.Request, s *models.Snippet) (bool, error) {
+	userID := a.authenticatedUserID(r)
+	if userID == "" {
+		return false, nil
+	}
+	if userID == s.OwnerID {
+		return true, nil
+	}
+	if _, ok := requestClaims(r); ok {
+		// the roles of the user were granted with the token
+		return hasRole(r, auth.RoleAdmin), nil
+	}
+
+	return a.hasUserRole(r, auth.RoleAdmin), nil
+}
+
+// snippetFilename derives a file name for downloading the snippet from its
+// title and language, e.g. "Deploy script" in bash becomes "deploy-script.sh".
+func snippetFilename(s *models.Snippet) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s.Title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+
+	name := strings.Trim(b.String(), ".")
+	if name == "" {
+		name = "snippet"
+	}
+
+	l, ok := highlight.Lookup(s.Language)
+	if !ok {
+		l, _ = highlight.Lookup(highlight.Plaintext)
+	}
+	if !strings.HasSuffix(name, l.Ext) {
+		name += l.Ext
+	}
+
+	return name
+}
+
+// canView checks if the authenticated user may read the snippet. Public and
+// unlisted snippets are readable by anyone, private ones only by the owner
+// and admins.
+func (a *app) canView(r *http.Request, s *models.Snippet) (bool, error) {
+	if s.Visibility != models.VisibilityPrivate {
+		return true, nil
+	}
+
+	return a.canModify(r, s)
+}
+
 func (a *app) render(w http.ResponseWriter, r *http.Request, status int, page string, data templateData) {
 	ts, ok := a.templateCache[page]
 	if !ok {
@@ -113,3 +257,223 @@ func (app *app) decodePostForm(r *http.Request, dst any) error {
 
 	return nil
 }
+
+// sendVerification mails a link to verify the email address to the user. It
+// fails with models.ErrRateLimited if a link was sent recently.
+func (a *app) sendVerification(ctx context.Context, usr *models.User) error {
+	now := time.Now()
+	if err := a.users.RecordVerificationSent(ctx, usr.ID, now, verificationInterval); err != nil {
+		return err
+	}
+
+	token := a.verifier.Sign(usr.ID, usr.Email, now.Add(verificationTTL))
+	a.sendMail(mail.Message{
+		To:      usr.Email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"please follow this link within the next two days to verify your email address:\n\n"+
+			"%s/user/verify/%s\n\n"+
+			"If you did not sign up for Snippetbox, you can ignore this email.\n",
+			usr.Name, a.baseURL, token),
+	})
+
+	return nil
+}
+
+// background runs fn in a goroutine which is waited for on shutdown. Panics
+// are recovered and logged.
+func (a *app) background(fn func()) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				a.log.Printf("background: %v\n%s", err, debug.Stack())
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// sendMail sends the message in the background, so the response time does
+// not tell whether a message was sent.
+func (a *app) sendMail(m mail.Message) {
+	a.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := a.mailer.Send(ctx, m); err != nil {
+			a.log.Printf("sending mail: %v", err)
+		}
+	})
+}
+
+// clientIP returns the IP address the request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkLogin verifies the email and password of a login. Each attempt is
+// counted against the account and the IP address of the request before the
+// password is checked, and released again if it succeeds. Once either is
+// locked the login fails with models.ErrRateLimited and the time to wait,
+// without checking the password. It reports whether the user has to enter
+// a second factor too, the failures of the account are forgotten only once
+// that succeeds as well.
+func (a *app) checkLogin(r *http.Request, email, password string) (auth.Claims, bool, time.Duration, error) {
+	now := time.Now()
+
+	att, err := a.logins.Attempt(r.Context(), email, clientIP(r), now)
+	if err != nil {
+		return auth.Claims{}, false, 0, err
+	}
+	if att.Rejected() {
+		return auth.Claims{}, false, att.LockedUntil.Sub(now), models.ErrRateLimited
+	}
+
+	claims, err := a.users.Authenticate(r.Context(), now, email, password)
+	if errors.Is(err, models.ErrAuthenticationFailure) {
+		if err := a.recordLoginFailure(r, att, "password"); err != nil {
+			return auth.Claims{}, false, 0, err
+		}
+		return auth.Claims{}, false, 0, err
+	}
+	if err != nil {
+		a.releaseLogin(r, att)
+		return auth.Claims{}, false, 0, err
+	}
+
+	tf, err := a.twoFactor.Status(r.Context(), claims.Subject)
+	if err != nil {
+		a.releaseLogin(r, att)
+		return auth.Claims{}, false, 0, err
+	}
+	if tf.Enabled {
+		if err := a.logins.Release(r.Context(), att); err != nil {
+			return auth.Claims{}, false, 0, err
+		}
+		return claims, true, 0, nil
+	}
+
+	if err := a.logins.Succeed(r.Context(), att); err != nil {
+		return auth.Claims{}, false, 0, err
+	}
+
+	return claims, false, 0, nil
+}
+
+// checkSecondFactor verifies the code of the authenticator app or a recovery
+// code the user entered after the password. Wrong codes are counted like
+// wrong passwords, against the account and the IP address of the request.
+// Once either is locked the code fails with models.ErrRateLimited and the
+// time to wait, without checking the code.
+func (a *app) checkSecondFactor(r *http.Request, userID, code string) (time.Duration, error) {
+	usr, err := a.users.QueryByID(r.Context(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	att, err := a.logins.Attempt(r.Context(), usr.Email, clientIP(r), now)
+	if err != nil {
+		return 0, err
+	}
+	if att.Rejected() {
+		return att.LockedUntil.Sub(now), models.ErrRateLimited
+	}
+
+	err = a.twoFactor.Verify(r.Context(), userID, code, now)
+	if errors.Is(err, models.ErrInvalidCode) {
+		if err := a.recordLoginFailure(r, att, "2fa"); err != nil {
+			return 0, err
+		}
+		return 0, err
+	}
+	if err != nil {
+		a.releaseLogin(r, att)
+		return 0, err
+	}
+
+	return 0, a.logins.Succeed(r.Context(), att)
+}
+
+// releaseLogin releases a login attempt that failed for other reasons than
+// wrong credentials.
+func (a *app) releaseLogin(r *http.Request, att *models.LoginAttempt) {
+	if err := a.logins.Release(r.Context(), att); err != nil {
+		a.log.Printf("releasing login attempt: %v", err)
+	}
+}
+
+// recordLoginFailure audits a login attempt failing at the factor, the
+// password or the second factor, and the lockouts it triggered.
+func (a *app) recordLoginFailure(r *http.Request, att *models.LoginAttempt, factor string) error {
+	// the failures are shown to the user owning the email
+	var targetID string
+	if usr, err := a.users.QueryByEmail(r.Context(), att.Email); err == nil {
+		targetID = usr.ID
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+	a.recordAudit(r, models.AuditLoginFailed, "", targetID, map[string]string{"email": att.Email, "factor": factor})
+	for _, l := range att.Lockouts {
+		a.recordAudit(r, models.AuditLoginLockout, "", targetID, map[string]string{
+			l.Kind:     l.Subject,
+			"failures": strconv.Itoa(l.Failures),
+			"until":    l.Until.UTC().Format(time.RFC3339),
+		})
+	}
+	return nil
+}
+
+// setRetryAfter tells the client how many seconds to wait before retrying.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// sessionTouchInterval limits how often the last seen time of a session is
+// updated, as each update writes the session to the store.
+const sessionTouchInterval = time.Minute
+
+// touchSession records when and from where the session was last seen.
+func (a *app) touchSession(r *http.Request) {
+	ctx := r.Context()
+	now := time.Now()
+	lastSeen := time.Unix(a.sessionManager.GetInt64(ctx, models.SessionLastSeenKey), 0)
+	if now.Sub(lastSeen) < sessionTouchInterval && a.sessionManager.GetString(ctx, models.SessionIPKey) == clientIP(r) {
+		return
+	}
+
+	a.sessionManager.Put(ctx, models.SessionLastSeenKey, now.Unix())
+	a.sessionManager.Put(ctx, models.SessionIPKey, clientIP(r))
+	a.sessionManager.Put(ctx, models.SessionUserAgentKey, r.UserAgent())
+}
+
+// recordAudit appends an audit event for the request. A failure to record
+// it is logged but does not fail the request.
+func (a *app) recordAudit(r *http.Request, eventType, actorID, targetID string, details map[string]string) {
+	e := models.AuditEvent{
+		Type:      eventType,
+		ActorID:   actorID,
+		TargetID:  targetID,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	}
+	if err := a.audit.Record(r.Context(), e, time.Now()); err != nil {
+		a.log.Printf("audit: recording %s event: %v", eventType, err)
+	}
+}
+
+// recordSnippetDelete appends the audit event of a deleted snippet.
+func (a *app) recordSnippetDelete(r *http.Request, s *models.Snippet) {
+	a.recordAudit(r, models.AuditSnippetDelete, a.authenticatedUserID(r), s.ID, map[string]string{
+		"title":    s.Title,
+		"owner_id": s.OwnerID,
+	})
+}
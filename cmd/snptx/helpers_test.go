@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tullo/snptx/internal/assert"
+	"github.com/tullo/snptx/internal/models"
+)
+
+func TestSnippetFilename(t *testing.T) {
+	tests := []struct {
+		title    string
+		language string
+		want     string
+	}{
+		{"Deploy script", "bash", "deploy-script.sh"},
+		{"main.go", "go", "main.go"},
+		{"  Release notes v1.2! ", "markdown", "release-notes-v1.2.md"},
+		{"Grüße", "plaintext", "grüße.txt"},
+		{"???", "sql", "snippet.sql"},
+		{"Notes", "unknown", "notes.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			got := snippetFilename(&models.Snippet{Title: tt.title, Language: tt.language})
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
@@ -5,13 +5,18 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -20,23 +25,45 @@ import (
 	"github.com/pkg/errors"
 	"github.com/tullo/conf"
 	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/auth"
 	"github.com/tullo/snptx/internal/platform/database"
+	"github.com/tullo/snptx/internal/platform/highlight"
+	"github.com/tullo/snptx/internal/platform/mail"
+	"github.com/tullo/snptx/internal/platform/markdown"
 	"github.com/tullo/snptx/internal/platform/sec"
+	"github.com/tullo/snptx/internal/platform/sso"
+	"github.com/tullo/snptx/internal/platform/verify"
 )
 
 // build is the git version of this application. It is set using build flags in the makefile.
 var build = "develop"
 
 type app struct {
+	audit          models.AuditModelInterface
+	auth           *auth.Authenticator
+	baseURL        string
 	debug          bool
+	identities     models.IdentityModelInterface
 	log            *log.Logger
+	logins         models.LoginFailureModelInterface
+	mailer         mail.Mailer
+	resets         models.PasswordResetModelInterface
+	sessions       models.SessionModelInterface
 	snippets       models.SnippetModelInterface
+	successor      string        // ID of the user taking over the snippets of deleted accounts
+	sso            *singleSignOn // nil if single sign-on is disabled
+	tokens         models.AccessTokenModelInterface
+	twoFactor      models.TwoFactorModelInterface
 	users          models.UserModelInterface
+	verifier       *verify.Signer
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
+	highlighter    *highlight.Highlighter
+	markdown       *markdown.Renderer
 	sessionManager *scs.SessionManager
 	shutdown       chan os.Signal
 	version        string
+	wg             sync.WaitGroup
 	year           int
 }
 
@@ -68,8 +95,10 @@ func run(log *log.Logger) error {
 	var cfg struct {
 		Web struct {
 			APIHost         string        `conf:"default::4200"`
+			BaseURL         string        `conf:"default:https://localhost:4200"` // used in links sent by mail
 			DebugMode       bool          `conf:"default:false"`
 			SessionSecret   string        `conf:"noprint"`
+			LinkSecret      string        `conf:"noprint"` // signs the links sent by mail, at least 32 bytes
 			IdleTimeout     time.Duration `conf:"default:1m"`
 			ReadTimeout     time.Duration `conf:"default:5s"`
 			WriteTimeout    time.Duration `conf:"default:5s"`
@@ -82,15 +111,52 @@ func run(log *log.Logger) error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:false"`
 		}
-		Aragon struct {
-			// Note: Changing the value of Parallelism - changes the hash output!
-			Memory      uint `conf:"default:131072"` // 128 * 1024 (KB) - memory used by the Argon2 algorithm
-			Iterations  uint `conf:"default:4"`      // number of passes over the memory
-			Parallelism uint `conf:"default:4"`      // number of threads to use on a machine with multiple cores
-			SaltLength  uint `conf:"default:16"`     // 16 bytes is recommended for password hashing
-			KeyLength   uint `conf:"default:32"`     // length of the generated password hash
+		Auth struct {
+			KeysFolder string `conf:"default:./keys"`
+			ActiveKID  string `conf:"help:ID of the key signing tokens (file name without .pem) if the keys folder holds several keys"`
+			Algorithm  string `conf:"default:RS256,help:RS256, RS384 or RS512"`
+			// base64 encoded key encrypting the TOTP secrets, e.g. 'openssl rand -base64 32'
+			EncryptionKey string `conf:"noprint"`
 		}
-		Args conf.Args
+		Mail struct {
+			Host     string `conf:"help:SMTP server"`
+			Port     int    `conf:"default:587"`
+			Username string
+			Password string `conf:"noprint"`
+			From     string `conf:"default:Snippetbox <no-reply@localhost>"`
+			Mailbox  string `conf:"help:folder mail is written to instead of sending it if no SMTP server is set, for development"`
+		}
+		SSO struct {
+			Issuer       string `conf:"help:OpenID Connect provider, single sign-on is disabled if empty"`
+			ClientID     string
+			ClientSecret string `conf:"noprint"`
+			Name         string `conf:"default:Single Sign-On"` // shown on the login button
+			RoleClaim    string `conf:"default:groups"`         // ID token claim with the roles or groups of the user
+			AdminRole    string `conf:"help:value of the role claim granting the admin role, roles are not mapped if empty"`
+		}
+		Lockout struct {
+			AccountThreshold int           `conf:"default:5"`  // failed logins locking an account, 0 disables the lockout
+			IPThreshold      int           `conf:"default:20"` // failed logins locking an IP address, 0 disables the lockout
+			Delay            time.Duration `conf:"default:1m"` // first lockout, doubled with each further failure
+			MaxDelay         time.Duration `conf:"default:1h"`
+			Window           time.Duration `conf:"default:1h"` // failures older than this are forgotten
+		}
+		Account struct {
+			SnippetSuccessor string `conf:"help:email of the user taking over the snippets of deleted accounts, they are deleted with the account if empty"`
+		}
+		Audit struct {
+			Retention time.Duration `conf:"default:8760h"` // audit events are deleted after a year, 0 keeps them forever
+		}
+		Reaper struct {
+			Interval  time.Duration `conf:"default:10m"`
+			BatchSize int           `conf:"default:500"` // maximum number of records deleted by a single statement
+		}
+		Highlight struct {
+			Style     string `conf:"default:monokai"`
+			CacheSize int    `conf:"default:512"` // number of rendered snippets kept in memory
+		}
+		Aragon sec.Config
+		Args   conf.Args
 	}
 
 	if err := conf.Parse(os.Args[1:], "SNPTX", &cfg); err != nil {
@@ -104,6 +170,9 @@ func run(log *log.Logger) error {
 		}
 		return errors.Wrap(err, "error: parsing config")
 	}
+	if err := checkReaper(cfg.Reaper.Interval, cfg.Reaper.BatchSize); err != nil {
+		return errors.Wrap(err, "error: parsing config")
+	}
 
 	// =========================================================================
 	// Start Database
@@ -125,6 +194,43 @@ func run(log *log.Logger) error {
 		pool.Close()
 	}()
 
+	// =========================================================================
+	// Initialize authentication support
+
+	log.Println("Initializing authentication support")
+
+	keys, err := auth.LoadKeyStore(cfg.Auth.KeysFolder)
+	if err != nil {
+		return errors.Wrap(err, "loading keys")
+	}
+
+	kid := cfg.Auth.ActiveKID
+	switch kids := keys.KIDs(); {
+	case len(kids) == 0 && !cfg.Web.DebugMode:
+		return errors.Errorf("no keys found in %s", cfg.Auth.KeysFolder)
+	case len(kids) == 0:
+		// tokens signed with an ephemeral key do not survive a restart
+		log.Printf("No keys found in %s, signing tokens with an ephemeral key in debug mode", cfg.Auth.KeysFolder)
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return errors.Wrap(err, "generating ephemeral key")
+		}
+		kid = "ephemeral"
+		keys = auth.NewKeyStore(map[string]*rsa.PrivateKey{kid: key})
+	case kid == "" && len(kids) == 1:
+		kid = kids[0]
+	}
+
+	privateKey, err := keys.PrivateKey(kid)
+	if err != nil {
+		return errors.Wrap(err, "looking up the active key")
+	}
+
+	authenticator, err := auth.New(privateKey, kid, cfg.Auth.Algorithm, keys.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "constructing authenticator")
+	}
+
 	// =========================================================================
 	// Start Web Application
 
@@ -137,13 +243,7 @@ func run(log *log.Logger) error {
 	log.Printf("Config:\n%v\n", out)
 
 	// parameters used for password hashing
-	hp := sec.HashParams{
-		Memory:      uint32(cfg.Aragon.Memory),
-		Iterations:  uint32(cfg.Aragon.Iterations),
-		Parallelism: uint8(cfg.Aragon.Parallelism),
-		SaltLength:  uint32(cfg.Aragon.SaltLength),
-		KeyLength:   uint32(cfg.Aragon.KeyLength),
-	}
+	hp := cfg.Aragon.HashParams()
 
 	// initialize template cache
 	templateCache, err := newTemplateCache()
@@ -159,27 +259,168 @@ func run(log *log.Logger) error {
 	db := database.DB{Pool: pool}
 	snippets := models.NewSnippetStore(&db)
 	users := models.NewUserStore(&db, sec.Params(hp))
+	tokens := models.NewAccessTokenStore(&db)
+	resets := models.NewPasswordResetStore(&db, sec.Params(hp))
+	audit := models.NewAuditStore(&db, cfg.Audit.Retention)
+	identities := models.NewIdentityStore(&db)
+	logins := models.NewLoginFailureStore(&db, models.LockoutPolicy{
+		AccountThreshold: cfg.Lockout.AccountThreshold,
+		IPThreshold:      cfg.Lockout.IPThreshold,
+		Delay:            cfg.Lockout.Delay,
+		MaxDelay:         cfg.Lockout.MaxDelay,
+		Window:           cfg.Lockout.Window,
+	})
+
+	successorID, err := lookupSuccessor(context.Background(), users, cfg.Account.SnippetSuccessor)
+	if err != nil {
+		return err
+	}
+
+	var mailer mail.Mailer
+	switch {
+	case cfg.Mail.Host != "":
+		mailer = mail.NewSMTP(cfg.Mail.Host, cfg.Mail.Port, cfg.Mail.Username, cfg.Mail.Password, cfg.Mail.From)
+	case cfg.Mail.Mailbox != "":
+		log.Printf("No mail host configured, writing mail to %s", cfg.Mail.Mailbox)
+		mailer = mail.NewMailbox(cfg.Mail.Mailbox, cfg.Mail.From, log)
+	default:
+		return errors.New("no mail host configured, set a mailbox folder for development")
+	}
+
+	linkSecret := []byte(cfg.Web.LinkSecret)
+	switch {
+	case len(linkSecret) >= 32:
+	case len(linkSecret) > 0 || !cfg.Web.DebugMode:
+		return errors.Errorf("link secret must be at least 32 bytes, got %d", len(linkSecret))
+	default:
+		// links signed with an ephemeral secret do not survive a restart
+		log.Println("No link secret configured, signing links with an ephemeral secret in debug mode")
+		linkSecret = make([]byte, 32)
+		if _, err := rand.Read(linkSecret); err != nil {
+			return errors.Wrap(err, "generating ephemeral link secret")
+		}
+	}
+
+	var encryptionKey []byte
+	switch {
+	case cfg.Auth.EncryptionKey != "":
+		encryptionKey, err = base64.StdEncoding.DecodeString(cfg.Auth.EncryptionKey)
+		if err != nil {
+			return errors.Wrap(err, "decoding encryption key")
+		}
+	case !cfg.Web.DebugMode:
+		return errors.New("no encryption key configured")
+	default:
+		// secrets encrypted with an ephemeral key cannot be decrypted after
+		// a restart, so there must not be any yet
+		enrolled, err := models.NewTwoFactorStore(&db, nil).Enrolled(context.Background())
+		if err != nil {
+			return errors.Wrap(err, "counting two-factor enrollments")
+		}
+		if enrolled > 0 {
+			return errors.Errorf("no encryption key configured, but %d users enrolled in two-factor authentication", enrolled)
+		}
+		log.Println("No encryption key configured, encrypting TOTP secrets with an ephemeral key in debug mode")
+		encryptionKey = make([]byte, sec.KeySize)
+		if _, err := rand.Read(encryptionKey); err != nil {
+			return errors.Wrap(err, "generating ephemeral encryption key")
+		}
+	}
+	cipher, err := sec.NewCipher(encryptionKey)
+	if err != nil {
+		return errors.Wrap(err, "constructing cipher")
+	}
+	twoFactor := models.NewTwoFactorStore(&db, cipher)
+
+	var signOn *singleSignOn
+	if cfg.SSO.Issuer != "" {
+		log.Printf("Initializing single sign-on with %s", cfg.SSO.Issuer)
+		provider, err := sso.New(context.Background(), sso.Config{
+			Issuer:       cfg.SSO.Issuer,
+			ClientID:     cfg.SSO.ClientID,
+			ClientSecret: cfg.SSO.ClientSecret,
+			RedirectURL:  strings.TrimSuffix(cfg.Web.BaseURL, "/") + "/user/login/oidc/callback",
+			Scopes:       []string{"email", "profile"},
+		})
+		if err != nil {
+			return errors.Wrap(err, "initializing single sign-on")
+		}
+		signOn = &singleSignOn{
+			provider:  provider,
+			name:      cfg.SSO.Name,
+			roleClaim: cfg.SSO.RoleClaim,
+			adminRole: cfg.SSO.AdminRole,
+		}
+	}
 
 	formDecoder := form.NewDecoder()
 
+	highlighter := highlight.New(cfg.Highlight.Style, cfg.Highlight.CacheSize)
+
+	sessions := models.NewSessionsStore(&db)
+
 	sessionManager := scs.New()
-	sessionManager.Store = models.NewSessionsStore(&db)
+	sessionManager.Store = sessions
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
 	app := &app{
+		audit:          audit,
+		auth:           authenticator,
+		baseURL:        strings.TrimSuffix(cfg.Web.BaseURL, "/"),
 		debug:          cfg.Web.DebugMode,
 		formDecoder:    formDecoder,
+		highlighter:    highlighter,
+		identities:     identities,
+		markdown:       markdown.New(highlighter),
 		log:            log,
+		logins:         logins,
+		mailer:         mailer,
+		resets:         resets,
 		sessionManager: sessionManager,
+		sessions:       sessions,
 		shutdown:       shutdown,
 		snippets:       snippets,
+		successor:      successorID,
+		sso:            signOn,
 		templateCache:  templateCache,
+		tokens:         tokens,
+		twoFactor:      twoFactor,
 		users:          users,
+		verifier:       verify.NewSigner(linkSecret),
 		version:        build,
 		year:           time.Now().Year(),
 	}
 
+	// =========================================================================
+	// Start Reaper
+
+	rp := &reaper{
+		log:       log,
+		interval:  cfg.Reaper.Interval,
+		batchSize: cfg.Reaper.BatchSize,
+		targets: []reapTarget{
+			{"snippets", snippets},
+			{"sessions", sessions},
+			{"password-resets", resets},
+			{"login-failures", logins},
+			{"audit-events", audit},
+		},
+	}
+
+	reaperCtx, stopReaper := context.WithCancel(context.Background())
+	reaperDone := make(chan struct{})
+	go func() {
+		defer close(reaperDone)
+		rp.run(reaperCtx)
+	}()
+	defer func() {
+		// stop purging before the database pool is closed
+		log.Println("Reaper Stopping")
+		stopReaper()
+		<-reaperDone
+	}()
+
 	// use Go’s favored cipher suites (support for forward secrecy)
 	// and elliptic curves that are performant under heavy loads
 	tlsConfig := &tls.Config{
@@ -228,6 +469,9 @@ func run(log *log.Logger) error {
 			err = srv.Close()
 		}
 
+		// Let background tasks like sending mail complete.
+		app.wg.Wait()
+
 		// Log the status of this shutdown.
 		switch {
 		case sig == syscall.SIGSTOP:
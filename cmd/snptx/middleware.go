@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/auth"
 )
 
 func commonHeaders(next http.Handler) http.Handler {
@@ -84,7 +89,9 @@ func (a *app) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
-// authenticate checks the database for user status (active)
+// authenticate checks the database for user status (active) and stores the
+// roles of the user in the request context. Sessions of deactivated users
+// are logged out.
 func (a *app) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// check if user is logged in
@@ -94,17 +101,131 @@ func (a *app) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		exists, err := a.users.Exists(r.Context(), id)
+		usr, err := a.users.QueryByID(r.Context(), id)
 		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrInvalidID) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			a.serverError(w, r, err)
 			return
 		}
+		if !usr.Active {
+			a.sessionManager.Remove(r.Context(), "authenticatedUserID")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		a.touchSession(r)
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, userRolesContextKey, usr.Roles)
+		ctx = context.WithValue(ctx, isVerifiedContextKey, usr.Verified)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireRole responds with 403 Forbidden unless the authenticated user has
+// at least one of the roles. It must follow requireAuthentication.
+func (a *app) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.hasUserRole(r, roles...) {
+				a.clientError(w, http.StatusForbidden)
+				return
+			}
 
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiAuthenticate verifies the bearer token in the Authorization header of
+// API requests and stores its claims in the request context under auth.Key.
+// Requests without a token are anonymous.
+func (a *app) apiAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authz := r.Header.Get("Authorization")
+		if authz == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// expecting: bearer <token>
+		scheme, tkn, ok := strings.Cut(authz, " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") {
+			a.apiUnauthorized(w)
+			return
+		}
+
+		claims, err := a.tokenClaims(r.Context(), tkn)
+		if err != nil {
+			if errors.Is(err, models.ErrAuthenticationFailure) {
+				a.apiUnauthorized(w)
+			} else {
+				a.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), auth.Key, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tokenClaims verifies the bearer token and returns its claims. Invalid
+// tokens and the tokens of unknown or deactivated users are rejected with
+// models.ErrAuthenticationFailure.
+func (a *app) tokenClaims(ctx context.Context, tkn string) (auth.Claims, error) {
+	// personal access tokens are looked up, all other tokens are JWTs
+	if strings.HasPrefix(tkn, models.AccessTokenPrefix) {
+		return a.tokens.Authenticate(ctx, tkn, time.Now())
+	}
+
+	claims, err := a.auth.ParseClaims(tkn)
+	if err != nil {
+		return auth.Claims{}, models.ErrAuthenticationFailure
+	}
+
+	// signed tokens outlive the deactivation of their user
+	usr, err := a.users.QueryByID(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrInvalidID) {
+			return auth.Claims{}, models.ErrAuthenticationFailure
+		}
+		return auth.Claims{}, err
+	}
+	if !usr.Active {
+		return auth.Claims{}, models.ErrAuthenticationFailure
+	}
+
+	return claims, nil
+}
+
+// apiRequireAuthentication rejects anonymous API requests.
+func (a *app) apiRequireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requestClaims(r); !ok {
+			a.apiUnauthorized(w)
+			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// apiRequireScope rejects API requests whose token is not granted the scope.
+// Anonymous requests pass, they are restricted by the handlers.
+func (a *app) apiRequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := requestClaims(r); ok && !claims.HasScope(scope) {
+				a.apiClientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -61,3 +62,45 @@ func TestCommonHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestAuthenticate(t *testing.T) {
+	app := newTestApp(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isAuthenticated(r) {
+			w.Write([]byte(app.sessionManager.GetString(r.Context(), "authenticatedUserID")))
+		}
+	})
+	h := app.authenticate(next)
+
+	tests := []struct {
+		name     string
+		userID   string
+		wantBody string
+	}{
+		{"Active", "1", "1"},
+		// deactivated users are logged out
+		{"Deactivated", "4", ""},
+		{"Unknown", "5", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, err := app.sessionManager.Load(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.sessionManager.Put(ctx, "authenticatedUserID", tt.userID)
+
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h.ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Body.String(), tt.wantBody)
+		})
+	}
+}
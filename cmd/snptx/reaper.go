@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+)
+
+// reaperMetrics counts the reaper runs, the failed purges and the records
+// purged per target. They are published at /admin/debug/vars.
+var reaperMetrics = expvar.NewMap("reaper")
+
+// purger deletes expired records.
+type purger interface {
+	// DeleteExpired removes at most limit expired records and returns the
+	// number of records removed.
+	DeleteExpired(ctx context.Context, limit int) (int, error)
+}
+
+// reapTarget is a named set of records purged by the reaper, e.g. "snippets".
+type reapTarget struct {
+	name   string
+	purger purger
+}
+
+// reaper periodically purges expired records. Each target is purged in
+// batches of at most batchSize records, so no single statement holds locks
+// on a large number of rows.
+type reaper struct {
+	log       *log.Logger
+	interval  time.Duration
+	batchSize int
+	targets   []reapTarget
+}
+
+// checkReaper reports a reaper configuration that cannot work. A batch size
+// of zero would never come back short, so purge would not stop.
+func checkReaper(interval time.Duration, batchSize int) error {
+	if interval <= 0 {
+		return fmt.Errorf("reaper interval must be positive, got %v", interval)
+	}
+	if batchSize <= 0 {
+		return fmt.Errorf("reaper batch size must be positive, got %d", batchSize)
+	}
+	return nil
+}
+
+// run purges the targets right away and then once per interval until ctx
+// is cancelled.
+func (rp *reaper) run(ctx context.Context) {
+	ticker := time.NewTicker(rp.interval)
+	defer ticker.Stop()
+
+	for {
+		rp.reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reap purges all targets once. A failing target does not keep the others
+// from being purged.
+func (rp *reaper) reap(ctx context.Context) {
+	reaperMetrics.Add("runs", 1)
+
+	for _, t := range rp.targets {
+		n, err := rp.purge(ctx, t.purger)
+		reaperMetrics.Add(t.name, int64(n))
+		if err != nil {
+			if ctx.Err() != nil {
+				// shutting down
+				return
+			}
+			reaperMetrics.Add("errors", 1)
+			rp.log.Printf("reaper: purging expired %s: %v", t.name, err)
+			continue
+		}
+		if n > 0 {
+			rp.log.Printf("reaper: purged %d expired %s", n, t.name)
+		}
+	}
+}
+
+// purge deletes batches of expired records until a batch comes back short.
+func (rp *reaper) purge(ctx context.Context, p purger) (int, error) {
+	var total int
+	for {
+		n, err := p.DeleteExpired(ctx, rp.batchSize)
+		total += n
+		if err != nil || n < rp.batchSize {
+			return total, err
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
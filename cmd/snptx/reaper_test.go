@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tullo/snptx/internal/assert"
+)
+
+// expired is a purger holding a number of expired records.
+type expired struct {
+	n     int
+	calls int
+	err   error
+}
+
+func (e *expired) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	e.calls++
+	if e.err != nil {
+		return 0, e.err
+	}
+	n := min(e.n, limit)
+	e.n -= n
+	return n, nil
+}
+
+// metric returns the current value of a reaper counter.
+func metric(name string) int64 {
+	if v, ok := reaperMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestReaper(t *testing.T) {
+	snippets := &expired{n: 25}
+	broken := &expired{err: errors.New("connection refused")}
+	sessions := &expired{n: 3}
+
+	rp := &reaper{
+		log:       log.New(io.Discard, "", 0),
+		interval:  time.Hour,
+		batchSize: 10,
+		targets: []reapTarget{
+			{"test-snippets", snippets},
+			{"test-broken", broken},
+			{"test-sessions", sessions},
+		},
+	}
+
+	errs := metric("errors")
+	rp.reap(context.Background())
+
+	// 10 + 10 + 5 records
+	assert.Equal(t, snippets.calls, 3)
+	assert.Equal(t, snippets.n, 0)
+	assert.Equal(t, metric("test-snippets"), int64(25))
+
+	// a failing target does not stop the run
+	assert.Equal(t, metric("errors"), errs+1)
+	assert.Equal(t, sessions.n, 0)
+	assert.Equal(t, metric("test-sessions"), int64(3))
+}
+
+func TestReaperShutdown(t *testing.T) {
+	rp := &reaper{
+		log:       log.New(io.Discard, "", 0),
+		interval:  time.Millisecond,
+		batchSize: 10,
+		targets:   []reapTarget{{"test-shutdown", &expired{}}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		rp.run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop")
+	}
+}
+
+func TestCheckReaper(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  time.Duration
+		batchSize int
+		wantErr   bool
+	}{
+		{"Valid", 10 * time.Minute, 500, false},
+		{"Zero Interval", 0, 500, true},
+		{"Negative Interval", -time.Minute, 500, true},
+		{"Zero Batch Size", 10 * time.Minute, 0, true},
+		{"Negative Batch Size", 10 * time.Minute, -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkReaper(tt.interval, tt.batchSize)
+			assert.Equal(t, err != nil, tt.wantErr)
+		})
+	}
+}
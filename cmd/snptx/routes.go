@@ -1,9 +1,12 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/alice"
+	"github.com/tullo/snptx/internal/platform/auth"
 	"github.com/tullo/snptx/ui"
 )
 
@@ -14,6 +17,7 @@ func (a *app) routes() http.Handler {
 	mux.Handle("GET /static/", http.FileServerFS(ui.Files))
 
 	mux.HandleFunc("GET /ping", ping)
+	mux.HandleFunc("GET /static/css/highlight.css", a.highlightCSS)
 	// mux.HandleFunc("GET /favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 	// 	http.ServeFile(w, r, "static/img/favicon.ico")
 	// })
@@ -23,13 +27,32 @@ func (a *app) routes() http.Handler {
 
 	mux.Handle("GET /{$}", dynamic.ThenFunc(a.home))
 	mux.Handle("GET /about", dynamic.ThenFunc(a.about))
+	mux.Handle("GET /snippets", dynamic.ThenFunc(a.snippetList))
+	mux.Handle("GET /search", dynamic.ThenFunc(a.snippetSearch))
+	mux.Handle("GET /tag/{name}", dynamic.ThenFunc(a.tagView))
+	mux.Handle("GET /tags", dynamic.ThenFunc(a.tagSuggestions))
 
 	mux.Handle("GET /snippet/view/{id}", dynamic.ThenFunc(a.snippetView))
+	mux.Handle("GET /snippet/raw/{id}", dynamic.ThenFunc(a.snippetRaw))
+	mux.Handle("GET /snippet/download/{id}", dynamic.ThenFunc(a.snippetDownload))
+	mux.Handle("GET /snippet/{path...}", a.snippetPages(map[string]http.Handler{
+		"revisions": dynamic.ThenFunc(a.snippetRevisions),
+		"diff":      dynamic.ThenFunc(a.snippetDiff),
+	}))
 
 	mux.Handle("GET /user/signup", dynamic.ThenFunc(a.userSignupForm))
 	mux.Handle("POST /user/signup", dynamic.ThenFunc(a.userSignupPost))
 	mux.Handle("GET /user/login", dynamic.ThenFunc(a.loginUserForm))
 	mux.Handle("POST /user/login", dynamic.ThenFunc(a.userLoginPost))
+	mux.Handle("GET /user/login/2fa", dynamic.ThenFunc(a.loginTwoFactorForm))
+	mux.Handle("POST /user/login/2fa", dynamic.ThenFunc(a.loginTwoFactorPost))
+	mux.Handle("GET /user/login/oidc", dynamic.ThenFunc(a.ssoLogin))
+	mux.Handle("GET /user/login/oidc/callback", dynamic.ThenFunc(a.ssoCallback))
+	mux.Handle("GET /user/forgot-password", dynamic.ThenFunc(a.forgotPasswordForm))
+	mux.Handle("POST /user/forgot-password", dynamic.ThenFunc(a.forgotPasswordPost))
+	mux.Handle("GET /user/reset-password/{token}", dynamic.ThenFunc(a.resetPasswordForm))
+	mux.Handle("POST /user/reset-password/{token}", dynamic.ThenFunc(a.resetPasswordPost))
+	mux.Handle("GET /user/verify/{token}", dynamic.ThenFunc(a.verifyEmail))
 
 	protected := dynamic.Append(a.requireAuthentication)
 
@@ -38,11 +61,65 @@ func (a *app) routes() http.Handler {
 	mux.Handle("GET /snippet/edit/{id}", protected.ThenFunc(a.updateSnippetForm))
 	mux.Handle("POST /snippet/edit/{id}", protected.ThenFunc(a.updateSnippetPost))
 	mux.Handle("POST /snippet/delete/{id}", protected.ThenFunc(a.snippetDeletePost))
+	mux.Handle("POST /snippet/{path...}", a.snippetPages(map[string]http.Handler{
+		"restore": protected.ThenFunc(a.snippetRestorePost),
+	}))
 
 	mux.Handle("GET /user/change-password", protected.ThenFunc(a.changePasswordForm))
 	mux.Handle("POST /user/change-password", protected.ThenFunc(a.changePasswordPost))
 	mux.Handle("POST /user/logout", protected.ThenFunc(a.logoutUserPost))
 	mux.Handle("GET /user/profile", protected.ThenFunc(a.userProfile))
+	mux.Handle("GET /user/profile/edit", protected.ThenFunc(a.profileEditForm))
+	mux.Handle("POST /user/profile/edit", protected.ThenFunc(a.profileEditPost))
+	mux.Handle("GET /user/export", protected.ThenFunc(a.userExport))
+	mux.Handle("GET /user/delete", protected.ThenFunc(a.deleteAccountForm))
+	mux.Handle("POST /user/delete", protected.ThenFunc(a.deleteAccountPost))
+	mux.Handle("GET /user/reauth/oidc", protected.ThenFunc(a.ssoReauth))
+	mux.Handle("POST /user/verify/resend", protected.ThenFunc(a.verifyEmailResendPost))
+	mux.Handle("GET /user/2fa", protected.ThenFunc(a.twoFactorPage))
+	mux.Handle("GET /user/2fa/qr.png", protected.ThenFunc(a.twoFactorQRCode))
+	mux.Handle("POST /user/2fa/enroll", protected.ThenFunc(a.twoFactorEnrollPost))
+	mux.Handle("POST /user/2fa/enable", protected.ThenFunc(a.twoFactorEnablePost))
+	mux.Handle("POST /user/2fa/recovery-codes", protected.ThenFunc(a.twoFactorRecoveryCodesPost))
+	mux.Handle("POST /user/2fa/disable", protected.ThenFunc(a.twoFactorDisablePost))
+	mux.Handle("POST /user/token/create", protected.ThenFunc(a.accessTokenCreatePost))
+	mux.Handle("POST /user/token/revoke/{id}", protected.ThenFunc(a.accessTokenRevokePost))
+	mux.Handle("POST /user/session/revoke/{id}", protected.ThenFunc(a.sessionRevokePost))
+	mux.Handle("POST /user/sessions/revoke-others", protected.ThenFunc(a.sessionRevokeOthersPost))
+
+	// the admin area is restricted to admins
+	admin := protected.Append(a.requireRole(auth.RoleAdmin))
+
+	mux.Handle("GET /admin", admin.ThenFunc(a.adminUsers))
+	mux.Handle("POST /admin/user/activate/{id}", admin.ThenFunc(a.adminUserActivatePost))
+	mux.Handle("POST /admin/user/deactivate/{id}", admin.ThenFunc(a.adminUserDeactivatePost))
+	mux.Handle("POST /admin/user/roles/{id}", admin.ThenFunc(a.adminUserRolesPost))
+	mux.Handle("POST /admin/user/2fa/reset/{id}", admin.ThenFunc(a.adminUserResetTwoFactorPost))
+	mux.Handle("GET /admin/snippets", admin.ThenFunc(a.adminSnippets))
+	mux.Handle("GET /admin/audit", admin.ThenFunc(a.adminAudit))
+	mux.Handle("POST /admin/snippet/visibility/{id}", admin.ThenFunc(a.adminSnippetVisibilityPost))
+	mux.Handle("POST /admin/snippet/delete/{id}", admin.ThenFunc(a.adminSnippetDeletePost))
+	// the counters published with expvar, e.g. those of the reaper
+	mux.Handle("GET /admin/debug/vars", admin.Then(expvar.Handler()))
+
+	// the API authenticates each request by itself,
+	// it depends on neither sessions nor CSRF tokens
+	api := alice.New(a.apiAuthenticate)
+
+	// the token is issued for the credentials given with basic authentication
+	mux.HandleFunc("POST /api/v1/token", a.apiToken)
+
+	// personal access tokens are restricted to their scopes
+	apiRead := api.Append(a.apiRequireScope(auth.ScopeSnippetsRead))
+
+	mux.Handle("GET /api/v1/snippets", apiRead.ThenFunc(a.apiSnippetList))
+	mux.Handle("GET /api/v1/snippets/{id}", apiRead.ThenFunc(a.apiSnippetView))
+
+	apiProtected := api.Append(a.apiRequireAuthentication, a.apiRequireScope(auth.ScopeSnippetsWrite))
+
+	mux.Handle("POST /api/v1/snippets", apiProtected.ThenFunc(a.apiSnippetCreate))
+	mux.Handle("PATCH /api/v1/snippets/{id}", apiProtected.ThenFunc(a.apiSnippetUpdate))
+	mux.Handle("DELETE /api/v1/snippets/{id}", apiProtected.ThenFunc(a.apiSnippetDelete))
 
 	// 'standard' middleware used for every request
 	// flow of control: recoverPanic ↔ logRequest ↔ commonHeaders
@@ -52,3 +129,20 @@ func (a *app) routes() http.Handler {
 	// standard ↔ servemux ↔ dynamic ↔ application handler
 	return standard.Then(mux)
 }
+
+// snippetPages routes /snippet/{id}/{page} to the handler of the page, with
+// the id as path value. The pages cannot be registered as patterns of their
+// own, they would conflict with /snippet/view/{id} and its siblings.
+func (a *app) snippetPages(pages map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, page, _ := strings.Cut(r.PathValue("path"), "/")
+		h, ok := pages[page]
+		if !ok || id == "" {
+			a.notFound(w)
+			return
+		}
+
+		r.SetPathValue("id", id)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/auth"
+	"github.com/tullo/snptx/internal/platform/sso"
+)
+
+// ssoLoginTTL is how long a user has to log in at the provider.
+const ssoLoginTTL = 10 * time.Minute
+
+// reauthTTL is how long a re-authentication at the provider confirms the
+// identity of a user without a password.
+const reauthTTL = 5 * time.Minute
+
+// session keys of a single sign-on in progress
+const (
+	ssoStateKey    = "ssoState"
+	ssoNonceKey    = "ssoNonce"
+	ssoVerifierKey = "ssoVerifier"
+	ssoExpiresKey  = "ssoExpires"
+	ssoReauthKey   = "ssoReauth" // the page a re-authentication returns to
+)
+
+// reauthKey is the session key of the time of the last re-authentication.
+const reauthKey = "reauthenticated"
+
+// reauthPages are the pages a re-authentication may return to.
+var reauthPages = map[string]bool{
+	"/user/profile/edit": true,
+	"/user/delete":       true,
+}
+
+// singleSignOn is the login with an OpenID Connect provider.
+type singleSignOn struct {
+	provider *sso.Provider
+	name     string // shown on the login button
+
+	// roleClaim names the claim of the ID token holding the roles or groups
+	// of the user, users with the adminRole among them are admins
+	roleClaim string
+	adminRole string
+}
+
+// roles returns the roles of the user mapped from the ID token, keeping the
+// current roles other than the admin role. ok is false if the ID token does
+// not carry the role claim, the current roles are kept as they are then.
+func (s *singleSignOn) roles(id *sso.Identity, current []string) (roles []string, ok bool) {
+	if s.roleClaim == "" || s.adminRole == "" {
+		return current, false
+	}
+	if _, ok := id.Values(s.roleClaim); !ok {
+		return current, false
+	}
+
+	roles = slices.DeleteFunc(slices.Clone(current), func(role string) bool {
+		return role == auth.RoleAdmin
+	})
+	if !slices.Contains(roles, auth.RoleUser) {
+		roles = append(roles, auth.RoleUser)
+	}
+	if id.HasValue(s.roleClaim, s.adminRole) {
+		roles = append([]string{auth.RoleAdmin}, roles...)
+	}
+	return roles, true
+}
+
+var (
+	// errNoEmail occurs when the ID token of an unknown identity carries no
+	// email address.
+	errNoEmail = errors.New("identity without email address")
+
+	// errEmailTaken occurs when the email address of an unknown identity
+	// belongs to a user, but the provider did not verify it.
+	errEmailTaken = errors.New("email address of unverified identity in use")
+)
+
+// ssoLogin redirects the user to the provider, keeping the secrets of the
+// login in the session.
+func (a *app) ssoLogin(w http.ResponseWriter, r *http.Request) {
+	if a.sso == nil {
+		a.notFound(w)
+		return
+	}
+
+	a.sessionManager.Remove(r.Context(), ssoReauthKey)
+	a.redirectToProvider(w, r, "")
+}
+
+// ssoReauth redirects a user to the provider to confirm their identity, e.g.
+// before deleting an account which has no password. The provider is asked to
+// have the user log in again, the callback returns to the page in next.
+func (a *app) ssoReauth(w http.ResponseWriter, r *http.Request) {
+	if a.sso == nil {
+		a.notFound(w)
+		return
+	}
+
+	next := r.URL.Query().Get("next")
+	if !reauthPages[next] {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), ssoReauthKey, next)
+	a.redirectToProvider(w, r, "login")
+}
+
+// redirectToProvider redirects the user to the provider, keeping the secrets
+// of the login in the session.
+func (a *app) redirectToProvider(w http.ResponseWriter, r *http.Request, prompt string) {
+	req, err := sso.NewRequest()
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	req.Prompt = prompt
+
+	a.sessionManager.Put(r.Context(), ssoStateKey, req.State)
+	a.sessionManager.Put(r.Context(), ssoNonceKey, req.Nonce)
+	a.sessionManager.Put(r.Context(), ssoVerifierKey, req.Verifier)
+	a.sessionManager.Put(r.Context(), ssoExpiresKey, time.Now().Add(ssoLoginTTL).Unix())
+
+	http.Redirect(w, r, a.sso.provider.AuthCodeURL(req), http.StatusSeeOther)
+}
+
+// ssoCallback completes the login the provider redirected back from. Unknown
+// identities are linked to the user with the same verified email address,
+// or a new user is provisioned for them. A re-authentication returns to the
+// page it was started from instead.
+func (a *app) ssoCallback(w http.ResponseWriter, r *http.Request) {
+	if a.sso == nil {
+		a.notFound(w)
+		return
+	}
+
+	// the secrets are used once only
+	req, ok := a.popSSORequest(r)
+	next := a.sessionManager.PopString(r.Context(), ssoReauthKey)
+	back := "/user/login"
+	if next != "" {
+		back = next
+	}
+	if !ok {
+		a.sessionManager.Put(r.Context(), "flash", "Your login has expired, please log in again.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		a.log.Printf("sso: provider returned error %q: %s", e, q.Get("error_description"))
+		a.sessionManager.Put(r.Context(), "flash", "Single sign-on failed, please try again.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	id, err := a.sso.provider.Exchange(r.Context(), req, q.Get("state"), q.Get("code"))
+	if err != nil {
+		if errors.Is(err, sso.ErrInvalidState) {
+			a.clientError(w, http.StatusBadRequest)
+			return
+		}
+		a.log.Printf("sso: %v", err)
+		a.sessionManager.Put(r.Context(), "flash", "Single sign-on failed, please try again.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if next != "" {
+		a.ssoReauthenticated(w, r, id, next)
+		return
+	}
+
+	usr, err := a.ssoUser(r, id)
+	switch {
+	case errors.Is(err, errNoEmail):
+		a.sessionManager.Put(r.Context(), "flash", "Single sign-on failed, your email address is required.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	case errors.Is(err, errEmailTaken):
+		a.sessionManager.Put(r.Context(), "flash", "An account with your email address exists already. Please log in with your password.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	case err != nil:
+		a.serverError(w, r, err)
+		return
+	case !usr.Active:
+		a.sessionManager.Put(r.Context(), "flash", "Your account has been deactivated.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	if roles, ok := a.sso.roles(id, usr.Roles); ok && !slices.Equal(roles, usr.Roles) {
+		err := a.users.Update(r.Context(), usr.ID, models.UpdateUser{Roles: roles}, time.Now())
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	tf, err := a.twoFactor.Status(r.Context(), usr.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if tf.Enabled {
+		a.startTwoFactor(w, r, usr.ID, "sso")
+		return
+	}
+
+	a.completeLogin(w, r, usr.ID, "sso")
+}
+
+// ssoReauthenticated completes a re-authentication, the identity has to be
+// linked to the logged in user.
+func (a *app) ssoReauthenticated(w http.ResponseWriter, r *http.Request, id *sso.Identity, next string) {
+	userID, err := a.identities.Lookup(r.Context(), a.sso.provider.Issuer(), id.Subject)
+	switch {
+	case err == nil && userID == a.authenticatedUserID(r):
+		a.sessionManager.Put(r.Context(), reauthKey, time.Now().Unix())
+		a.sessionManager.Put(r.Context(), "flash", "Your identity has been confirmed.")
+	case err == nil || errors.Is(err, models.ErrNoRecord):
+		a.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You logged in as somebody else at %s, please try again.", a.sso.name))
+	default:
+		a.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// reauthenticated reports whether the user has confirmed their identity at
+// the provider within the last reauthTTL.
+func (a *app) reauthenticated(r *http.Request) bool {
+	at := a.sessionManager.GetInt64(r.Context(), reauthKey)
+	return at != 0 && time.Since(time.Unix(at, 0)) < reauthTTL
+}
+
+// noPassword tells a user whose account was provisioned by single sign-on
+// that it has no password.
+func (a *app) noPassword(w http.ResponseWriter, r *http.Request) {
+	a.sessionManager.Put(r.Context(), "flash", "Your account has no password, you log in with single sign-on.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// popSSORequest removes the secrets of the login in progress from the
+// session and returns them, unless the login has expired.
+func (a *app) popSSORequest(r *http.Request) (sso.Request, bool) {
+	ctx := r.Context()
+	req := sso.Request{
+		State:    a.sessionManager.PopString(ctx, ssoStateKey),
+		Nonce:    a.sessionManager.PopString(ctx, ssoNonceKey),
+		Verifier: a.sessionManager.PopString(ctx, ssoVerifierKey),
+	}
+	expires := a.sessionManager.GetInt64(ctx, ssoExpiresKey)
+	a.sessionManager.Remove(ctx, ssoExpiresKey)
+	if req.State == "" || time.Now().Unix() >= expires {
+		return sso.Request{}, false
+	}
+	return req, true
+}
+
+// ssoUser returns the user linked to the identity. An unknown identity is
+// linked to the user with its email address if the provider verified it,
+// otherwise a new user is provisioned.
+func (a *app) ssoUser(r *http.Request, id *sso.Identity) (*models.User, error) {
+	ctx := r.Context()
+	issuer := a.sso.provider.Issuer()
+	now := time.Now()
+
+	userID, err := a.identities.Lookup(ctx, issuer, id.Subject)
+	if err == nil {
+		return a.users.QueryByID(ctx, userID)
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+	if id.Email == "" {
+		return nil, errNoEmail
+	}
+
+	usr, err := a.users.QueryByEmail(ctx, id.Email)
+	switch {
+	case err == nil:
+		// anybody may claim any email address at some providers
+		if !id.EmailVerified {
+			return nil, errEmailTaken
+		}
+		if err := a.identities.Link(ctx, usr.ID, issuer, id.Subject, now); err != nil {
+			return nil, err
+		}
+		a.recordAudit(r, models.AuditIdentityLink, usr.ID, usr.ID, map[string]string{"issuer": issuer})
+
+		if !usr.Verified {
+			if err := a.users.VerifyEmail(ctx, usr.ID, usr.Email, now); err != nil {
+				return nil, err
+			}
+			usr.Verified = true
+		}
+		return usr, nil
+	case !errors.Is(err, models.ErrNoRecord):
+		return nil, err
+	}
+
+	name := id.Name
+	if name == "" {
+		name = id.Email
+	}
+	roles, _ := a.sso.roles(id, []string{auth.RoleUser})
+	// without a password the user logs in at the provider only
+	nu := models.NewUser{
+		Name:  name,
+		Email: id.Email,
+		Roles: roles,
+	}
+	usr, err = a.users.Create(ctx, nu, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.identities.Link(ctx, usr.ID, issuer, id.Subject, now); err != nil {
+		return nil, err
+	}
+	a.recordAudit(r, models.AuditSignup, usr.ID, usr.ID, map[string]string{"email": usr.Email, "via": "sso"})
+
+	if id.EmailVerified {
+		if err := a.users.VerifyEmail(ctx, usr.ID, usr.Email, now); err != nil {
+			return nil, err
+		}
+		usr.Verified = true
+	}
+
+	return usr, nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tullo/snptx/internal/assert"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/models/mock"
+	"github.com/tullo/snptx/internal/platform/auth"
+	"github.com/tullo/snptx/internal/platform/sso"
+	"github.com/tullo/snptx/internal/platform/sso/ssotest"
+)
+
+// enableSSO configures the single sign-on of the app with a fake provider
+// logging in the user. Members of the group "snptx-admins" are admins.
+func enableSSO(t *testing.T, app *app, u ssotest.User) *ssotest.Provider {
+	idp := ssotest.NewProvider(t, u)
+
+	p, err := sso.New(t.Context(), idp.Config(app.baseURL+"/user/login/oidc/callback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.sso = &singleSignOn{
+		provider:  p,
+		name:      "Example SSO",
+		roleClaim: "groups",
+		adminRole: "snptx-admins",
+	}
+
+	return idp
+}
+
+// ssoLogin starts the single sign-on and logs in at the provider. It returns
+// the path and query of the callback.
+func (ts *testServer) ssoLogin(t *testing.T, idp *ssotest.Provider) string {
+	code, headers, _ := ts.get(t, "/user/login/oidc")
+	if code != http.StatusSeeOther {
+		t.Fatalf("sso login: want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	return idp.Authorize(t, headers.Get("Location")).RequestURI()
+}
+
+func TestSingleSignOnDisabled(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	if strings.Contains(string(body), "/user/login/oidc") {
+		t.Error("want no single sign-on button")
+	}
+
+	code, _, _ := ts.get(t, "/user/login/oidc")
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, _ = ts.get(t, "/user/login/oidc/callback?state=x&code=y")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestSingleSignOn(t *testing.T) {
+	jane := ssotest.User{
+		Subject:       "jane",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane",
+		Claims:        map[string]any{"groups": []string{"staff"}},
+	}
+
+	t.Run("Login Form", func(t *testing.T) {
+		app := newTestApp(t)
+		enableSSO(t, app, jane)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.StringContains(t, string(body), "<a href='/user/login/oidc'>Log in with Example SSO</a>")
+	})
+
+	t.Run("Provision", func(t *testing.T) {
+		app := newTestApp(t)
+		idp := enableSSO(t, app, jane)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, headers, _ := ts.get(t, ts.ssoLogin(t, idp))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/create")
+
+		userID, err := app.identities.Lookup(t.Context(), idp.URL, "jane")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, userID, "100")
+
+		events := app.audit.(*mock.AuditStore).Events()
+		assert.Equal(t, len(events), 2)
+		assert.Equal(t, events[0].Type, models.AuditSignup)
+		assert.Equal(t, events[0].Details["via"], "sso")
+		assert.Equal(t, events[1].Type, models.AuditLogin)
+		assert.Equal(t, events[1].ActorID, "100")
+		assert.Equal(t, events[1].Details["via"], "sso")
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		app := newTestApp(t)
+		idp := enableSSO(t, app, ssotest.User{
+			Subject:       "alice",
+			Email:         "alice@example.com",
+			EmailVerified: true,
+		})
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, headers, _ := ts.get(t, ts.ssoLogin(t, idp))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/create")
+
+		events := app.audit.(*mock.AuditStore).Events()
+		assert.Equal(t, len(events), 2)
+		assert.Equal(t, events[0].Type, models.AuditIdentityLink)
+		assert.Equal(t, events[0].TargetID, "1")
+		assert.Equal(t, events[1].Type, models.AuditLogin)
+		assert.Equal(t, events[1].ActorID, "1")
+
+		// the linked identity logs in as alice, whatever its email address
+		idp.SetUser(ssotest.User{Subject: "alice", Email: "alice@example.org"})
+		_, _, body := ts.get(t, "/user/profile")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, string(body)))
+		ts.postForm(t, "/user/logout", form)
+
+		code, headers, _ = ts.get(t, ts.ssoLogin(t, idp))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/create")
+
+		events = app.audit.(*mock.AuditStore).Events()
+		assert.Equal(t, len(events), 3)
+		assert.Equal(t, events[2].Type, models.AuditLogin)
+		assert.Equal(t, events[2].ActorID, "1")
+	})
+
+	tests := []struct {
+		name      string
+		user      ssotest.User
+		wantFlash string
+	}{
+		{"Unverified Email In Use", ssotest.User{Subject: "carol", Email: "carol@example.com"}, "An account with your email address exists already."},
+		{"No Email", ssotest.User{Subject: "nobody"}, "your email address is required"},
+		{"Deactivated", ssotest.User{Subject: "dave", Email: "dave@example.com", EmailVerified: true}, "Your account has been deactivated."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			idp := enableSSO(t, app, tt.user)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, headers, _ := ts.get(t, ts.ssoLogin(t, idp))
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/login")
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, string(body), tt.wantFlash)
+
+			code, _, _ = ts.get(t, "/user/profile")
+			assert.Equal(t, code, http.StatusSeeOther)
+		})
+	}
+
+	t.Run("Two-Factor", func(t *testing.T) {
+		app := newTestApp(t)
+		idp := enableSSO(t, app, ssotest.User{Subject: "gina", Email: "gina@example.com", EmailVerified: true})
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, headers, _ := ts.get(t, ts.ssoLogin(t, idp))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login/2fa")
+
+		_, _, body := ts.get(t, "/user/login/2fa")
+		form := url.Values{}
+		form.Add("code", "123456")
+		form.Add("csrf_token", extractCSRFToken(t, string(body)))
+		code, headers, _ = ts.postForm(t, "/user/login/2fa", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/create")
+
+		events := app.audit.(*mock.AuditStore).Events()
+		last := events[len(events)-1]
+		assert.Equal(t, last.Type, models.AuditLogin)
+		assert.Equal(t, last.Details["via"], "sso")
+	})
+
+	t.Run("Forged State", func(t *testing.T) {
+		app := newTestApp(t)
+		idp := enableSSO(t, app, jane)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		cb, err := url.Parse(ts.ssoLogin(t, idp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := cb.Query()
+		q.Set("state", "forged")
+		cb.RawQuery = q.Encode()
+
+		code, _, _ := ts.get(t, cb.RequestURI())
+		assert.Equal(t, code, http.StatusBadRequest)
+
+		// the login cannot be retried with the correct state either
+		code, headers, _ := ts.get(t, ts.ssoLogin(t, idp))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/create")
+	})
+
+	t.Run("Replayed Callback", func(t *testing.T) {
+		app := newTestApp(t)
+		idp := enableSSO(t, app, jane)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		callback := ts.ssoLogin(t, idp)
+		code, _, _ := ts.get(t, callback)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, headers, _ := ts.get(t, callback)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.StringContains(t, string(body), "Your login has expired")
+	})
+
+	t.Run("Provider Error", func(t *testing.T) {
+		app := newTestApp(t)
+		enableSSO(t, app, jane)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, _, _ := ts.get(t, "/user/login/oidc")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, headers, _ := ts.get(t, "/user/login/oidc/callback?error=access_denied")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.StringContains(t, string(body), "Single sign-on failed")
+	})
+}
+
+func TestSingleSignOnRoles(t *testing.T) {
+	s := &singleSignOn{roleClaim: "groups", adminRole: "snptx-admins"}
+
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		current []string
+		want    []string
+		wantOK  bool
+	}{
+		{"Grant Admin", map[string]any{"groups": []any{"staff", "snptx-admins"}}, []string{auth.RoleUser}, []string{auth.RoleAdmin, auth.RoleUser}, true},
+		{"Single Value", map[string]any{"groups": "snptx-admins"}, []string{auth.RoleUser}, []string{auth.RoleAdmin, auth.RoleUser}, true},
+		{"Revoke Admin", map[string]any{"groups": []any{"staff"}}, []string{auth.RoleAdmin, auth.RoleUser}, []string{auth.RoleUser}, true},
+		{"No Claim", map[string]any{}, []string{auth.RoleAdmin, auth.RoleUser}, []string{auth.RoleAdmin, auth.RoleUser}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := sso.NewIdentity("subject", tt.claims)
+			got, ok := s.roles(id, tt.current)
+			assert.Equal(t, ok, tt.wantOK)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("want roles %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSingleSignOnWithoutPassword(t *testing.T) {
+	jane := ssotest.User{
+		Subject:       "jane",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane",
+	}
+
+	t.Run("Delete Account", func(t *testing.T) {
+		app := newTestApp(t)
+		idp := enableSSO(t, app, jane)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.get(t, ts.ssoLogin(t, idp))
+		usr, err := app.users.QueryByID(t.Context(), "100")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, usr.SSOOnly, true)
+
+		// there is no password to change
+		code, headers, _ := ts.get(t, "/user/change-password")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/profile")
+
+		_, _, body := ts.get(t, "/user/delete")
+		assert.StringContains(t, string(body), "<a href='/user/reauth/oidc?next=/user/delete'>Confirm your identity with Example SSO</a>")
+
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, string(body)))
+		code, _, body = ts.postForm(t, "/user/delete", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, string(body), "Please confirm your identity with single sign-on first.")
+
+		code, headers, _ = ts.get(t, "/user/reauth/oidc?next=/user/delete")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.StringContains(t, headers.Get("Location"), "prompt=login")
+
+		code, headers, _ = ts.get(t, idp.Authorize(t, headers.Get("Location")).RequestURI())
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/delete")
+
+		_, _, body = ts.get(t, "/user/delete")
+		assert.StringContains(t, string(body), "Your identity has been confirmed.")
+
+		code, headers, _ = ts.postForm(t, "/user/delete", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/")
+	})
+
+	t.Run("Somebody Else", func(t *testing.T) {
+		app := newTestApp(t)
+		idp := enableSSO(t, app, jane)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.get(t, ts.ssoLogin(t, idp))
+
+		idp.SetUser(ssotest.User{Subject: "mallory", Email: "mallory@example.com", EmailVerified: true})
+		_, headers, _ := ts.get(t, "/user/reauth/oidc?next=/user/profile/edit")
+		code, headers, _ := ts.get(t, idp.Authorize(t, headers.Get("Location")).RequestURI())
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/profile/edit")
+
+		_, _, body := ts.get(t, "/user/profile/edit")
+		assert.StringContains(t, string(body), "You logged in as somebody else at Example SSO")
+
+		form := url.Values{}
+		form.Add("name", "Jane")
+		form.Add("email", "jane@example.org")
+		form.Add("csrf_token", extractCSRFToken(t, string(body)))
+		code, _, body = ts.postForm(t, "/user/profile/edit", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, string(body), "Please confirm your identity with single sign-on first.")
+	})
+
+	t.Run("Unknown Page", func(t *testing.T) {
+		app := newTestApp(t)
+		idp := enableSSO(t, app, jane)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.get(t, ts.ssoLogin(t, idp))
+
+		code, _, _ := ts.get(t, "/user/reauth/oidc?next=https://example.org/")
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+}
@@ -7,22 +7,52 @@ import (
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/diff"
+	"github.com/tullo/snptx/internal/platform/highlight"
 	"github.com/tullo/snptx/ui"
 )
 
 type templateData struct {
-	CSRFToken       string
-	CurrentYear     int
-	Flash           string
-	Form            any
-	IsAuthenticated bool
-	Snippet         *models.Snippet
-	Snippets        []models.Snippet
-	User            *models.User
-	Version         string
+	AccessTokens        []models.AccessToken
+	AuditEvents         []models.AuditEvent
+	AuditEventTypes     []string
+	Sessions            []models.Session
+	AuthenticatedUserID string
+	CanModify           bool
+	CSRFToken           string
+	CurrentYear         int
+	Diff                []diff.Hunk
+	DiffFrom            *models.Revision
+	DiffTo              *models.Revision
+	Flash               string
+	Form                any
+	IsAdmin             bool
+	IsAuthenticated     bool
+	Markdown            bool
+	NewAccessToken      string
+	NextPage            string
+	Owners              map[string]string
+	PrevPage            string
+	ReassignSnippets    bool
+	Reauthenticated     bool
+	Rendered            template.HTML
+	Revisions           []models.Revision
+	SearchResults       []models.SearchResult
+	Token               string
+	Snippet             *models.Snippet
+	Snippets            []models.Snippet
+	SSOName             string
+	User                *models.User
+	TwoFactor           *models.TwoFactor
+	TOTPSecret          string
+	RecoveryCodes       []string
+	Users               []models.User
+	Version             string
 }
 
 func humanDate(t time.Time) string {
@@ -42,9 +72,49 @@ func shortID(s string) string {
 	return s[:8]
 }
 
+// languageName returns the display name of a highlighter language.
+func languageName(id string) string {
+	if l, ok := highlight.Lookup(id); ok {
+		return l.Name
+	}
+	return id
+}
+
+// auditLabels describe the types of audit events to users.
+var auditLabels = map[string]string{
+	models.AuditSignup:         "Signed up",
+	models.AuditLogin:          "Logged in",
+	models.AuditLoginFailed:    "Failed login",
+	models.AuditLoginLockout:   "Login locked",
+	models.AuditIdentityLink:   "Linked single sign-on",
+	models.AuditPasswordChange: "Changed password",
+	models.AuditEmailChange:    "Changed email address",
+	models.AuditAccountDelete:  "Deleted account",
+	models.AuditDataExport:     "Exported data",
+	models.AuditRoleChange:     "Changed roles",
+	models.AuditUserActivate:   "Reactivated account",
+	models.AuditUserDeactivate: "Deactivated account",
+	models.AuditTwoFactorReset: "Reset two-factor authentication",
+	models.AuditSnippetDelete:  "Deleted snippet",
+	models.AuditSnippetVisible: "Changed snippet visibility",
+}
+
+// auditLabel returns the description of a type of audit events.
+func auditLabel(eventType string) string {
+	if l, ok := auditLabels[eventType]; ok {
+		return l
+	}
+	return eventType
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
-	"shortID":   shortID,
+	"auditLabel":   auditLabel,
+	"has":          slices.Contains[[]string],
+	"humanDate":    humanDate,
+	"join":         strings.Join,
+	"languageName": languageName,
+	"languages":    func() []highlight.Language { return highlight.Languages },
+	"shortID":      shortID,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"html"
 	"io"
 	"log"
@@ -11,6 +14,9 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -18,6 +24,11 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	"github.com/tullo/snptx/internal/models/mock"
+	"github.com/tullo/snptx/internal/platform/auth"
+	"github.com/tullo/snptx/internal/platform/highlight"
+	"github.com/tullo/snptx/internal/platform/mail"
+	"github.com/tullo/snptx/internal/platform/markdown"
+	"github.com/tullo/snptx/internal/platform/verify"
 )
 
 // Capture the CSRF token value from the HTML for the user signup page
@@ -35,6 +46,15 @@ func extractCSRFToken(t *testing.T, body string) string {
 	return html.UnescapeString(matches[1])
 }
 
+// testKey signs the tokens of all test apps, generating a key per test is slow.
+var testKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
 // newTestApp creates an application struct with mock loggers
 func newTestApp(t *testing.T) *app {
 	// initialize template cache
@@ -48,20 +68,41 @@ func newTestApp(t *testing.T) *app {
 
 	formDecoder := form.NewDecoder()
 
+	keys := auth.NewKeyStore(map[string]*rsa.PrivateKey{"test": testKey()})
+	authenticator, err := auth.New(testKey(), "test", "RS256", keys.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	highlighter := highlight.New("monokai", 16)
+
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
 	// app struct instantiation using the mocks for the loggers and database models
 	return &app{
+		audit:          mock.NewAuditStore(),
+		auth:           authenticator,
+		baseURL:        "https://snptx.test",
 		log:            log.New(io.Discard, "", 0),
+		logins:         mock.NewLoginFailureStore(),
 		debug:          false,
 		formDecoder:    formDecoder,
+		highlighter:    highlighter,
+		identities:     mock.NewIdentityStore(),
+		mailer:         &testMailer{},
+		markdown:       markdown.New(highlighter),
+		resets:         mock.NewPasswordResetStore(),
 		sessionManager: sessionManager,
+		sessions:       mock.NewSessionStore(),
 		shutdown:       shutdown,
 		snippets:       mock.NewSnippetStore(),
 		templateCache:  templateCache,
+		tokens:         mock.NewAccessTokenStore(),
+		twoFactor:      mock.NewTwoFactorStore(),
 		users:          mock.NewUserStore(),
+		verifier:       verify.NewSigner([]byte("0123456789abcdef0123456789abcdef")),
 		version:        "develop",
 	}
 }
@@ -111,10 +152,44 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, []byt
 	return rs.StatusCode, rs.Header, body
 }
 
+// getWithHeader performs a GET request with additional request headers
+func (ts *testServer) getWithHeader(t *testing.T, urlPath string, header http.Header) (int, http.Header, []byte) {
+	req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, body
+}
+
 // postForm method for sending POST requests to the test server
 func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, []byte) {
+	req, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// nosurf (since v1.2) rejects POST requests with 400 Bad Request
+	// unless Sec-Fetch-Site, Origin or Referer shows the same origin,
+	// browsers send Origin on form submissions and so does this client
+	req.Header.Set("Origin", ts.URL)
+
 	// make a POST request against the test server
-	rs, err := ts.Client().PostForm(ts.URL+urlPath, form)
+	rs, err := ts.Client().Do(req)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,3 +202,83 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 
 	return rs.StatusCode, rs.Header, body
 }
+
+// login logs in as the mocked user with the email, e.g. alice@example.com,
+// and returns the CSRF token for subsequent forms.
+func (ts *testServer) login(t *testing.T, email string) string {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, string(body))
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	return csrfToken
+}
+
+// testToken returns a token for the user with the given roles.
+func testToken(t *testing.T, a *app, userID string, roles ...string) string {
+	tkn, err := a.auth.GenerateToken(auth.NewClaims(userID, roles, time.Now(), time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tkn
+}
+
+// apiRequest sends a JSON request to the test server, authenticated by the
+// bearer token unless it is empty.
+func (ts *testServer) apiRequest(t *testing.T, method, urlPath, token, body string) (int, http.Header, []byte) {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+urlPath, rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	b, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, b
+}
+
+// testMailer records the messages instead of sending them.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *testMailer) Send(_ context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// sentMail waits for the background tasks of the app and returns the
+// messages sent so far.
+func sentMail(a *app) []mail.Message {
+	a.wg.Wait()
+	m := a.mailer.(*testMailer)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.sent)
+}
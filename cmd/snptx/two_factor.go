@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/totp"
+	"github.com/tullo/snptx/internal/validator"
+)
+
+const (
+	// twoFactorIssuer names the account in authenticator apps.
+	twoFactorIssuer = "Snippetbox"
+
+	// twoFactorLoginTTL is how long a user who entered the correct password
+	// has to enter the code of their authenticator app.
+	twoFactorLoginTTL = 5 * time.Minute
+)
+
+// session keys of the partially authenticated state between entering the
+// password and entering the code
+const (
+	twoFactorUserIDKey  = "twoFactorUserID"
+	twoFactorExpiresKey = "twoFactorExpires"
+	twoFactorViaKey     = "twoFactorVia"
+)
+
+type twoFactorForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// twoFactorPage renders the enrollment with the QR code to scan, or the controls
+// to manage the enabled two-factor authentication. A user who has not
+// enrolled yet is offered to start the enrollment.
+func (a *app) twoFactorPage(w http.ResponseWriter, r *http.Request) {
+	a.renderTwoFactor(w, r, http.StatusOK, twoFactorForm{}, nil)
+}
+
+// renderTwoFactor renders the two-factor authentication page of the
+// authenticated user. New recovery codes are shown this one time only.
+func (a *app) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, form twoFactorForm, codes []string) {
+	userID := a.authenticatedUserID(r)
+
+	tf, err := a.twoFactor.Status(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(r)
+	data.TwoFactor = tf
+	data.RecoveryCodes = codes
+	data.Form = form
+
+	if tf.Pending {
+		secret, err := a.twoFactor.Secret(r.Context(), userID)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+		// for authenticator apps which cannot scan QR codes
+		data.TOTPSecret = totp.Encode(secret)
+	}
+
+	a.render(w, r, status, "two_factor.tmpl", data)
+}
+
+// twoFactorQRCode responds with the QR code of the TOTP secret the user
+// enrolls with.
+func (a *app) twoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	usr, err := a.users.QueryByID(r.Context(), a.authenticatedUserID(r))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	secret, err := a.twoFactor.Secret(r.Context(), usr.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrTwoFactorEnabled) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	png, err := totp.QRCode(totp.URI(twoFactorIssuer, usr.Email, secret), 256)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// the image holds the secret
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// twoFactorEnrollPost starts the enrollment by generating the TOTP secret
+// the user adds to their authenticator app.
+func (a *app) twoFactorEnrollPost(w http.ResponseWriter, r *http.Request) {
+	_, err := a.twoFactor.Enroll(r.Context(), a.authenticatedUserID(r), time.Now())
+	if err != nil && !errors.Is(err, models.ErrTwoFactorEnabled) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+// twoFactorEnablePost completes the enrollment with a code of the
+// authenticator app and shows the recovery codes.
+func (a *app) twoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	form, ok := a.decodeTwoFactorForm(w, r)
+	if !ok {
+		return
+	}
+
+	var codes []string
+	if form.Valid() {
+		var err error
+		codes, err = a.twoFactor.Enable(r.Context(), a.authenticatedUserID(r), form.Code, time.Now())
+		switch {
+		case errors.Is(err, models.ErrInvalidCode):
+			form.AddFieldError("code", "The code is incorrect")
+		case errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrTwoFactorEnabled):
+			http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+			return
+		case err != nil:
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		a.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+
+	a.renderTwoFactor(w, r, http.StatusOK, twoFactorForm{}, codes)
+}
+
+// twoFactorRecoveryCodesPost replaces the recovery codes after checking a
+// code of the authenticator app.
+func (a *app) twoFactorRecoveryCodesPost(w http.ResponseWriter, r *http.Request) {
+	form, ok := a.verifyTwoFactorForm(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := a.twoFactor.RegenerateRecoveryCodes(r.Context(), a.authenticatedUserID(r), time.Now())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.renderTwoFactor(w, r, http.StatusOK, form, codes)
+}
+
+// twoFactorDisablePost disables the two-factor authentication after checking
+// a code of the authenticator app or a recovery code.
+func (a *app) twoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.verifyTwoFactorForm(w, r); !ok {
+		return
+	}
+
+	if err := a.twoFactor.Disable(r.Context(), a.authenticatedUserID(r)); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Two-factor authentication disabled!")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// decodeTwoFactorForm decodes and validates the posted code. It writes a
+// response and returns false if the form cannot be decoded.
+func (a *app) decodeTwoFactorForm(w http.ResponseWriter, r *http.Request) (twoFactorForm, bool) {
+	var form twoFactorForm
+
+	if err := a.decodePostForm(r, &form); err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return form, false
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	return form, true
+}
+
+// verifyTwoFactorForm checks the posted code of the authenticated user. It
+// renders the two-factor authentication page and returns false unless the
+// code is correct.
+func (a *app) verifyTwoFactorForm(w http.ResponseWriter, r *http.Request) (twoFactorForm, bool) {
+	form, ok := a.decodeTwoFactorForm(w, r)
+	if !ok {
+		return form, false
+	}
+
+	if form.Valid() {
+		err := a.twoFactor.Verify(r.Context(), a.authenticatedUserID(r), form.Code, time.Now())
+		switch {
+		case errors.Is(err, models.ErrInvalidCode):
+			form.AddFieldError("code", "The code is incorrect")
+		case err != nil:
+			a.serverError(w, r, err)
+			return form, false
+		}
+	}
+
+	if !form.Valid() {
+		a.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form, nil)
+		return form, false
+	}
+
+	return twoFactorForm{}, true
+}
+
+// loginTwoFactorForm asks users who entered the correct password for the
+// code of their authenticator app.
+func (a *app) loginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.pendingTwoFactor(r); !ok {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := a.newTemplateData(r)
+	data.Form = twoFactorForm{}
+	a.render(w, r, http.StatusOK, "login_2fa.tmpl", data)
+}
+
+// loginTwoFactorPost completes the login with a code of the authenticator
+// app or a recovery code. Wrong codes are counted like wrong passwords, once
+// the account or the IP address is locked the user has to start over with
+// the password.
+func (a *app) loginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.pendingTwoFactor(r)
+	if !ok {
+		a.sessionManager.Put(r.Context(), "flash", "Your login has expired, please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	form, ok := a.decodeTwoFactorForm(w, r)
+	if !ok {
+		return
+	}
+
+	if form.Valid() {
+		_, err := a.checkSecondFactor(r, userID, form.Code)
+		switch {
+		case errors.Is(err, models.ErrRateLimited):
+			a.clearTwoFactor(r)
+			a.sessionManager.Put(r.Context(), "flash", "Too many failed login attempts, please log in again later.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		case errors.Is(err, models.ErrInvalidCode):
+			form.AddFieldError("code", "The code is incorrect")
+		case err != nil:
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := a.newTemplateData(r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "login_2fa.tmpl", data)
+		return
+	}
+
+	via := a.sessionManager.GetString(r.Context(), twoFactorViaKey)
+	a.clearTwoFactor(r)
+	a.completeLogin(w, r, userID, via)
+}
+
+// startTwoFactor puts the session into the partially authenticated state
+// after the user entered the correct password or logged in with single
+// sign-on.
+func (a *app) startTwoFactor(w http.ResponseWriter, r *http.Request, userID, via string) {
+	if err := a.sessionManager.RenewToken(r.Context()); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), twoFactorUserIDKey, userID)
+	a.sessionManager.Put(r.Context(), twoFactorExpiresKey, time.Now().Add(twoFactorLoginTTL).Unix())
+	a.sessionManager.Put(r.Context(), twoFactorViaKey, via)
+
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
+
+// pendingTwoFactor returns the ID of the user who entered the correct
+// password but not yet the code.
+func (a *app) pendingTwoFactor(r *http.Request) (string, bool) {
+	userID := a.sessionManager.GetString(r.Context(), twoFactorUserIDKey)
+	if userID == "" || time.Now().Unix() >= a.sessionManager.GetInt64(r.Context(), twoFactorExpiresKey) {
+		return "", false
+	}
+	return userID, true
+}
+
+// clearTwoFactor removes the partially authenticated state from the session.
+func (a *app) clearTwoFactor(r *http.Request) {
+	a.sessionManager.Remove(r.Context(), twoFactorUserIDKey)
+	a.sessionManager.Remove(r.Context(), twoFactorExpiresKey)
+	a.sessionManager.Remove(r.Context(), twoFactorViaKey)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tullo/snptx/internal/assert"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/models/mock"
+)
+
+// loginTwoFactor logs in as gina, who has enabled two-factor authentication,
+// and returns the CSRF token for subsequent forms.
+func (ts *testServer) loginTwoFactor(t *testing.T) string {
+	csrfToken := ts.login(t, "gina@example.com")
+
+	form := url.Values{}
+	form.Add("code", "123456")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login 2fa: want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	return csrfToken
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// the second step needs the first one
+	code, headers, _ := ts.get(t, "/user/login/2fa")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, string(body))
+
+	form := url.Values{}
+	form.Add("email", "gina@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, headers, _ = ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login/2fa")
+
+	// the session is not authenticated yet
+	code, headers, _ = ts.get(t, "/user/profile")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	code, _, body = ts.get(t, "/user/login/2fa")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "<form action='/user/login/2fa' method='POST' novalidate>")
+	csrfToken = extractCSRFToken(t, string(body))
+
+	tests := []struct {
+		name         string
+		code         string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{"Blank Code", "", http.StatusUnprocessableEntity, "", "This field cannot be blank"},
+		{"Wrong Code", "654321", http.StatusUnprocessableEntity, "", "The code is incorrect"},
+		{"Valid Code", "123456", http.StatusSeeOther, "/snippet/create", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/user/login/2fa", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+
+	code, _, _ = ts.get(t, "/user/profile")
+	assert.Equal(t, code, http.StatusOK)
+
+	// the partially authenticated state is gone
+	code, _, _ = ts.get(t, "/user/login/2fa")
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestLoginTwoFactorRecoveryCode(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "gina@example.com")
+
+	form := url.Values{}
+	form.Add("code", "ABCD-EFGH-IJKL-MNOP")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, _, _ = ts.get(t, "/user/profile")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestLoginTwoFactorAttempts(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "gina@example.com")
+
+	form := url.Values{}
+	form.Add("code", "654321")
+	form.Add("csrf_token", csrfToken)
+	for range models.DefaultLockoutPolicy.AccountThreshold {
+		code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	// the wrong codes are counted against the account like wrong passwords
+	failed := 0
+	for _, e := range app.audit.(*mock.AuditStore).Events() {
+		if e.Type == models.AuditLoginFailed && e.Details["factor"] == "2fa" {
+			failed++
+		}
+	}
+	assert.Equal(t, failed, models.DefaultLockoutPolicy.AccountThreshold)
+
+	// the correct code does not help anymore
+	form.Set("code", "123456")
+	code, headers, _ := ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	_, _, body := ts.get(t, "/user/login")
+	assert.StringContains(t, string(body), "Too many failed login attempts, please log in again later.")
+
+	// and neither does starting over with the password
+	code, headers, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	form = url.Values{}
+	form.Add("email", "gina@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, string(body)))
+	code, _, _ = ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	code, _, _ = ts.get(t, "/user/profile")
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestTwoFactorEnroll(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com")
+
+	// viewing the page does not enroll
+	code, _, body := ts.get(t, "/user/2fa")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "<form action='/user/2fa/enroll' method='POST'>")
+	code, _, _ = ts.get(t, "/user/2fa/qr.png")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, headers, _ := ts.postForm(t, "/user/2fa/enroll", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/2fa")
+
+	code, _, body = ts.get(t, "/user/2fa")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "<img src='/user/2fa/qr.png'")
+	assert.StringContains(t, string(body), "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+
+	code, headers, body = ts.get(t, "/user/2fa/qr.png")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "image/png")
+	assert.Equal(t, headers.Get("Cache-Control"), "no-store")
+	assert.Equal(t, strings.HasPrefix(string(body), "\x89PNG"), true)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{"Blank Code", "", http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"Wrong Code", "654321", http.StatusUnprocessableEntity, "The code is incorrect"},
+		{"Valid Code", "123456", http.StatusOK, "ABCD-EFGH-IJKL-MNOP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/2fa/enable", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestTwoFactorManage(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.loginTwoFactor(t)
+
+	code, _, body := ts.get(t, "/user/2fa")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, string(body), "You have 9 unused recovery codes.")
+
+	// there is nothing to enroll with
+	code, _, _ = ts.get(t, "/user/2fa/qr.png")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	tests := []struct {
+		name         string
+		path         string
+		code         string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{"Regenerate Wrong Code", "/user/2fa/recovery-codes", "654321", http.StatusUnprocessableEntity, "", "The code is incorrect"},
+		{"Regenerate", "/user/2fa/recovery-codes", "123456", http.StatusOK, "", "QRST-UVWX-YZ23-4567"},
+		{"Disable Blank Code", "/user/2fa/disable", "", http.StatusUnprocessableEntity, "", "This field cannot be blank"},
+		{"Disable", "/user/2fa/disable", "123456", http.StatusSeeOther, "/user/profile", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, tt.path, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestAdminResetTwoFactor(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	form := url.Values{}
+
+	// only admins may reset
+	form.Add("csrf_token", ts.login(t, "alice@example.com"))
+	code, _, _ := ts.postForm(t, "/admin/user/2fa/reset/14", form)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	form.Set("csrf_token", ts.login(t, "carol@example.com"))
+
+	tests := []struct {
+		name      string
+		id        string
+		wantFlash string
+	}{
+		{"Enabled", "14", "Two-factor authentication reset!"},
+		{"Not Enabled", "1", "The user has not enabled two-factor authentication."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.postForm(t, "/admin/user/2fa/reset/"+tt.id, form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/admin")
+
+			_, _, body := ts.get(t, "/admin")
+			assert.StringContains(t, string(body), html.EscapeString(tt.wantFlash))
+		})
+	}
+
+	// only the actual reset is audited
+	var resets []models.AuditEvent
+	for _, e := range app.audit.(*mock.AuditStore).Events() {
+		if e.Type == models.AuditTwoFactorReset {
+			resets = append(resets, e)
+		}
+	}
+	assert.Equal(t, len(resets), 1)
+	assert.Equal(t, resets[0].ActorID, "9")
+	assert.Equal(t, resets[0].TargetID, "14")
+}
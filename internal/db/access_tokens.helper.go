@@ -0,0 +1,21 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// GetCreateAccessTokenParams builds the parameters for storing a token. A
+// zero expiry time is stored as NULL, the token never expires.
+func GetCreateAccessTokenParams(id, user, name string, hash []byte, scopes []string, exp, create time.Time) CreateAccessTokenParams {
+	return CreateAccessTokenParams{
+		TokenID:     id,
+		UserID:      user,
+		Name:        name,
+		TokenHash:   hash,
+		Scopes:      scopes,
+		DateExpires: pgtype.Timestamptz{Time: exp, Valid: !exp.IsZero()},
+		DateCreated: pgtype.Timestamptz{Time: create, Valid: true},
+	}
+}
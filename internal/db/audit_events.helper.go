@@ -0,0 +1,27 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// GetCreateAuditEventParams builds the parameters to append an audit event.
+// An empty actor ID, target ID, IP address or user agent is stored as NULL.
+func GetCreateAuditEventParams(id, eventType, actorID, targetID, ip, userAgent string,
+	details []byte, created time.Time) CreateAuditEventParams {
+	p := CreateAuditEventParams{
+		EventID:     id,
+		EventType:   eventType,
+		TargetID:    pgtype.Text{String: targetID, Valid: targetID != ""},
+		Ip:          pgtype.Text{String: ip, Valid: ip != ""},
+		UserAgent:   pgtype.Text{String: userAgent, Valid: userAgent != ""},
+		Details:     details,
+		DateCreated: pgtype.Timestamptz{Time: created, Valid: true},
+	}
+	if actorID != "" {
+		// invalid IDs are stored as NULL as well
+		_ = p.ActorID.Scan(actorID)
+	}
+	return p
+}
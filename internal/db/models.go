@@ -8,6 +8,59 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AccessToken struct {
+	TokenID      string
+	UserID       string
+	Name         string
+	TokenHash    []byte
+	Scopes       []string
+	DateExpires  pgtype.Timestamptz
+	DateLastUsed pgtype.Timestamptz
+	DateCreated  pgtype.Timestamptz
+}
+
+type AuditEvent struct {
+	EventID     string
+	EventType   string
+	ActorID     pgtype.UUID
+	TargetID    pgtype.Text
+	Ip          pgtype.Text
+	UserAgent   pgtype.Text
+	Details     []byte
+	DateCreated pgtype.Timestamptz
+}
+
+type LoginFailure struct {
+	FailureKey  string
+	Failures    int64
+	LockedUntil pgtype.Timestamptz
+	DateUpdated pgtype.Timestamptz
+}
+
+type PasswordReset struct {
+	TokenHash   []byte
+	UserID      string
+	DateExpires pgtype.Timestamptz
+	DateCreated pgtype.Timestamptz
+}
+
+type RecoveryCode struct {
+	UserID      string
+	CodeHash    []byte
+	DateCreated pgtype.Timestamptz
+}
+
+type Session struct {
+	Token        string
+	Data         []byte
+	Expiry       pgtype.Timestamp
+	UserID       pgtype.UUID
+	DateCreated  pgtype.Timestamptz
+	DateLastSeen pgtype.Timestamptz
+	Ip           pgtype.Text
+	UserAgent    pgtype.Text
+}
+
 type Snippet struct {
 	SnippetID   string
 	Title       pgtype.Text
@@ -15,15 +68,54 @@ type Snippet struct {
 	DateExpires pgtype.Timestamptz
 	DateCreated pgtype.Timestamptz
 	DateUpdated pgtype.Timestamptz
+	OwnerID     string
+	Visibility  string
+	Language    string
+}
+
+type SnippetRevision struct {
+	RevisionID  string
+	SnippetID   string
+	AuthorID    string
+	Title       pgtype.Text
+	Content     pgtype.Text
+	DateCreated pgtype.Timestamptz
+}
+
+type SnippetTag struct {
+	SnippetID string
+	TagID     string
+}
+
+type Tag struct {
+	TagID string
+	Name  string
 }
 
 type User struct {
-	UserID       string
-	Name         pgtype.Text
-	Email        pgtype.Text
-	Active       pgtype.Bool
-	Roles        []string
-	PasswordHash pgtype.Text
-	DateCreated  pgtype.Timestamptz
-	DateUpdated  pgtype.Timestamptz
+	UserID               string
+	Name                 pgtype.Text
+	Email                pgtype.Text
+	Active               pgtype.Bool
+	Roles                []string
+	PasswordHash         pgtype.Text
+	DateCreated          pgtype.Timestamptz
+	DateUpdated          pgtype.Timestamptz
+	DateVerified         pgtype.Timestamptz
+	DateVerificationSent pgtype.Timestamptz
+}
+
+type UserIdentity struct {
+	Issuer      string
+	Subject     string
+	UserID      string
+	DateCreated pgtype.Timestamptz
+}
+
+type UserTotp struct {
+	UserID      string
+	Secret      []byte
+	LastStep    int64
+	DateEnabled pgtype.Timestamptz
+	DateCreated pgtype.Timestamptz
 }
@@ -0,0 +1,16 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func GetCreatePasswordResetParams(hash []byte, user string, exp, create time.Time) CreatePasswordResetParams {
+	return CreatePasswordResetParams{
+		TokenHash:   hash,
+		UserID:      user,
+		DateExpires: pgtype.Timestamptz{Time: exp, Valid: true},
+		DateCreated: pgtype.Timestamptz{Time: create, Valid: true},
+	}
+}
@@ -0,0 +1,18 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func GetCreateSnippetRevisionParams(id, snippet, author, title, content string, create time.Time) CreateSnippetRevisionParams {
+	return CreateSnippetRevisionParams{
+		RevisionID:  id,
+		SnippetID:   snippet,
+		AuthorID:    author,
+		Title:       pgtype.Text{String: title, Valid: true},
+		Content:     pgtype.Text{String: content, Valid: true},
+		DateCreated: pgtype.Timestamptz{Time: create, Valid: true},
+	}
+}
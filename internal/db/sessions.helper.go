@@ -0,0 +1,27 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// GetCommitSessionParams builds the parameters to store a session. An empty
+// user ID, IP address or user agent and zero times are stored as NULL.
+func GetCommitSessionParams(token string, data []byte, expiry time.Time, userID string,
+	created, lastSeen time.Time, ip, userAgent string) CommitSessionParams {
+	p := CommitSessionParams{
+		Token:        token,
+		Data:         data,
+		Expiry:       pgtype.Timestamp{Time: expiry, Valid: true},
+		DateCreated:  pgtype.Timestamptz{Time: created, Valid: !created.IsZero()},
+		DateLastSeen: pgtype.Timestamptz{Time: lastSeen, Valid: !lastSeen.IsZero()},
+		Ip:           pgtype.Text{String: ip, Valid: ip != ""},
+		UserAgent:    pgtype.Text{String: userAgent, Valid: userAgent != ""},
+	}
+	if userID != "" {
+		// invalid IDs are stored as NULL as well
+		_ = p.UserID.Scan(userID)
+	}
+	return p
+}
@@ -6,11 +6,14 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-func GetCreateSnippetParams(id, title, content string, exp, create, up time.Time) CreateSnippetParams {
+func GetCreateSnippetParams(id, owner, title, content, visibility, language string, exp, create, up time.Time) CreateSnippetParams {
 	return CreateSnippetParams{
 		SnippetID:   id,
+		OwnerID:     owner,
 		Title:       pgtype.Text{String: title, Valid: true},
 		Content:     pgtype.Text{String: content, Valid: true},
+		Visibility:  visibility,
+		Language:    language,
 		DateExpires: pgtype.Timestamptz{Time: exp, Valid: true},
 		DateCreated: pgtype.Timestamptz{Time: create, Valid: true},
 		DateUpdated: pgtype.Timestamptz{Time: up, Valid: true},
@@ -18,11 +21,13 @@ func GetCreateSnippetParams(id, title, content string, exp, create, up time.Time
 
 }
 
-func GetUpdateSnippetParams(id, title, content string, exp, up time.Time) UpdateSnippetParams {
+func GetUpdateSnippetParams(id, title, content, visibility, language string, exp, up time.Time) UpdateSnippetParams {
 	return UpdateSnippetParams{
 		SnippetID:   id,
 		Title:       pgtype.Text{String: title, Valid: true},
 		Content:     pgtype.Text{String: content, Valid: true},
+		Visibility:  visibility,
+		Language:    language,
 		DateExpires: pgtype.Timestamptz{Time: exp, Valid: true},
 		DateUpdated: pgtype.Timestamptz{Time: up, Valid: true},
 	}
@@ -13,16 +13,19 @@ import (
 
 const createSnippet = `-- name: CreateSnippet :one
 INSERT INTO snippets
-  (snippet_id, title, content, date_expires, date_created, date_updated)
+  (snippet_id, owner_id, title, content, visibility, language, date_expires, date_created, date_updated)
   VALUES
-    ($1, $2, $3, $4, $5, $6)
-  RETURNING snippet_id, title, content, date_expires, date_created, date_updated
+    ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+  RETURNING snippet_id, title, content, date_expires, date_created, date_updated, owner_id, visibility, language
 `
 
 type CreateSnippetParams struct {
 	SnippetID   string
+	OwnerID     string
 	Title       pgtype.Text
 	Content     pgtype.Text
+	Visibility  string
+	Language    string
 	DateExpires pgtype.Timestamptz
 	DateCreated pgtype.Timestamptz
 	DateUpdated pgtype.Timestamptz
@@ -31,8 +34,11 @@ type CreateSnippetParams struct {
 func (q *Queries) CreateSnippet(ctx context.Context, arg CreateSnippetParams) (Snippet, error) {
 	row := q.db.QueryRow(ctx, createSnippet,
 		arg.SnippetID,
+		arg.OwnerID,
 		arg.Title,
 		arg.Content,
+		arg.Visibility,
+		arg.Language,
 		arg.DateExpires,
 		arg.DateCreated,
 		arg.DateUpdated,
@@ -45,10 +51,31 @@ func (q *Queries) CreateSnippet(ctx context.Context, arg CreateSnippetParams) (S
 		&i.DateExpires,
 		&i.DateCreated,
 		&i.DateUpdated,
+		&i.OwnerID,
+		&i.Visibility,
+		&i.Language,
 	)
 	return i, err
 }
 
+const deleteExpiredSnippets = `-- name: DeleteExpiredSnippets :execrows
+DELETE FROM snippets
+  WHERE snippet_id IN (
+    SELECT snippet_id FROM snippets
+      WHERE date_expires <= NOW()
+      ORDER BY date_expires
+      LIMIT $1
+  )
+`
+
+func (q *Queries) DeleteExpiredSnippets(ctx context.Context, maxRows int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredSnippets, maxRows)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteSnippet = `-- name: DeleteSnippet :exec
 DELETE FROM snippets
   WHERE snippet_id = $1
@@ -59,8 +86,21 @@ func (q *Queries) DeleteSnippet(ctx context.Context, snippetID string) error {
 	return err
 }
 
+const deleteUserSnippets = `-- name: DeleteUserSnippets :execrows
+DELETE FROM snippets
+  WHERE owner_id = $1
+`
+
+func (q *Queries) DeleteUserSnippets(ctx context.Context, ownerID string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserSnippets, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getSnippet = `-- name: GetSnippet :one
-SELECT snippet_id, title, content, date_expires, date_created, date_updated FROM snippets
+SELECT snippet_id, title, content, date_expires, date_created, date_updated, owner_id, visibility, language FROM snippets
   WHERE snippet_id = $1 LIMIT 1
 `
 
@@ -74,19 +114,43 @@ func (q *Queries) GetSnippet(ctx context.Context, snippetID string) (Snippet, er
 		&i.DateExpires,
 		&i.DateCreated,
 		&i.DateUpdated,
+		&i.OwnerID,
+		&i.Visibility,
+		&i.Language,
+	)
+	return i, err
+}
+
+const getSnippetForUpdate = `-- name: GetSnippetForUpdate :one
+SELECT snippet_id, title, content, date_expires, date_created, date_updated, owner_id, visibility, language FROM snippets
+  WHERE snippet_id = $1 LIMIT 1
+  FOR UPDATE
+`
+
+func (q *Queries) GetSnippetForUpdate(ctx context.Context, snippetID string) (Snippet, error) {
+	row := q.db.QueryRow(ctx, getSnippetForUpdate, snippetID)
+	var i Snippet
+	err := row.Scan(
+		&i.SnippetID,
+		&i.Title,
+		&i.Content,
+		&i.DateExpires,
+		&i.DateCreated,
+		&i.DateUpdated,
+		&i.OwnerID,
+		&i.Visibility,
+		&i.Language,
 	)
 	return i, err
 }
 
-const listLatestSnippets = `-- name: ListLatestSnippets :many
-SELECT snippet_id, title, content, date_expires, date_created, date_updated FROM snippets
-	WHERE date_expires > NOW()
-	ORDER BY date_created DESC
-	LIMIT 10
+const listSnippets = `-- name: ListSnippets :many
+SELECT snippet_id, title, content, date_expires, date_created, date_updated, owner_id, visibility, language FROM snippets
+  ORDER BY title
 `
 
-func (q *Queries) ListLatestSnippets(ctx context.Context) ([]Snippet, error) {
-	rows, err := q.db.Query(ctx, listLatestSnippets)
+func (q *Queries) ListSnippets(ctx context.Context) ([]Snippet, error) {
+	rows, err := q.db.Query(ctx, listSnippets)
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +165,9 @@ func (q *Queries) ListLatestSnippets(ctx context.Context) ([]Snippet, error) {
 			&i.DateExpires,
 			&i.DateCreated,
 			&i.DateUpdated,
+			&i.OwnerID,
+			&i.Visibility,
+			&i.Language,
 		); err != nil {
 			return nil, err
 		}
@@ -112,20 +179,64 @@ func (q *Queries) ListLatestSnippets(ctx context.Context) ([]Snippet, error) {
 	return items, nil
 }
 
-const listSnippets = `-- name: ListSnippets :many
-SELECT snippet_id, title, content, date_expires, date_created, date_updated FROM snippets
-  ORDER BY title
+const reassignUserSnippets = `-- name: ReassignUserSnippets :execrows
+UPDATE snippets
+  SET owner_id = $1
+  WHERE owner_id = $2
 `
 
-func (q *Queries) ListSnippets(ctx context.Context) ([]Snippet, error) {
-	rows, err := q.db.Query(ctx, listSnippets)
+type ReassignUserSnippetsParams struct {
+	SuccessorID string
+	UserID      string
+}
+
+func (q *Queries) ReassignUserSnippets(ctx context.Context, arg ReassignUserSnippetsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, reassignUserSnippets, arg.SuccessorID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const searchSnippets = `-- name: SearchSnippets :many
+SELECT snippet_id, title, content, date_expires, date_created, date_updated, owner_id, visibility, language,
+    ts_rank(to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(content, '')), to_tsquery('simple', $1::STRING))::FLOAT8 AS rank
+  FROM snippets
+  WHERE to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(content, '')) @@ to_tsquery('simple', $1::STRING)
+    AND (visibility = 'public' OR owner_id::STRING = $2::STRING)
+    AND date_expires > NOW()
+  ORDER BY rank DESC, date_created DESC
+  LIMIT $3
+`
+
+type SearchSnippetsParams struct {
+	Query      string
+	ViewerID   string
+	MaxResults int32
+}
+
+type SearchSnippetsRow struct {
+	SnippetID   string
+	Title       pgtype.Text
+	Content     pgtype.Text
+	DateExpires pgtype.Timestamptz
+	DateCreated pgtype.Timestamptz
+	DateUpdated pgtype.Timestamptz
+	OwnerID     string
+	Visibility  string
+	Language    string
+	Rank        float64
+}
+
+func (q *Queries) SearchSnippets(ctx context.Context, arg SearchSnippetsParams) ([]SearchSnippetsRow, error) {
+	rows, err := q.db.Query(ctx, searchSnippets, arg.Query, arg.ViewerID, arg.MaxResults)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Snippet
+	var items []SearchSnippetsRow
 	for rows.Next() {
-		var i Snippet
+		var i SearchSnippetsRow
 		if err := rows.Scan(
 			&i.SnippetID,
 			&i.Title,
@@ -133,6 +244,10 @@ func (q *Queries) ListSnippets(ctx context.Context) ([]Snippet, error) {
 			&i.DateExpires,
 			&i.DateCreated,
 			&i.DateUpdated,
+			&i.OwnerID,
+			&i.Visibility,
+			&i.Language,
+			&i.Rank,
 		); err != nil {
 			return nil, err
 		}
@@ -149,8 +264,10 @@ UPDATE snippets
   SET
     "title" = $2,
     "content" = $3,
-    "date_expires" = $4,
-    "date_updated" = $5
+    "visibility" = $4,
+    "language" = $5,
+    "date_expires" = $6,
+    "date_updated" = $7
   WHERE snippet_id = $1
 `
 
@@ -158,6 +275,8 @@ type UpdateSnippetParams struct {
 	SnippetID   string
 	Title       pgtype.Text
 	Content     pgtype.Text
+	Visibility  string
+	Language    string
 	DateExpires pgtype.Timestamptz
 	DateUpdated pgtype.Timestamptz
 }
@@ -167,6 +286,8 @@ func (q *Queries) UpdateSnippet(ctx context.Context, arg UpdateSnippetParams) er
 		arg.SnippetID,
 		arg.Title,
 		arg.Content,
+		arg.Visibility,
+		arg.Language,
 		arg.DateExpires,
 		arg.DateUpdated,
 	)
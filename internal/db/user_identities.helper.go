@@ -0,0 +1,16 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func GetCreateUserIdentityParams(issuer, subject, user string, created time.Time) CreateUserIdentityParams {
+	return CreateUserIdentityParams{
+		Issuer:      issuer,
+		Subject:     subject,
+		UserID:      user,
+		DateCreated: pgtype.Timestamptz{Time: created, Valid: true},
+	}
+}
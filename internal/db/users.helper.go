@@ -14,21 +14,22 @@ func GetCreateUserParams(id, name, email string, active bool,
 		Email:        pgtype.Text{String: email, Valid: true},
 		Active:       pgtype.Bool{Bool: active, Valid: true},
 		Roles:        roles,
-		PasswordHash: pgtype.Text{String: hash, Valid: true},
+		PasswordHash: pgtype.Text{String: hash, Valid: hash != ""},
 		DateCreated:  pgtype.Timestamptz{Time: create, Valid: true},
 		DateUpdated:  pgtype.Timestamptz{Time: up, Valid: true},
 	}
 
 }
 
-func GetUpdateUserParams(id, name, email string, roles []string,
+func GetUpdateUserParams(id, name, email string, active bool, roles []string,
 	hash string, up time.Time) UpdateUserParams {
 	return UpdateUserParams{
 		UserID:       id,
 		Name:         pgtype.Text{String: name, Valid: true},
 		Email:        pgtype.Text{String: email, Valid: true},
+		Active:       pgtype.Bool{Bool: active, Valid: true},
 		Roles:        roles,
-		PasswordHash: pgtype.Text{String: hash, Valid: true},
+		PasswordHash: pgtype.Text{String: hash, Valid: hash != ""},
 		DateUpdated:  pgtype.Timestamptz{Time: up, Valid: true},
 	}
 }
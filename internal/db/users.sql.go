@@ -34,7 +34,7 @@ INSERT INTO users
 	  (user_id, name, email, active, password_hash, roles, date_created, date_updated)
 	VALUES
 	  ($1, $2, $3, $4, $5, $6, $7, $8)
-  RETURNING user_id, name, email, active, roles, password_hash, date_created, date_updated
+  RETURNING user_id, name, email, active, roles, password_hash, date_created, date_updated, date_verified, date_verification_sent
 `
 
 type CreateUserParams struct {
@@ -69,6 +69,8 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.PasswordHash,
 		&i.DateCreated,
 		&i.DateUpdated,
+		&i.DateVerified,
+		&i.DateVerificationSent,
 	)
 	return i, err
 }
@@ -85,7 +87,7 @@ func (q *Queries) DeleteUser(ctx context.Context, userID string) error {
 }
 
 const getUser = `-- name: GetUser :one
-SELECT user_id, name, email, active, roles, password_hash, date_created, date_updated FROM users
+SELECT user_id, name, email, active, roles, password_hash, date_created, date_updated, date_verified, date_verification_sent FROM users
   WHERE "user_id" = $1
 `
 
@@ -101,12 +103,38 @@ func (q *Queries) GetUser(ctx context.Context, userID string) (User, error) {
 		&i.PasswordHash,
 		&i.DateCreated,
 		&i.DateUpdated,
+		&i.DateVerified,
+		&i.DateVerificationSent,
+	)
+	return i, err
+}
+
+const getUserForUpdate = `-- name: GetUserForUpdate :one
+SELECT user_id, name, email, active, roles, password_hash, date_created, date_updated, date_verified, date_verification_sent FROM users
+  WHERE "user_id" = $1
+  FOR UPDATE
+`
+
+func (q *Queries) GetUserForUpdate(ctx context.Context, userID string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserForUpdate, userID)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.Name,
+		&i.Email,
+		&i.Active,
+		&i.Roles,
+		&i.PasswordHash,
+		&i.DateCreated,
+		&i.DateUpdated,
+		&i.DateVerified,
+		&i.DateVerificationSent,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT user_id, name, email, active, roles, password_hash, date_created, date_updated FROM users
+SELECT user_id, name, email, active, roles, password_hash, date_created, date_updated, date_verified, date_verification_sent FROM users
   WHERE email = $1
 `
 
@@ -122,12 +150,14 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email pgtype.Text) (User,
 		&i.PasswordHash,
 		&i.DateCreated,
 		&i.DateUpdated,
+		&i.DateVerified,
+		&i.DateVerificationSent,
 	)
 	return i, err
 }
 
 const listUsers = `-- name: ListUsers :many
-SELECT user_id, name, email, active, roles, password_hash, date_created, date_updated FROM users
+SELECT user_id, name, email, active, roles, password_hash, date_created, date_updated, date_verified, date_verification_sent FROM users
   ORDER BY name
 `
 
@@ -149,6 +179,8 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 			&i.PasswordHash,
 			&i.DateCreated,
 			&i.DateUpdated,
+			&i.DateVerified,
+			&i.DateVerificationSent,
 		); err != nil {
 			return nil, err
 		}
@@ -160,14 +192,64 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
+const recordVerificationSent = `-- name: RecordVerificationSent :execrows
+UPDATE users
+  SET
+    "date_verification_sent" = $1
+  WHERE
+    "user_id" = $2
+    AND "date_verified" IS NULL
+    AND ("date_verification_sent" IS NULL OR "date_verification_sent" <= $3)
+`
+
+type RecordVerificationSentParams struct {
+	Now        pgtype.Timestamptz
+	UserID     string
+	SentBefore pgtype.Timestamptz
+}
+
+func (q *Queries) RecordVerificationSent(ctx context.Context, arg RecordVerificationSentParams) (int64, error) {
+	result, err := q.db.Exec(ctx, recordVerificationSent, arg.Now, arg.UserID, arg.SentBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const rehashPassword = `-- name: RehashPassword :execrows
+UPDATE users
+  SET
+    "password_hash" = $1
+  WHERE
+    "user_id" = $2 AND "password_hash" = $3
+`
+
+type RehashPasswordParams struct {
+	NewHash pgtype.Text
+	UserID  string
+	OldHash pgtype.Text
+}
+
+func (q *Queries) RehashPassword(ctx context.Context, arg RehashPasswordParams) (int64, error) {
+	result, err := q.db.Exec(ctx, rehashPassword, arg.NewHash, arg.UserID, arg.OldHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateUser = `-- name: UpdateUser :exec
 UPDATE users
   SET
     "name" = $2,
     "email" = $3,
-    "roles" = $4,
-    "password_hash" = $5,
-    "date_updated" = $6
+    "active" = $4,
+    "roles" = $5,
+    "password_hash" = $6,
+    "date_updated" = $7,
+    -- a changed email address has to be verified again
+    "date_verified" = CASE WHEN "email" = $3 THEN "date_verified" END,
+    "date_verification_sent" = CASE WHEN "email" = $3 THEN "date_verification_sent" END
   WHERE user_id = $1
 `
 
@@ -175,6 +257,7 @@ type UpdateUserParams struct {
 	UserID       string
 	Name         pgtype.Text
 	Email        pgtype.Text
+	Active       pgtype.Bool
 	Roles        []string
 	PasswordHash pgtype.Text
 	DateUpdated  pgtype.Timestamptz
@@ -185,6 +268,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
 		arg.UserID,
 		arg.Name,
 		arg.Email,
+		arg.Active,
 		arg.Roles,
 		arg.PasswordHash,
 		arg.DateUpdated,
@@ -207,3 +291,25 @@ func (q *Queries) UserExists(ctx context.Context, userID string) (bool, error) {
 	err := row.Scan(&exists)
 	return exists, err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+  SET
+    "date_verified" = COALESCE("date_verified", $1::TIMESTAMPTZ)
+  WHERE
+    "user_id" = $2 AND "email" = $3
+`
+
+type VerifyUserEmailParams struct {
+	Now    pgtype.Timestamptz
+	UserID string
+	Email  pgtype.Text
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.Exec(ctx, verifyUserEmail, arg.Now, arg.UserID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
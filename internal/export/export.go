@@ -0,0 +1,208 @@
+// Package export writes the data held about a user to a ZIP archive, e.g. to
+// answer a data access request. The records are read page by page and written
+// to the archive as they arrive, so neither the data nor the archive is ever
+// held in memory as a whole.
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/highlight"
+)
+
+// pageSize is the number of snippets or audit events read at a time.
+const pageSize = 100
+
+// Stores holds the stores the data of the user is read from.
+type Stores struct {
+	Snippets models.SnippetModelInterface
+	Audit    models.AuditModelInterface
+	Sessions models.SessionModelInterface
+}
+
+// Filename returns the name of an archive created at the time.
+func Filename(now time.Time) string {
+	return fmt.Sprintf("snptx-export-%s.zip", now.Format("2006-01-02"))
+}
+
+// Write writes the archive of the user to w. It holds
+//
+//	profile.json        the profile of the user
+//	snippets.json       the snippets of the user, including the expired ones
+//	snippets/<id>.<ext> the content of each snippet
+//	audit_events.json   the security events caused by or affecting the user
+//	sessions.json       the sessions the user is logged in with
+//
+// An error leaves w with an incomplete archive.
+func Write(ctx context.Context, w io.Writer, s Stores, usr *models.User, now time.Time) error {
+	zw := zip.NewWriter(w)
+
+	if err := writeJSON(zw, "profile.json", now, usr); err != nil {
+		return err
+	}
+
+	// the listing and the contents cannot be written side by side, a ZIP
+	// archive is written one file after the other
+	err := writeArray(zw, "snippets.json", now, func(add func(any) error) error {
+		return eachSnippet(ctx, s.Snippets, usr.ID, func(spt *models.Snippet) error {
+			return add(spt)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	err = eachSnippet(ctx, s.Snippets, usr.ID, func(spt *models.Snippet) error {
+		f, err := create(zw, "snippets/"+spt.ID+snippetExt(spt), spt.DateUpdated)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, spt.Content)
+		return errors.Wrapf(err, "writing snippet %s", spt.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeArray(zw, "audit_events.json", now, func(add func(any) error) error {
+		return eachAuditEvent(ctx, s.Audit, usr.ID, func(e *models.AuditEvent) error {
+			return add(e)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.Sessions.List(ctx, usr.ID, "")
+	if err != nil {
+		return errors.Wrap(err, "listing sessions")
+	}
+	if err := writeJSON(zw, "sessions.json", now, sessions); err != nil {
+		return err
+	}
+
+	return errors.Wrap(zw.Close(), "closing archive")
+}
+
+// eachSnippet calls fn with each snippet of the user, the oldest first.
+func eachSnippet(ctx context.Context, snippets models.SnippetModelInterface, userID string, fn func(*models.Snippet) error) error {
+	f := models.SnippetFilter{
+		ViewerID:       userID,
+		OwnerID:        userID,
+		IncludeExpired: true,
+		Sort:           models.SortCreated,
+		Limit:          pageSize,
+	}
+	for {
+		p, err := snippets.List(ctx, f)
+		if err != nil {
+			return errors.Wrap(err, "listing snippets")
+		}
+		for i := range p.Snippets {
+			if err := fn(&p.Snippets[i]); err != nil {
+				return err
+			}
+		}
+		if p.Next == "" {
+			return nil
+		}
+		f.Cursor = p.Next
+	}
+}
+
+// eachAuditEvent calls fn with each audit event caused by or affecting the
+// user, the latest first.
+func eachAuditEvent(ctx context.Context, audit models.AuditModelInterface, userID string, fn func(*models.AuditEvent) error) error {
+	f := models.AuditFilter{UserID: userID, Limit: pageSize}
+	for {
+		events, err := audit.List(ctx, f)
+		if err != nil {
+			return errors.Wrap(err, "listing audit events")
+		}
+		for i := range events {
+			if err := fn(&events[i]); err != nil {
+				return err
+			}
+		}
+		if len(events) < pageSize {
+			return nil
+		}
+		last := events[len(events)-1]
+		f.Before, f.BeforeID = last.DateCreated, last.ID
+	}
+}
+
+// snippetExt returns the file extension of the language of the snippet.
+func snippetExt(spt *models.Snippet) string {
+	l, ok := highlight.Lookup(spt.Language)
+	if !ok {
+		l, _ = highlight.Lookup(highlight.Plaintext)
+	}
+	return l.Ext
+}
+
+func create(zw *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating %s", name)
+	}
+	return f, nil
+}
+
+// writeJSON writes a file holding v as JSON.
+func writeJSON(zw *zip.Writer, name string, now time.Time, v any) error {
+	f, err := create(zw, name, now)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return errors.Wrapf(enc.Encode(v), "writing %s", name)
+}
+
+// writeArray writes a file holding a JSON array of the elements passed to
+// add by fill, one element at a time.
+func writeArray(zw *zip.Writer, name string, now time.Time, fill func(add func(any) error) error) error {
+	f, err := create(zw, name, now)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, "["); err != nil {
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	sep := "\n  "
+	add := func(v any) error {
+		b, err := json.MarshalIndent(v, "  ", "  ")
+		if err != nil {
+			return errors.Wrapf(err, "encoding %s", name)
+		}
+		if _, err := io.WriteString(f, sep); err != nil {
+			return errors.Wrapf(err, "writing %s", name)
+		}
+		sep = ",\n  "
+		_, err = f.Write(b)
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	if err := fill(add); err != nil {
+		return err
+	}
+
+	end := "\n]\n"
+	if sep == "\n  " {
+		end = "]\n"
+	}
+	_, err = io.WriteString(f, end)
+	return errors.Wrapf(err, "writing %s", name)
+}
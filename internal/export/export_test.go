@@ -0,0 +1,127 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/models/mock"
+)
+
+// readArchive writes the archive of the user and returns its files by name,
+// in the order they were written.
+func readArchive(t *testing.T, s Stores, userID string) ([]string, map[string][]byte) {
+	t.Helper()
+
+	usr, err := mock.NewUserStore().QueryByID(t.Context(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(t.Context(), &buf, s, usr, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, f.Name)
+		files[f.Name] = b
+	}
+	return names, files
+}
+
+func decode[T any](t *testing.T, files map[string][]byte, name string) T {
+	t.Helper()
+
+	var v T
+	if err := json.Unmarshal(files[name], &v); err != nil {
+		t.Fatalf("decoding %s: %v", name, err)
+	}
+	return v
+}
+
+func TestWrite(t *testing.T) {
+	audit := mock.NewAuditStore()
+	now := time.Now()
+	for i := range pageSize + 10 {
+		// three events at a time, some of them on either side of a page
+		e := models.AuditEvent{Type: models.AuditLogin, ActorID: "1", IP: strconv.Itoa(i)}
+		if err := audit.Record(t.Context(), e, now.Add(time.Duration(i/3)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not about alice
+	if err := audit.Record(t.Context(), models.AuditEvent{Type: models.AuditLogin, ActorID: "9"}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	s := Stores{Snippets: mock.NewSnippetStore(), Audit: audit, Sessions: mock.NewSessionStore()}
+	names, files := readArchive(t, s, "1")
+
+	want := []string{"profile.json", "snippets.json", "snippets/1.txt", "snippets/6.txt", "audit_events.json", "sessions.json"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("want files %v; got %v", want, names)
+	}
+
+	usr := decode[models.User](t, files, "profile.json")
+	if usr.ID != "1" || usr.Email != "alice@example.com" {
+		t.Errorf("want profile of alice; got %+v", usr)
+	}
+
+	snippets := decode[[]models.Snippet](t, files, "snippets.json")
+	if len(snippets) != 2 || snippets[0].ID != "1" || snippets[1].ID != "6" {
+		t.Errorf("want snippets 1 and 6; got %+v", snippets)
+	}
+	if got := string(files["snippets/1.txt"]); got != "An old silent pond..." {
+		t.Errorf("want content of snippet 1; got %q", got)
+	}
+
+	events := decode[[]models.AuditEvent](t, files, "audit_events.json")
+	if len(events) != pageSize+10 {
+		t.Fatalf("want %d audit events; got %d", pageSize+10, len(events))
+	}
+	if events[0].IP != strconv.Itoa(pageSize+9) || events[len(events)-1].IP != "0" {
+		t.Errorf("want the latest audit event first; got %q to %q", events[0].IP, events[len(events)-1].IP)
+	}
+
+	sessions := decode[[]models.Session](t, files, "sessions.json")
+	if len(sessions) != 2 {
+		t.Errorf("want 2 sessions; got %d", len(sessions))
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	s := Stores{Snippets: mock.NewSnippetStore(), Audit: mock.NewAuditStore(), Sessions: mock.NewSessionStore()}
+	names, files := readArchive(t, s, "9")
+
+	want := []string{"profile.json", "snippets.json", "audit_events.json", "sessions.json"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("want files %v; got %v", want, names)
+	}
+	for _, name := range []string{"snippets.json", "audit_events.json"} {
+		if got := string(files[name]); got != "[]\n" {
+			t.Errorf("want empty array in %s; got %q", name, got)
+		}
+	}
+}
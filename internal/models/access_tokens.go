@@ -0,0 +1,193 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	"github.com/tullo/snptx/internal/db"
+	"github.com/tullo/snptx/internal/platform/auth"
+	"github.com/tullo/snptx/internal/platform/database"
+	"go.opencensus.io/trace"
+)
+
+// AccessTokenPrefix starts every personal access token. It tells them apart
+// from JWTs and makes leaked tokens easy to find by secret scanners.
+const AccessTokenPrefix = "snptx_pat_"
+
+type AccessTokenModelInterface interface {
+	Authenticate(context.Context, string, time.Time) (auth.Claims, error)
+	Create(context.Context, NewAccessToken, time.Time) (*AccessToken, string, error)
+	List(context.Context, string) ([]AccessToken, error)
+	Revoke(context.Context, string, string) error
+}
+
+// AccessTokenStore manages the personal access tokens of the users. Only the
+// SHA-256 hashes of the tokens are stored.
+type AccessTokenStore struct {
+	db *database.DB
+	q  *db.Queries
+}
+
+// NewAccessTokenStore constructs an AccessTokenStore for api access.
+func NewAccessTokenStore(d *database.DB) AccessTokenStore {
+	return AccessTokenStore{
+		db: d,
+		q:  db.New(d),
+	}
+}
+
+// Create generates a new token for the user. It returns the stored token
+// and the token itself, which cannot be retrieved later.
+func (s AccessTokenStore) Create(ctx context.Context, n NewAccessToken, now time.Time) (*AccessToken, string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.token.Create")
+	defer span.End()
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	secret := AccessTokenPrefix + token
+
+	t := AccessToken{
+		ID:          uuid.New().String(),
+		UserID:      n.UserID,
+		Name:        n.Name,
+		Scopes:      n.Scopes,
+		DateExpires: n.DateExpires,
+		DateCreated: now,
+	}
+
+	err = s.q.CreateAccessToken(ctx, db.GetCreateAccessTokenParams(
+		t.ID,
+		t.UserID,
+		t.Name,
+		hashToken(secret),
+		t.Scopes,
+		t.DateExpires,
+		t.DateCreated,
+	))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "inserting access token")
+	}
+
+	return &t, secret, nil
+}
+
+// List gets the tokens of the user, newest first.
+func (s AccessTokenStore) List(ctx context.Context, userID string) ([]AccessToken, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.token.List")
+	defer span.End()
+
+	rows, err := s.q.ListAccessTokens(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "selecting access tokens of user %q", userID)
+	}
+
+	ts := make([]AccessToken, len(rows))
+	for i, r := range rows {
+		ts[i] = AccessToken{
+			ID:           r.TokenID,
+			UserID:       r.UserID,
+			Name:         r.Name,
+			Scopes:       r.Scopes,
+			DateExpires:  localTime(r.DateExpires),
+			DateLastUsed: localTime(r.DateLastUsed),
+			DateCreated:  localTime(r.DateCreated),
+		}
+	}
+
+	return ts, nil
+}
+
+// Revoke deletes the token of the user.
+func (s AccessTokenStore) Revoke(ctx context.Context, userID, id string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.token.Revoke")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	n, err := s.q.DeleteAccessToken(ctx, db.DeleteAccessTokenParams{TokenID: id, UserID: userID})
+	if err != nil {
+		return errors.Wrapf(err, "deleting access token %q", id)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Authenticate finds the token and returns the claims of its user restricted
+// to the scopes of the token. The time the token was last used is recorded
+// with a precision of a minute.
+func (s AccessTokenStore) Authenticate(ctx context.Context, secret string, now time.Time) (auth.Claims, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.token.Authenticate")
+	defer span.End()
+
+	if !strings.HasPrefix(secret, AccessTokenPrefix) {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
+	t, err := s.q.GetAccessTokenByHash(ctx, hashToken(secret))
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return auth.Claims{}, ErrAuthenticationFailure
+		}
+		return auth.Claims{}, errors.Wrap(err, "selecting access token")
+	}
+	if t.DateExpires.Valid && !t.DateExpires.Time.After(now) {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
+	err = s.q.TouchAccessToken(ctx, db.TouchAccessTokenParams{
+		Now:     pgtype.Timestamptz{Time: now, Valid: true},
+		TokenID: t.TokenID,
+	})
+	if err != nil {
+		return auth.Claims{}, errors.Wrapf(err, "recording use of access token %q", t.TokenID)
+	}
+
+	claims := auth.Claims{
+		Roles:  t.Roles,
+		Scopes: t.Scopes,
+	}
+	claims.Subject = t.UserID
+	claims.ID = t.TokenID
+
+	return claims, nil
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash under which the token is stored. The tokens are
+// random and long enough that a slow password hash adds nothing.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// localTime converts a nullable timestamp to local time. NULL is converted to
+// the zero time.
+func localTime(t pgtype.Timestamptz) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return t.Time.In(copenhagen)
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tullo/snptx/internal/db"
+	"github.com/tullo/snptx/internal/platform/database"
+	"go.opencensus.io/trace"
+)
+
+// Types of audit events.
+const (
+	AuditSignup         = "user.signup"
+	AuditLogin          = "user.login"
+	AuditLoginFailed    = "user.login_failed"
+	AuditLoginLockout   = "user.login_lockout"
+	AuditIdentityLink   = "user.identity_link"
+	AuditPasswordChange = "user.password_change"
+	AuditEmailChange    = "user.email_change"
+	AuditAccountDelete  = "user.delete"
+	AuditDataExport     = "user.export"
+	AuditRoleChange     = "user.role_change"
+	AuditUserActivate   = "user.activate"
+	AuditUserDeactivate = "user.deactivate"
+	AuditTwoFactorReset = "user.2fa_reset"
+	AuditSnippetDelete  = "snippet.delete"
+	AuditSnippetVisible = "snippet.visibility"
+)
+
+// AuditEventTypes lists the types of audit events.
+var AuditEventTypes = []string{
+	AuditSignup,
+	AuditLogin,
+	AuditLoginFailed,
+	AuditLoginLockout,
+	AuditIdentityLink,
+	AuditPasswordChange,
+	AuditEmailChange,
+	AuditAccountDelete,
+	AuditDataExport,
+	AuditRoleChange,
+	AuditUserActivate,
+	AuditUserDeactivate,
+	AuditTwoFactorReset,
+	AuditSnippetDelete,
+	AuditSnippetVisible,
+}
+
+// defaultAuditPageSize is the number of audit events listed if the filter
+// has no limit.
+const defaultAuditPageSize = 50
+
+// maxEventID orders after any other event ID.
+const maxEventID = "ffffffff-ffff-ffff-ffff-ffffffffffff"
+
+type AuditModelInterface interface {
+	Record(context.Context, AuditEvent, time.Time) error
+	List(context.Context, AuditFilter) ([]AuditEvent, error)
+}
+
+// AuditStore records audit events in the database. It offers no way to
+// change an event, the events are deleted with DeleteExpired after the
+// retention period. The database itself does not prevent changes.
+type AuditStore struct {
+	q         *db.Queries
+	retention time.Duration
+}
+
+// NewAuditStore constructs an AuditStore for api access. Events are kept for
+// the retention period, or forever if it is not positive.
+func NewAuditStore(d *database.DB, retention time.Duration) AuditStore {
+	return AuditStore{
+		q:         db.New(d),
+		retention: retention,
+	}
+}
+
+// Record appends the event.
+func (s AuditStore) Record(ctx context.Context, e AuditEvent, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.audit.Record")
+	defer span.End()
+
+	details := []byte("{}")
+	if len(e.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(e.Details); err != nil {
+			return fmt.Errorf("encoding details: [%w]", err)
+		}
+	}
+
+	err := s.q.CreateAuditEvent(ctx, db.GetCreateAuditEventParams(
+		uuid.New().String(),
+		e.Type,
+		e.ActorID,
+		e.TargetID,
+		e.IP,
+		e.UserAgent,
+		details,
+		now.UTC(),
+	))
+	if err != nil {
+		return fmt.Errorf("inserting audit event: [%w]", err)
+	}
+
+	return nil
+}
+
+// List retrieves the events matching the filter, the latest first. A
+// BeforeID other than a UUID fails with ErrInvalidID.
+func (s AuditStore) List(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.audit.List")
+	defer span.End()
+
+	if f.Limit <= 0 {
+		f.Limit = defaultAuditPageSize
+	}
+	if f.Before.IsZero() {
+		f.Before = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+	if f.BeforeID == "" {
+		f.BeforeID = maxEventID
+	}
+	if _, err := uuid.Parse(f.BeforeID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	rows, err := s.q.ListAuditEvents(ctx, db.ListAuditEventsParams{
+		EventType: f.Type,
+		UserID:    f.UserID,
+		Before:    pgtype.Timestamptz{Time: f.Before, Valid: true},
+		BeforeID:  f.BeforeID,
+		MaxRows:   int32(f.Limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("selecting audit events: [%w]", err)
+	}
+
+	events := make([]AuditEvent, len(rows))
+	for i, r := range rows {
+		events[i] = AuditEvent{
+			ID:          r.EventID,
+			Type:        r.EventType,
+			TargetID:    r.TargetID.String,
+			IP:          r.Ip.String,
+			UserAgent:   r.UserAgent.String,
+			DateCreated: localTime(r.DateCreated),
+		}
+		if r.ActorID.Valid {
+			events[i].ActorID = uuid.UUID(r.ActorID.Bytes).String()
+		}
+		// details of unexpected shape are not shown
+		_ = json.Unmarshal(r.Details, &events[i].Details)
+	}
+
+	return events, nil
+}
+
+// DeleteExpired removes at most limit events older than the retention
+// period, the oldest first. It returns the number of events removed.
+func (s AuditStore) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.audit.DeleteExpired")
+	defer span.End()
+
+	if s.retention <= 0 {
+		return 0, nil
+	}
+
+	n, err := s.q.DeleteExpiredAuditEvents(ctx, db.DeleteExpiredAuditEventsParams{
+		CreatedBefore: pgtype.Timestamptz{Time: time.Now().Add(-s.retention), Valid: true},
+		MaxRows:       int32(limit),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired audit events: [%w]", err)
+	}
+
+	return int(n), nil
+}
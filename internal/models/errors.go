@@ -12,6 +12,8 @@ var (
 
 	ErrNoRecord = errors.New("models: no matching record found")
 
+	ErrInvalidCursor = errors.New("models: invalid pagination cursor")
+
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 
 	ErrDuplicateEmail = errors.New("models: duplicate email")
@@ -19,4 +21,27 @@ var (
 	// ErrAuthenticationFailure occurs when a user attempts
 	// to authenticate but anything goes wrong.
 	ErrAuthenticationFailure = errors.New("authentication failed")
+
+	// ErrInactiveUser occurs when a deactivated user attempts to
+	// authenticate with valid credentials.
+	ErrInactiveUser = errors.New("models: inactive user")
+
+	// ErrInvalidToken occurs when a one-time token is unknown, has expired
+	// or has been used already.
+	ErrInvalidToken = errors.New("models: invalid or expired token")
+
+	// ErrNoPassword occurs when checking or resetting the password of an
+	// account provisioned by single sign-on, which has none.
+	ErrNoPassword = errors.New("models: account has no password")
+
+	// ErrRateLimited occurs when an action is repeated too often.
+	ErrRateLimited = errors.New("models: rate limited")
+
+	// ErrInvalidCode occurs when a two-factor code or recovery code is wrong
+	// or has been used already.
+	ErrInvalidCode = errors.New("models: invalid two-factor code")
+
+	// ErrTwoFactorEnabled occurs when enrolling a user whose two-factor
+	// authentication is enabled already.
+	ErrTwoFactorEnabled = errors.New("models: two-factor authentication enabled")
 )
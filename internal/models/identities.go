@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/google/uuid"
+	"github.com/tullo/snptx/internal/db"
+	"github.com/tullo/snptx/internal/platform/database"
+	"go.opencensus.io/trace"
+)
+
+type IdentityModelInterface interface {
+	Link(context.Context, string, string, string, time.Time) error
+	Lookup(context.Context, string, string) (string, error)
+}
+
+// IdentityStore manages the links between the users and their identities at
+// OpenID Connect providers. An identity is the subject of the ID tokens of
+// an issuer, which unlike the email address never changes.
+type IdentityStore struct {
+	q *db.Queries
+}
+
+// NewIdentityStore constructs an IdentityStore for api access.
+func NewIdentityStore(d *database.DB) IdentityStore {
+	return IdentityStore{
+		q: db.New(d),
+	}
+}
+
+// Lookup gets the ID of the user linked to the subject of the issuer.
+// Unknown identities fail with ErrNoRecord.
+func (s IdentityStore) Lookup(ctx context.Context, issuer, subject string) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.identity.Lookup")
+	defer span.End()
+
+	id, err := s.q.GetUserIdentity(ctx, db.GetUserIdentityParams{Issuer: issuer, Subject: subject})
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return "", ErrNoRecord
+		}
+		return "", fmt.Errorf("selecting identity %q of %q: [%w]", subject, issuer, err)
+	}
+
+	return id, nil
+}
+
+// Link links the subject of the issuer to the user.
+func (s IdentityStore) Link(ctx context.Context, userID, issuer, subject string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.identity.Link")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	err := s.q.CreateUserIdentity(ctx, db.GetCreateUserIdentityParams(issuer, subject, userID, now.UTC()))
+	if err != nil {
+		return fmt.Errorf("linking identity %q of %q to user %q: [%w]", subject, issuer, userID, err)
+	}
+
+	return nil
+}
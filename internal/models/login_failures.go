@@ -0,0 +1,264 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tullo/snptx/internal/db"
+	"github.com/tullo/snptx/internal/platform/database"
+	"go.opencensus.io/trace"
+)
+
+type LoginFailureModelInterface interface {
+	Attempt(context.Context, string, string, time.Time) (*LoginAttempt, error)
+	Release(context.Context, *LoginAttempt) error
+	Succeed(context.Context, *LoginAttempt) error
+}
+
+// Kinds of failure counters, a login is counted against both the account and
+// the IP address it came from.
+const (
+	LockoutAccount = "account"
+	LockoutIP      = "ip"
+)
+
+// Lockout describes a login lockout triggered by a failed attempt.
+type Lockout struct {
+	Kind     string // LockoutAccount or LockoutIP
+	Subject  string // the email or IP address
+	Failures int
+	Until    time.Time
+}
+
+// LoginAttempt is a login attempt, counted as a failure before the
+// credentials are checked. Concurrent attempts so cannot all pass the check
+// before the first of them fails. An attempt that turns out to succeed is
+// released again.
+type LoginAttempt struct {
+	Email string
+	IP    string
+
+	// LockedUntil is set if the attempt is rejected, because the account or
+	// the IP address is locked. A rejected attempt is not counted.
+	LockedUntil time.Time
+
+	// Lockouts are the lockouts triggered by the attempt. They are in effect
+	// already and lifted again when the attempt is released.
+	Lockouts []Lockout
+}
+
+// Rejected reports whether the attempt is rejected by a lockout.
+func (a *LoginAttempt) Rejected() bool {
+	return !a.LockedUntil.IsZero()
+}
+
+// lockout returns the lockout of the kind triggered by the attempt.
+func (a *LoginAttempt) lockout(kind string) (Lockout, bool) {
+	for _, l := range a.Lockouts {
+		if l.Kind == kind {
+			return l, true
+		}
+	}
+	return Lockout{}, false
+}
+
+// LockoutPolicy defines after how many failed logins an account or IP
+// address is locked and for how long.
+type LockoutPolicy struct {
+	AccountThreshold int           // failures locking an account, zero disables the counter
+	IPThreshold      int           // failures locking an IP address, zero disables the counter
+	Delay            time.Duration // first lockout, doubled with each further failure
+	MaxDelay         time.Duration
+	Window           time.Duration // failures older than this are forgotten
+}
+
+// DefaultLockoutPolicy locks an account after 5 and an IP address after 20
+// failures within an hour, for one minute up to one hour.
+var DefaultLockoutPolicy = LockoutPolicy{
+	AccountThreshold: 5,
+	IPThreshold:      20,
+	Delay:            time.Minute,
+	MaxDelay:         time.Hour,
+	Window:           time.Hour,
+}
+
+// Duration returns how long to lock after the number of failures counted
+// against a threshold. It is zero below the threshold.
+func (p LockoutPolicy) Duration(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+	d := p.Delay
+	for i := threshold; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// LoginFailureStore counts failed logins per account and per IP address in
+// the database, so lockouts apply across all instances of the app.
+type LoginFailureStore struct {
+	p LockoutPolicy
+	q *db.Queries
+}
+
+// NewLoginFailureStore constructs a LoginFailureStore for api access.
+func NewLoginFailureStore(d *database.DB, p LockoutPolicy) LoginFailureStore {
+	return LoginFailureStore{
+		p: p,
+		q: db.New(d),
+	}
+}
+
+// failureKey returns the key of the counter of the kind for the subject.
+func failureKey(kind, subject string) string {
+	if kind == LockoutAccount {
+		subject = strings.ToLower(subject)
+	}
+	return kind + ":" + subject
+}
+
+// counter is a failure counter an attempt is counted against.
+type counter struct {
+	kind, subject string
+	threshold     int
+}
+
+// counters returns the enabled counters of an attempt to the account with the
+// email from the IP address, the account first.
+func (s LoginFailureStore) counters(email, ip string) []counter {
+	var cs []counter
+	for _, c := range []counter{
+		{LockoutAccount, email, s.p.AccountThreshold},
+		{LockoutIP, ip, s.p.IPThreshold},
+	} {
+		if c.threshold > 0 && c.subject != "" {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// Attempt counts an attempt to log in to the account with the email from the
+// IP address. Each counter is incremented and locked in one statement, and
+// not at all if it is locked already. The attempt is rejected then, with
+// LockedUntil telling until when.
+func (s LoginFailureStore) Attempt(ctx context.Context, email, ip string, now time.Time) (*LoginAttempt, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loginFailure.Attempt")
+	defer span.End()
+
+	att := LoginAttempt{Email: email, IP: ip}
+	cs := s.counters(email, ip)
+	for i, c := range cs {
+		key := failureKey(c.kind, c.subject)
+		row, err := s.q.AttemptLogin(ctx, db.AttemptLoginParams{
+			FailureKey:  key,
+			Threshold:   int64(c.threshold),
+			Now:         pgtype.Timestamptz{Time: now, Valid: true},
+			Delay:       pgtype.Interval{Microseconds: s.p.Delay.Microseconds(), Valid: true},
+			WindowStart: pgtype.Timestamptz{Time: now.Add(-s.p.Window), Valid: true},
+			MaxDelay:    pgtype.Interval{Microseconds: s.p.MaxDelay.Microseconds(), Valid: true},
+		})
+		if err == nil {
+			if row.LockedUntil.Valid {
+				att.Lockouts = append(att.Lockouts, Lockout{Kind: c.kind, Subject: c.subject, Failures: int(row.Failures), Until: row.LockedUntil.Time})
+			}
+			continue
+		}
+		if !pgxscan.NotFound(err) {
+			return nil, fmt.Errorf("counting login attempt: [%w]", err)
+		}
+
+		// the counter is locked, the counters incremented so far are
+		// released again
+		if err := s.release(ctx, &att, cs[:i]); err != nil {
+			return nil, err
+		}
+		until, err := s.q.GetLoginLockout(ctx, db.GetLoginLockoutParams{
+			FailureKeys: []string{key},
+			Now:         pgtype.Timestamptz{Time: now, Valid: true},
+		})
+		switch {
+		case pgxscan.NotFound(err):
+			// the lockout ended in the meantime
+			until.Time = now
+		case err != nil:
+			return nil, fmt.Errorf("selecting login lockout: [%w]", err)
+		}
+		return &LoginAttempt{Email: email, IP: ip, LockedUntil: until.Time}, nil
+	}
+
+	return &att, nil
+}
+
+// Release takes back an attempt that did not fail, e.g. a correct password
+// still awaiting the second factor. The failures counted before are kept.
+func (s LoginFailureStore) Release(ctx context.Context, att *LoginAttempt) error {
+	ctx, span := trace.StartSpan(ctx, "internal.loginFailure.Release")
+	defer span.End()
+
+	return s.release(ctx, att, s.counters(att.Email, att.IP))
+}
+
+// Succeed ends a successful login. It forgets the failed logins to the account
+// and releases the attempt from the IP address. The counter of the IP address
+// is kept, so an attacker owning one account cannot use it to reset the
+// counter of their address.
+func (s LoginFailureStore) Succeed(ctx context.Context, att *LoginAttempt) error {
+	ctx, span := trace.StartSpan(ctx, "internal.loginFailure.Succeed")
+	defer span.End()
+
+	var ip []counter
+	for _, c := range s.counters(att.Email, att.IP) {
+		if c.kind == LockoutIP {
+			ip = append(ip, c)
+		}
+	}
+	if err := s.release(ctx, att, ip); err != nil {
+		return err
+	}
+
+	if err := s.q.DeleteLoginFailures(ctx, failureKey(LockoutAccount, att.Email)); err != nil {
+		return fmt.Errorf("deleting login failures: [%w]", err)
+	}
+
+	return nil
+}
+
+// release decrements the counters of the attempt and lifts the lockouts it
+// triggered.
+func (s LoginFailureStore) release(ctx context.Context, att *LoginAttempt, cs []counter) error {
+	for _, c := range cs {
+		var until pgtype.Timestamptz
+		if l, ok := att.lockout(c.kind); ok {
+			until = pgtype.Timestamptz{Time: l.Until, Valid: true}
+		}
+		err := s.q.ReleaseLoginAttempt(ctx, db.ReleaseLoginAttemptParams{
+			LockedUntil: until,
+			FailureKey:  failureKey(c.kind, c.subject),
+		})
+		if err != nil {
+			return fmt.Errorf("releasing login attempt: [%w]", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteExpired removes at most limit counters without failures for a day,
+// the oldest first. It returns the number of counters removed.
+func (s LoginFailureStore) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loginFailure.DeleteExpired")
+	defer span.End()
+
+	n, err := s.q.DeleteExpiredLoginFailures(ctx, int32(limit))
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired login failures: [%w]", err)
+	}
+
+	return int(n), nil
+}
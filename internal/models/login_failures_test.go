@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyDuration(t *testing.T) {
+	p := LockoutPolicy{Delay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	tests := []struct {
+		name      string
+		failures  int
+		threshold int
+		want      time.Duration
+	}{
+		{"Below Threshold", 4, 5, 0},
+		{"At Threshold", 5, 5, time.Minute},
+		{"Doubled", 6, 5, 2 * time.Minute},
+		{"Doubled Twice", 7, 5, 4 * time.Minute},
+		{"Capped", 9, 5, 10 * time.Minute},
+		{"Far Beyond", 1000, 5, 10 * time.Minute},
+		{"Disabled", 100, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Duration(tt.failures, tt.threshold); got != tt.want {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFailureKey(t *testing.T) {
+	if got := failureKey(LockoutAccount, "Alice@Example.com"); got != "account:alice@example.com" {
+		t.Errorf("want %q; got %q", "account:alice@example.com", got)
+	}
+	if got := failureKey(LockoutIP, "192.0.2.1"); got != "ip:192.0.2.1" {
+		t.Errorf("want %q; got %q", "ip:192.0.2.1", got)
+	}
+}
@@ -0,0 +1,75 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/auth"
+)
+
+var mockAccessToken = models.AccessToken{
+	ID:          "7",
+	UserID:      "1",
+	Name:        "ci",
+	Scopes:      []string{auth.ScopeSnippetsRead, auth.ScopeSnippetsWrite},
+	DateCreated: time.Now(),
+}
+
+// AccessTokenStore mocks the personal access tokens of alice. The token
+// "snptx_pat_valid" grants all scopes, "snptx_pat_readonly" grants
+// snippets:read only.
+type AccessTokenStore struct {
+}
+
+// NewAccessTokenStore constructs an AccessTokenStore for api access.
+func NewAccessTokenStore() AccessTokenStore {
+	var s AccessTokenStore
+	return s
+}
+
+// Authenticate finds the token and returns the claims restricted to its scopes.
+func (s AccessTokenStore) Authenticate(ctx context.Context, secret string, now time.Time) (auth.Claims, error) {
+	var scopes []string
+	switch secret {
+	case models.AccessTokenPrefix + "valid":
+		scopes = mockAccessToken.Scopes
+	case models.AccessTokenPrefix + "readonly":
+		scopes = []string{auth.ScopeSnippetsRead}
+	default:
+		return auth.Claims{}, models.ErrAuthenticationFailure
+	}
+
+	claims := auth.Claims{Roles: mockUser.Roles, Scopes: scopes}
+	claims.Subject = mockUser.ID
+	return claims, nil
+}
+
+// Create generates a new token for the user.
+func (s AccessTokenStore) Create(ctx context.Context, n models.NewAccessToken, now time.Time) (*models.AccessToken, string, error) {
+	t := models.AccessToken{
+		ID:          "8",
+		UserID:      n.UserID,
+		Name:        n.Name,
+		Scopes:      n.Scopes,
+		DateExpires: n.DateExpires,
+		DateCreated: now,
+	}
+	return &t, models.AccessTokenPrefix + "created", nil
+}
+
+// List gets the tokens of the user.
+func (s AccessTokenStore) List(ctx context.Context, userID string) ([]models.AccessToken, error) {
+	if userID != mockAccessToken.UserID {
+		return nil, nil
+	}
+	return []models.AccessToken{mockAccessToken}, nil
+}
+
+// Revoke deletes the token of the user.
+func (s AccessTokenStore) Revoke(ctx context.Context, userID, id string) error {
+	if id != mockAccessToken.ID || userID != mockAccessToken.UserID {
+		return models.ErrNoRecord
+	}
+	return nil
+}
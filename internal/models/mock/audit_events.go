@@ -0,0 +1,96 @@
+package mock
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+)
+
+// AuditStore keeps the audit events in memory, so tests can check which
+// events were recorded. The events are numbered in the order they are
+// recorded.
+type AuditStore struct {
+	mu     sync.Mutex
+	events []models.AuditEvent
+}
+
+// NewAuditStore constructs an AuditStore for api access.
+func NewAuditStore() *AuditStore {
+	return &AuditStore{}
+}
+
+// Record appends the event.
+func (s *AuditStore) Record(ctx context.Context, e models.AuditEvent, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.ID = strconv.Itoa(len(s.events) + 1)
+	e.DateCreated = now
+	s.events = append(s.events, e)
+	return nil
+}
+
+// List retrieves the events matching the filter, the latest first. Events
+// recorded at the same time are ordered by their numbers.
+func (s *AuditStore) List(ctx context.Context, f models.AuditFilter) ([]models.AuditEvent, error) {
+	beforeID := math.MaxInt
+	if f.BeforeID != "" {
+		var err error
+		if beforeID, err = strconv.Atoi(f.BeforeID); err != nil {
+			return nil, models.ErrInvalidID
+		}
+	}
+
+	s.mu.Lock()
+	all := slices.Clone(s.events)
+	s.mu.Unlock()
+
+	// the latest first
+	slices.SortStableFunc(all, func(a, b models.AuditEvent) int {
+		if c := b.DateCreated.Compare(a.DateCreated); c != 0 {
+			return c
+		}
+		return cmp.Compare(eventNumber(b), eventNumber(a))
+	})
+
+	var events []models.AuditEvent
+	for _, e := range all {
+		if f.Type != "" && e.Type != f.Type {
+			continue
+		}
+		if f.UserID != "" && e.ActorID != f.UserID && e.TargetID != f.UserID {
+			continue
+		}
+		if !f.Before.IsZero() {
+			c := e.DateCreated.Compare(f.Before)
+			if c > 0 || c == 0 && eventNumber(e) >= beforeID {
+				continue
+			}
+		}
+		if f.Limit > 0 && len(events) == f.Limit {
+			break
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// eventNumber returns the number the event was recorded with.
+func eventNumber(e models.AuditEvent) int {
+	n, _ := strconv.Atoi(e.ID)
+	return n
+}
+
+// Events returns all recorded events, the oldest first.
+func (s *AuditStore) Events() []models.AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.events)
+}
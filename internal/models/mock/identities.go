@@ -0,0 +1,44 @@
+package mock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+)
+
+// IdentityStore keeps the links between users and their identities at
+// OpenID Connect providers in memory. No identity is linked initially.
+type IdentityStore struct {
+	mu    sync.Mutex
+	links map[string]string
+}
+
+// NewIdentityStore constructs an IdentityStore for api access.
+func NewIdentityStore() *IdentityStore {
+	return &IdentityStore{
+		links: make(map[string]string),
+	}
+}
+
+// Lookup gets the ID of the user linked to the subject of the issuer.
+func (s *IdentityStore) Lookup(ctx context.Context, issuer, subject string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.links[issuer+"\n"+subject]
+	if !ok {
+		return "", models.ErrNoRecord
+	}
+	return id, nil
+}
+
+// Link links the subject of the issuer to the user.
+func (s *IdentityStore) Link(ctx context.Context, userID, issuer, subject string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.links[issuer+"\n"+subject] = userID
+	return nil
+}
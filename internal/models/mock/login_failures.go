@@ -0,0 +1,102 @@
+package mock
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+)
+
+// LoginFailureStore counts the login attempts in memory, applying the
+// default lockout policy.
+type LoginFailureStore struct {
+	mu       sync.Mutex
+	failures map[string]int
+	locked   map[string]time.Time
+}
+
+// NewLoginFailureStore constructs a LoginFailureStore for api access.
+func NewLoginFailureStore() *LoginFailureStore {
+	return &LoginFailureStore{
+		failures: make(map[string]int),
+		locked:   make(map[string]time.Time),
+	}
+}
+
+type counter struct {
+	kind, key, subject string
+	threshold          int
+}
+
+func counters(email, ip string) []counter {
+	p := models.DefaultLockoutPolicy
+	return []counter{
+		{models.LockoutAccount, "account:" + strings.ToLower(email), email, p.AccountThreshold},
+		{models.LockoutIP, "ip:" + ip, ip, p.IPThreshold},
+	}
+}
+
+// Attempt counts a login attempt, unless the account or the IP address is
+// locked.
+func (s *LoginFailureStore) Attempt(ctx context.Context, email, ip string, now time.Time) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs := counters(email, ip)
+	for _, c := range cs {
+		if t := s.locked[c.key]; t.After(now) {
+			return &models.LoginAttempt{Email: email, IP: ip, LockedUntil: t}, nil
+		}
+	}
+
+	att := models.LoginAttempt{Email: email, IP: ip}
+	for _, c := range cs {
+		s.failures[c.key]++
+		if d := models.DefaultLockoutPolicy.Duration(s.failures[c.key], c.threshold); d > 0 {
+			s.locked[c.key] = now.Add(d)
+			att.Lockouts = append(att.Lockouts, models.Lockout{Kind: c.kind, Subject: c.subject, Failures: s.failures[c.key], Until: now.Add(d)})
+		}
+	}
+	return &att, nil
+}
+
+// Release takes back the attempt.
+func (s *LoginFailureStore) Release(ctx context.Context, att *models.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range counters(att.Email, att.IP) {
+		s.release(att, c)
+	}
+	return nil
+}
+
+// Succeed forgets the failed logins to the account and releases the attempt
+// from the IP address.
+func (s *LoginFailureStore) Succeed(ctx context.Context, att *models.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range counters(att.Email, att.IP) {
+		if c.kind == models.LockoutIP {
+			s.release(att, c)
+			continue
+		}
+		delete(s.failures, c.key)
+		delete(s.locked, c.key)
+	}
+	return nil
+}
+
+func (s *LoginFailureStore) release(att *models.LoginAttempt, c counter) {
+	if s.failures[c.key] > 0 {
+		s.failures[c.key]--
+	}
+	for _, l := range att.Lockouts {
+		if l.Kind == c.kind && s.locked[c.key].Equal(l.Until) {
+			delete(s.locked, c.key)
+		}
+	}
+}
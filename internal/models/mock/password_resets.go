@@ -0,0 +1,49 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+)
+
+// PasswordResetStore mocks the password reset tokens. Alice may reset her
+// password with the token "valid-reset-token", every other token is invalid.
+type PasswordResetStore struct {
+}
+
+// NewPasswordResetStore constructs a PasswordResetStore for api access.
+func NewPasswordResetStore() PasswordResetStore {
+	var s PasswordResetStore
+	return s
+}
+
+// Create generates a reset token for the user with the email.
+func (s PasswordResetStore) Create(ctx context.Context, email string, now time.Time, ttl time.Duration) (string, *models.User, error) {
+	switch email {
+	case mockUser.Email:
+		return "valid-reset-token", mockUser, nil
+	case mockInactiveUser.Email:
+		return "", nil, models.ErrInactiveUser
+	case mockSSOUser.Email:
+		return "", mockSSOUser, models.ErrNoPassword
+	default:
+		return "", nil, models.ErrNoRecord
+	}
+}
+
+// Check verifies that the token may be used to reset a password.
+func (s PasswordResetStore) Check(ctx context.Context, token string, now time.Time) error {
+	if token != "valid-reset-token" {
+		return models.ErrInvalidToken
+	}
+	return nil
+}
+
+// Reset uses up the token and replaces the password of its user.
+func (s PasswordResetStore) Reset(ctx context.Context, token, password string, now time.Time) (string, error) {
+	if err := s.Check(ctx, token, now); err != nil {
+		return "", err
+	}
+	return mockUser.ID, nil
+}
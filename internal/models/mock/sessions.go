@@ -0,0 +1,61 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+)
+
+// mockOtherSessionID identifies the session alice has opened on another
+// device.
+const mockOtherSessionID = "0123456789abcdef0123456789abcdef"
+
+// SessionStore mocks the sessions of alice. Besides the current session she
+// is logged in on another device.
+type SessionStore struct {
+}
+
+// NewSessionStore constructs a SessionStore for api access.
+func NewSessionStore() SessionStore {
+	var s SessionStore
+	return s
+}
+
+// List returns the sessions of the user.
+func (s SessionStore) List(ctx context.Context, userID, currentToken string) ([]models.Session, error) {
+	sessions := []models.Session{{
+		ID:           models.SessionID(currentToken),
+		Current:      true,
+		DateCreated:  time.Now().Add(-time.Hour),
+		DateLastSeen: time.Now(),
+		IP:           "127.0.0.1",
+		UserAgent:    "Go-http-client/1.1",
+	}}
+	if userID == mockUser.ID {
+		sessions = append(sessions, models.Session{
+			ID:           mockOtherSessionID,
+			DateCreated:  time.Now().Add(-48 * time.Hour),
+			DateLastSeen: time.Now().Add(-24 * time.Hour),
+			IP:           "192.0.2.10",
+			UserAgent:    "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+		})
+	}
+	return sessions, nil
+}
+
+// Revoke deletes the session of the user.
+func (s SessionStore) Revoke(ctx context.Context, userID, id string) error {
+	if userID != mockUser.ID || id != mockOtherSessionID {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// RevokeOthers deletes all sessions of the user but the current one.
+func (s SessionStore) RevokeOthers(ctx context.Context, userID, currentToken string) (int, error) {
+	if userID == mockUser.ID {
+		return 1, nil
+	}
+	return 0, nil
+}
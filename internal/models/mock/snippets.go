@@ -1,19 +1,101 @@
 package mock
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tullo/snptx/internal/models"
+	"github.com/tullo/snptx/internal/platform/search"
 )
 
 var mockSnippet = &models.Snippet{
 	ID:          "1",
+	OwnerID:     "1",
 	Title:       "An old silent pond",
 	Content:     "An old silent pond...",
+	Visibility:  models.VisibilityPublic,
+	Language:    "plaintext",
+	Tags:        []string{"haiku", "nature"},
 	DateCreated: time.Now(),
-	DateExpires: time.Now(),
+	DateExpires: time.Now().Add(24 * time.Hour),
+}
+
+// foreignSnippet is owned by a user other than the mocked alice.
+var foreignSnippet = &models.Snippet{
+	ID:          "3",
+	OwnerID:     "2",
+	Title:       "Over the wintry forest",
+	Content:     "Over the wintry forest...",
+	Visibility:  models.VisibilityUnlisted,
+	Language:    "plaintext",
+	DateCreated: time.Now(),
+	DateExpires: time.Now().Add(24 * time.Hour),
+}
+
+// privateSnippet is a private snippet owned by a user other than the mocked alice.
+var privateSnippet = &models.Snippet{
+	ID:          "4",
+	OwnerID:     "2",
+	Title:       "Haiku",
+	Content:     "First autumn morning...",
+	Visibility:  models.VisibilityPrivate,
+	Language:    "plaintext",
+	Tags:        []string{"haiku", "private-notes"},
+	DateCreated: time.Now(),
+	DateExpires: time.Now().Add(24 * time.Hour),
+}
+
+// markdownSnippet is a public Markdown snippet owned by a user other than
+// the mocked alice.
+var markdownSnippet = &models.Snippet{
+	ID:          "5",
+	OwnerID:     "2",
+	Title:       "Runbook",
+	Content:     "# Runbook\n\n<script>alert(1)</script>\n\n- [x] deploy\n",
+	Visibility:  models.VisibilityPublic,
+	Language:    "markdown",
+	DateCreated: time.Now(),
+	DateExpires: time.Now().Add(24 * time.Hour),
+}
+
+// expiredSnippet is a public snippet which has expired but was not purged.
+var expiredSnippet = &models.Snippet{
+	ID:          "6",
+	OwnerID:     "1",
+	Title:       "Autumn moonlight",
+	Content:     "Autumn moonlight...",
+	Visibility:  models.VisibilityPublic,
+	Language:    "plaintext",
+	DateCreated: time.Now().Add(-48 * time.Hour),
+	DateExpires: time.Now().Add(-24 * time.Hour),
+}
+
+var mockRevisions = []models.Revision{
+	{
+		ID:          "12",
+		SnippetID:   "1",
+		Number:      2,
+		AuthorID:    "1",
+		AuthorName:  "Alice",
+		Title:       "An old silent pond",
+		Content:     "An old silent pond...\nA frog jumps into the pond,\nsplash! Silence again.",
+		DateCreated: time.Now(),
+	},
+	{
+		ID:          "11",
+		SnippetID:   "1",
+		Number:      1,
+		AuthorID:    "1",
+		AuthorName:  "Alice",
+		Title:       "An old silent pond",
+		Content:     "An old silent pond...\nA frog jumps into the lake,\nsplash! Silence again.",
+		DateCreated: time.Now(),
+	},
 }
 
 // SnippetStore manages the set of API's for snippet access
@@ -26,9 +108,19 @@ func NewSnippetStore() SnippetStore {
 }
 
 // Create inserts a new snippet record into the database.
-func (s SnippetStore) Create(context.Context, models.NewSnippet, time.Time) (*models.Snippet, error) {
-	var spt models.Snippet
-	spt.ID = "2"
+func (s SnippetStore) Create(ctx context.Context, ns models.NewSnippet, now time.Time) (*models.Snippet, error) {
+	spt := models.Snippet{
+		ID:          "2",
+		OwnerID:     ns.OwnerID,
+		Title:       ns.Title,
+		Content:     ns.Content,
+		Visibility:  ns.Visibility,
+		Language:    ns.Language,
+		Tags:        ns.Tags,
+		DateExpires: ns.DateExpires,
+		DateCreated: now,
+		DateUpdated: now,
+	}
 	return &spt, nil
 }
 
@@ -37,6 +129,14 @@ func (s SnippetStore) Retrieve(ctx context.Context, id string) (*models.Snippet,
 	switch id {
 	case "1":
 		return mockSnippet, nil
+	case "3":
+		return foreignSnippet, nil
+	case "4":
+		return privateSnippet, nil
+	case "5":
+		return markdownSnippet, nil
+	case "6":
+		return expiredSnippet, nil
 	case "66":
 		return nil, fmt.Errorf("internal server error")
 	default:
@@ -44,15 +144,112 @@ func (s SnippetStore) Retrieve(ctx context.Context, id string) (*models.Snippet,
 	}
 }
 
-// Latest gets the latest snippets from the database.
-func (s SnippetStore) Latest(context.Context) ([]models.Snippet, error) {
-	return []models.Snippet{*mockSnippet}, nil
+// List gets a page of the mocked snippets matching the filter, applying the
+// same visibility and expiry rules as the database. The snippets are listed
+// in the order of their IDs whatever the sort order, the cursors are "page1",
+// "page2" and so on.
+func (s SnippetStore) List(ctx context.Context, f models.SnippetFilter) (*models.SnippetPage, error) {
+	if f.Limit <= 0 {
+		f.Limit = 10
+	}
+	page := 1
+	if f.Cursor != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(f.Cursor, "page"))
+		if err != nil || n < 1 || !strings.HasPrefix(f.Cursor, "page") {
+			return nil, models.ErrInvalidCursor
+		}
+		page = n
+	}
+
+	own := f.All || (f.OwnerID != "" && f.OwnerID == f.ViewerID)
+	var matches []models.Snippet
+	for _, spt := range []*models.Snippet{mockSnippet, foreignSnippet, privateSnippet, markdownSnippet, expiredSnippet} {
+		switch {
+		case !own && spt.Visibility != models.VisibilityPublic,
+			(!own || !f.IncludeExpired) && spt.DateExpires.Before(time.Now()),
+			f.OwnerID != "" && spt.OwnerID != f.OwnerID,
+			f.Tag != "" && !slices.Contains(spt.Tags, f.Tag),
+			!f.CreatedAfter.IsZero() && spt.DateCreated.Before(f.CreatedAfter),
+			!f.CreatedBefore.IsZero() && !spt.DateCreated.Before(f.CreatedBefore):
+			continue
+		}
+		matches = append(matches, *spt)
+	}
+
+	var p models.SnippetPage
+	start := (page - 1) * f.Limit
+	if start < len(matches) {
+		p.Snippets = matches[start:min(start+f.Limit, len(matches))]
+	}
+	if start+f.Limit < len(matches) {
+		p.Next = fmt.Sprintf("page%d", page+1)
+	}
+	if page > 1 {
+		p.Prev = fmt.Sprintf("page%d", page-1)
+	}
+	return &p, nil
+}
+
+// Search gets the mocked snippets matching the query, applying the same
+// visibility and expiry rules as the database. Snippets are ranked by the
+// number of matched words.
+func (s SnippetStore) Search(ctx context.Context, ss models.SnippetSearch) ([]models.SearchResult, error) {
+	q := search.Parse(ss.Query)
+
+	var res []models.SearchResult
+	for _, spt := range []*models.Snippet{mockSnippet, foreignSnippet, privateSnippet} {
+		if spt.Visibility != models.VisibilityPublic && spt.OwnerID != ss.ViewerID {
+			continue
+		}
+		if spt.DateExpires.Before(time.Now()) || !q.Match(spt.Title+" "+spt.Content) {
+			continue
+		}
+
+		r := models.SearchResult{
+			Snippet:  *spt,
+			Headline: q.Highlight(spt.Title),
+			Excerpt:  q.Excerpt(spt.Content, 200),
+		}
+		for _, fs := range [][]search.Fragment{r.Headline, r.Excerpt} {
+			for _, f := range fs {
+				if f.Match {
+					r.Rank++
+				}
+			}
+		}
+		res = append(res, r)
+	}
+
+	slices.SortStableFunc(res, func(a, b models.SearchResult) int {
+		return cmp.Compare(b.Rank, a.Rank)
+	})
+
+	return res, nil
+}
+
+// SuggestTags gets the tags of the mocked snippets readable by the viewer
+// which start with prefix.
+func (s SnippetStore) SuggestTags(ctx context.Context, viewerID, prefix string) ([]string, error) {
+	var tags []string
+	for _, spt := range []*models.Snippet{mockSnippet, foreignSnippet, privateSnippet} {
+		if spt.Visibility != models.VisibilityPublic && spt.OwnerID != viewerID {
+			continue
+		}
+		for _, t := range spt.Tags {
+			if strings.HasPrefix(t, prefix) && !slices.Contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+	}
+	slices.Sort(tags)
+
+	return tags, nil
 }
 
 // Update updates a snippet record in the database.
-func (s SnippetStore) Update(ctx context.Context, id string, us models.UpdateSnippet, t time.Time) error {
+func (s SnippetStore) Update(ctx context.Context, id string, us models.UpdateSnippet, author string, t time.Time) error {
 	switch id {
-	case "1":
+	case "1", "3", "4":
 		return nil
 	case "66":
 		return fmt.Errorf("internal server error")
@@ -65,3 +262,23 @@ func (s SnippetStore) Update(ctx context.Context, id string, us models.UpdateSni
 func (s SnippetStore) Delete(context.Context, string) error {
 	return nil
 }
+
+// Revisions gets the revisions of the specified snippet, newest first.
+func (s SnippetStore) Revisions(ctx context.Context, id string) ([]models.Revision, error) {
+	switch id {
+	case "1":
+		return mockRevisions, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Revision gets the specified revision of a snippet.
+func (s SnippetStore) Revision(ctx context.Context, id, revisionID string) (*models.Revision, error) {
+	for _, r := range mockRevisions {
+		if r.SnippetID == id && r.ID == revisionID {
+			return &r, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
@@ -0,0 +1,108 @@
+package mock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tullo/snptx/internal/models"
+)
+
+// mockTOTPSecret is the secret users enroll with.
+var mockTOTPSecret = []byte("12345678901234567890")
+
+// mockRecoveryCodes are returned when enabling two-factor authentication or
+// generating new recovery codes.
+var mockRecoveryCodes = []string{"ABCD-EFGH-IJKL-MNOP", "QRST-UVWX-YZ23-4567"}
+
+// TwoFactorStore mocks the two-factor authentication of the users. Gina has
+// enabled it and logs in with the code "123456" or the recovery code
+// "ABCD-EFGH-IJKL-MNOP". Everybody else may enroll, "123456" completes the
+// enrollment.
+type TwoFactorStore struct {
+	mu       sync.Mutex
+	enrolled map[string]bool
+}
+
+// NewTwoFactorStore constructs a TwoFactorStore for api access.
+func NewTwoFactorStore() *TwoFactorStore {
+	return &TwoFactorStore{enrolled: make(map[string]bool)}
+}
+
+// Status gets the state of the two-factor authentication of the user.
+func (s *TwoFactorStore) Status(ctx context.Context, userID string) (*models.TwoFactor, error) {
+	if userID == mockTwoFactorUser.ID {
+		return &models.TwoFactor{Enabled: true, DateEnabled: mockTwoFactorUser.DateCreated, RecoveryCodes: 9}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &models.TwoFactor{Pending: s.enrolled[userID]}, nil
+}
+
+// Enroll returns the TOTP secret of the user.
+func (s *TwoFactorStore) Enroll(ctx context.Context, userID string, now time.Time) ([]byte, error) {
+	if userID == mockTwoFactorUser.ID {
+		return nil, models.ErrTwoFactorEnabled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enrolled[userID] = true
+	return mockTOTPSecret, nil
+}
+
+// Secret returns the TOTP secret of a user who enrolled.
+func (s *TwoFactorStore) Secret(ctx context.Context, userID string) ([]byte, error) {
+	if userID == mockTwoFactorUser.ID {
+		return nil, models.ErrTwoFactorEnabled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enrolled[userID] {
+		return nil, models.ErrNoRecord
+	}
+	return mockTOTPSecret, nil
+}
+
+// Enable completes the enrollment and returns the recovery codes.
+func (s *TwoFactorStore) Enable(ctx context.Context, userID, code string, now time.Time) ([]string, error) {
+	if userID == mockTwoFactorUser.ID {
+		return nil, models.ErrTwoFactorEnabled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enrolled[userID] {
+		return nil, models.ErrNoRecord
+	}
+	if code != "123456" {
+		return nil, models.ErrInvalidCode
+	}
+	return mockRecoveryCodes, nil
+}
+
+// Verify checks a code of the authenticator app or a recovery code.
+func (s *TwoFactorStore) Verify(ctx context.Context, userID, code string, now time.Time) error {
+	if userID != mockTwoFactorUser.ID || (code != "123456" && code != mockRecoveryCodes[0]) {
+		return models.ErrInvalidCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user.
+func (s *TwoFactorStore) RegenerateRecoveryCodes(ctx context.Context, userID string, now time.Time) ([]string, error) {
+	if userID != mockTwoFactorUser.ID {
+		return nil, models.ErrNoRecord
+	}
+	return mockRecoveryCodes, nil
+}
+
+// Disable removes the two-factor authentication of the user.
+func (s *TwoFactorStore) Disable(ctx context.Context, userID string) error {
+	if userID != mockTwoFactorUser.ID {
+		return models.ErrNoRecord
+	}
+	return nil
+}
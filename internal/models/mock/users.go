@@ -2,10 +2,10 @@ package mock
 
 import (
 	"context"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/dgrijalva/jwt-go/v4"
-	"github.com/google/uuid"
 	"github.com/tullo/snptx/internal/models"
 	"github.com/tullo/snptx/internal/platform/auth"
 )
@@ -14,92 +14,283 @@ var mockUser = &models.User{
 	ID:          "1",
 	Name:        "Alice",
 	Email:       "alice@example.com",
+	Roles:       []string{auth.RoleUser},
 	DateCreated: time.Now(),
 	Active:      true,
+	Verified:    true,
 }
 
-// UserStore manages the set of API's for user access. It wraps a sql.DB
-// connection pool.
+// mockAdmin is carol, an admin moderating the users and snippets.
+var mockAdmin = &models.User{
+	ID:          "9",
+	Name:        "Carol",
+	Email:       "carol@example.com",
+	Roles:       []string{auth.RoleAdmin, auth.RoleUser},
+	DateCreated: time.Now(),
+	Active:      true,
+	Verified:    true,
+}
+
+// mockInactiveUser is dave, whose account has been deactivated.
+var mockInactiveUser = &models.User{
+	ID:          "4",
+	Name:        "Dave",
+	Email:       "dave@example.com",
+	Roles:       []string{auth.RoleUser},
+	DateCreated: time.Now(),
+	Active:      false,
+	Verified:    true,
+}
+
+// mockUnverifiedUser is erin, who has not verified her email address yet.
+var mockUnverifiedUser = &models.User{
+	ID:          "12",
+	Name:        "Erin",
+	Email:       "erin@example.com",
+	Roles:       []string{auth.RoleUser},
+	DateCreated: time.Now(),
+	Active:      true,
+}
+
+// mockRecentlyNotifiedUser is frank, who has not verified his email address
+// and was sent a verification link a moment ago.
+var mockRecentlyNotifiedUser = &models.User{
+	ID:          "13",
+	Name:        "Frank",
+	Email:       "frank@example.com",
+	Roles:       []string{auth.RoleUser},
+	DateCreated: time.Now(),
+	Active:      true,
+}
+
+// mockTwoFactorUser is gina, who has enabled two-factor authentication.
+var mockTwoFactorUser = &models.User{
+	ID:          "14",
+	Name:        "Gina",
+	Email:       "gina@example.com",
+	Roles:       []string{auth.RoleUser},
+	DateCreated: time.Now(),
+	Active:      true,
+	Verified:    true,
+}
+
+// mockSSOUser is hank, whose account was provisioned by single sign-on and
+// has no password.
+var mockSSOUser = &models.User{
+	ID:          "15",
+	Name:        "Hank",
+	Email:       "hank@example.com",
+	Roles:       []string{auth.RoleUser},
+	DateCreated: time.Now(),
+	Active:      true,
+	Verified:    true,
+	SSOOnly:     true,
+}
+
+// UserStore keeps the mock users in memory. Created users are kept as well,
+// they get the IDs 100, 101 and so on.
 type UserStore struct {
+	mu      sync.Mutex
+	created []*models.User
 }
 
 // NewUserStore constructs a UserStore for api access.
-func NewUserStore() UserStore {
-	var u UserStore
-	return u
+func NewUserStore() *UserStore {
+	return &UserStore{}
 }
 
-func (u UserStore) Exists(ctx context.Context, id string) (bool, error) {
-	switch id {
-	case "1":
-		return true, nil
-	default:
-		return false, nil
-	}
+func (u *UserStore) Exists(ctx context.Context, id string) (bool, error) {
+	_, err := u.QueryByID(ctx, id)
+	return err == nil, nil
 }
 
 // Authenticate finds a user by their email and verifies their password.
-func (u UserStore) Authenticate(ctx context.Context, now time.Time, email, password string) (auth.Claims, error) {
+func (u *UserStore) Authenticate(ctx context.Context, now time.Time, email, password string) (auth.Claims, error) {
 
 	switch email {
 	case "alice@example.com":
 		if password != "validPa$$word" {
 			return auth.Claims{}, models.ErrAuthenticationFailure
 		}
-		return auth.Claims{StandardClaims: jwt.StandardClaims{Subject: "1"}}, nil
+		return auth.NewClaims("1", mockUser.Roles, now, time.Hour), nil
+
+	case "carol@example.com":
+		if password != "validPa$$word" {
+			return auth.Claims{}, models.ErrAuthenticationFailure
+		}
+		return auth.NewClaims("9", mockAdmin.Roles, now, time.Hour), nil
+
+	case "gina@example.com":
+		if password != "validPa$$word" {
+			return auth.Claims{}, models.ErrAuthenticationFailure
+		}
+		return auth.NewClaims(mockTwoFactorUser.ID, mockTwoFactorUser.Roles, now, time.Hour), nil
+
+	case "erin@example.com", "frank@example.com":
+		if password != "validPa$$word" {
+			return auth.Claims{}, models.ErrAuthenticationFailure
+		}
+		usr := mockUnverifiedUser
+		if email == mockRecentlyNotifiedUser.Email {
+			usr = mockRecentlyNotifiedUser
+		}
+		return auth.NewClaims(usr.ID, usr.Roles, now, time.Hour), nil
+
+	case "dave@example.com":
+		if password != "validPa$$word" {
+			return auth.Claims{}, models.ErrAuthenticationFailure
+		}
+		return auth.Claims{}, models.ErrInactiveUser
 
 	default:
 		return auth.Claims{}, models.ErrAuthenticationFailure
 	}
 }
 
-// ChangePassword generates a hash based on the new password and saves it to the db.
-func (u UserStore) ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error {
-	if currentPassword != "validPa$$word" {
+// CheckPassword compares the password with the one of the user.
+func (u *UserStore) CheckPassword(ctx context.Context, id, password string) error {
+	usr, err := u.QueryByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if usr.SSOOnly {
+		return models.ErrNoPassword
+	}
+	if password != "validPa$$word" {
 		return models.ErrInvalidCredentials
 	}
 
 	return nil
 }
 
-// Create inserts a new user into the database.
-func (u UserStore) Create(ctx context.Context, nu models.NewUser, now time.Time) (*models.User, error) {
-	switch nu.Email {
-	case "dupe@example.com":
+// ChangePassword generates a hash based on the new password and saves it to the db.
+func (u *UserStore) ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error {
+	return u.CheckPassword(ctx, id, currentPassword)
+}
+
+// Create adds a new user to the created ones.
+func (u *UserStore) Create(ctx context.Context, nu models.NewUser, now time.Time) (*models.User, error) {
+	if _, err := u.QueryByEmail(ctx, nu.Email); err == nil || nu.Email == "dupe@example.com" {
 		return nil, models.ErrDuplicateEmail
-	default:
-		var usr models.User
-		return &usr, nil
 	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	usr := models.User{
+		ID:          strconv.Itoa(100 + len(u.created)),
+		Name:        nu.Name,
+		Email:       nu.Email,
+		Active:      true,
+		Roles:       nu.Roles,
+		DateCreated: now,
+		DateUpdated: now,
+		SSOOnly:     nu.Password == "",
+	}
+	u.created = append(u.created, &usr)
+	return &usr, nil
 }
 
-// Delete removes a user from the database.
-func (u UserStore) Delete(ctx context.Context, id string) error {
-	if _, err := uuid.Parse(id); err != nil {
+// Delete removes a user from the database, handing over their snippets to the
+// successor.
+func (u *UserStore) Delete(ctx context.Context, id, successorID string) error {
+	if successorID == id {
 		return models.ErrInvalidID
 	}
+	if _, err := u.QueryByID(ctx, id); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 // List retrieves a list of existing users from the database.
-func (u UserStore) List(ctx context.Context) ([]models.User, error) {
+func (u *UserStore) List(ctx context.Context) ([]models.User, error) {
+	users := []models.User{*mockUser, *mockAdmin, *mockInactiveUser, *mockUnverifiedUser, *mockRecentlyNotifiedUser, *mockTwoFactorUser, *mockSSOUser}
 
-	var users []models.User
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, usr := range u.created {
+		users = append(users, *usr)
+	}
 	return users, nil
 }
 
 // QueryByID gets the specified user from the database.
-func (u UserStore) QueryByID(ctx context.Context, id string) (*models.User, error) {
+func (u *UserStore) QueryByID(ctx context.Context, id string) (*models.User, error) {
 	switch id {
 	case "1":
 		return mockUser, nil
-	default:
-		return nil, models.ErrNoRecord
+	case "4":
+		return mockInactiveUser, nil
+	case "12":
+		return mockUnverifiedUser, nil
+	case "13":
+		return mockRecentlyNotifiedUser, nil
+	case "14":
+		return mockTwoFactorUser, nil
+	case "9":
+		return mockAdmin, nil
+	case "15":
+		return mockSSOUser, nil
 	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, usr := range u.created {
+		if usr.ID == id {
+			return usr, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// QueryByEmail gets the user with the email.
+func (u *UserStore) QueryByEmail(ctx context.Context, email string) (*models.User, error) {
+	users, _ := u.List(ctx)
+	for i := range users {
+		if users[i].Email == email {
+			return &users[i], nil
+		}
+	}
+	return nil, models.ErrNoRecord
 }
 
 // Update replaces a user document in the database.
-func (u UserStore) Update(context.Context, string, models.UpdateUser, time.Time) error {
+func (u *UserStore) Update(ctx context.Context, id string, upd models.UpdateUser, now time.Time) error {
+	if _, err := u.QueryByID(ctx, id); err != nil {
+		return err
+	}
+	if upd.Email != nil {
+		if usr, err := u.QueryByEmail(ctx, *upd.Email); err == nil && usr.ID != id || *upd.Email == "dupe@example.com" {
+			return models.ErrDuplicateEmail
+		}
+	}
 	return nil
 }
+
+// VerifyEmail marks the email of the user as verified.
+func (u *UserStore) VerifyEmail(ctx context.Context, id, email string, now time.Time) error {
+	usr, err := u.QueryByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if usr.Email != email {
+		return models.ErrInvalidToken
+	}
+	return nil
+}
+
+// RecordVerificationSent records that a verification link is sent to the
+// user. Frank was sent one a moment ago.
+func (u *UserStore) RecordVerificationSent(ctx context.Context, id string, now time.Time, interval time.Duration) error {
+	usr, err := u.QueryByID(ctx, id)
+	switch {
+	case err != nil:
+		return err
+	case usr.Verified || usr.ID == mockRecentlyNotifiedUser.ID:
+		return models.ErrRateLimited
+	default:
+		return nil
+	}
+}
@@ -2,13 +2,26 @@ package models
 
 import (
 	"time"
+
+	"github.com/tullo/snptx/internal/platform/search"
+)
+
+// Visibility levels of a Snippet.
+const (
+	VisibilityPublic   = "public"   // listed on the home page
+	VisibilityUnlisted = "unlisted" // reachable by URL only
+	VisibilityPrivate  = "private"  // readable by the owner and admins only
 )
 
 // Info represents a textual extract of something
 type Snippet struct {
 	ID          string    `json:"id"`
+	OwnerID     string    `json:"owner_id"`
 	Title       string    `json:"title"`
 	Content     string    `json:"content"`
+	Visibility  string    `json:"visibility"`
+	Language    string    `json:"language"`
+	Tags        []string  `json:"tags"`
 	DateExpires time.Time `json:"date_expires"`
 	DateCreated time.Time `json:"date_created"`
 	DateUpdated time.Time `json:"date_updated"`
@@ -16,8 +29,12 @@ type Snippet struct {
 
 // NewSnippet contains information needed to create a new Snippet.
 type NewSnippet struct {
+	OwnerID     string    `json:"owner_id" validate:"required"`
 	Title       string    `json:"title" validate:"required"`
 	Content     string    `json:"content" validate:"required"`
+	Visibility  string    `json:"visibility" validate:"required"`
+	Language    string    `json:"language"`
+	Tags        []string  `json:"tags"`
 	DateExpires time.Time `json:"date_expires" validate:"required"`
 }
 
@@ -28,9 +45,107 @@ type NewSnippet struct {
 type UpdateSnippet struct {
 	Title       *string    `json:"title"`
 	Content     *string    `json:"content"`
+	Visibility  *string    `json:"visibility"`
+	Language    *string    `json:"language"`
+	Tags        *[]string  `json:"tags"`
 	DateExpires *time.Time `json:"date_expires"`
 }
 
+// Sort orders supported when listing snippets.
+const (
+	SortCreated = "created" // newest first
+	SortUpdated = "updated" // most recently updated first
+	SortTitle   = "title"   // alphabetically
+	SortExpires = "expires" // expiring soon first
+)
+
+// SnippetFilter defines which snippets are listed and in which order. The zero
+// value lists the latest public, non-expired snippets.
+type SnippetFilter struct {
+	// ViewerID identifies the user requesting the list. Owners listing their
+	// own snippets also see unlisted, private and (on request) expired ones.
+	ViewerID       string
+	OwnerID        string
+	Tag            string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	IncludeExpired bool
+	Sort           string
+	Cursor         string
+	Limit          int
+	// All lists the snippets of all owners regardless of their visibility,
+	// it is meant for moderation by admins.
+	All bool
+}
+
+// SnippetPage is a single page of a snippet listing. Next and Prev hold the
+// cursors of the adjacent pages and are empty when there is no such page.
+type SnippetPage struct {
+	Snippets []Snippet
+	Next     string
+	Prev     string
+}
+
+// SnippetSearch defines a full-text search over the snippets readable by
+// the viewer: public snippets and the viewer's own. Expired snippets are
+// never found. See package search for the query syntax.
+type SnippetSearch struct {
+	ViewerID string
+	Query    string
+	Limit    int
+}
+
+// SearchResult is a snippet found by a search. Headline and Excerpt hold the
+// title and an excerpt of the content with the matches of the query marked.
+type SearchResult struct {
+	Snippet
+	Rank     float64
+	Headline []search.Fragment
+	Excerpt  []search.Fragment
+}
+
+// Revision is an immutable copy of a snippet's title and content, recorded
+// whenever the snippet is created or updated.
+type Revision struct {
+	ID          string    `json:"id"`
+	SnippetID   string    `json:"snippet_id"`
+	Number      int       `json:"number"`
+	AuthorID    string    `json:"author_id"`
+	AuthorName  string    `json:"author_name"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+// AccessToken is a personal access token authenticating API requests on
+// behalf of its user with the granted scopes. Zero expiry and last used
+// times mean that the token never expires and has not been used yet.
+type AccessToken struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	Name         string    `json:"name"`
+	Scopes       []string  `json:"scopes"`
+	DateExpires  time.Time `json:"date_expires"`
+	DateLastUsed time.Time `json:"date_last_used"`
+	DateCreated  time.Time `json:"date_created"`
+}
+
+// NewAccessToken contains information needed to create a new AccessToken.
+type NewAccessToken struct {
+	UserID      string    `json:"user_id" validate:"required"`
+	Name        string    `json:"name" validate:"required"`
+	Scopes      []string  `json:"scopes" validate:"required"`
+	DateExpires time.Time `json:"date_expires"`
+}
+
+// TwoFactor is the state of the two-factor authentication of a user.
+type TwoFactor struct {
+	Enabled       bool      `json:"enabled"`
+	Pending       bool      `json:"pending"` // enrolled but not enabled yet
+	DateEnabled   time.Time `json:"date_enabled"`
+	RecoveryCodes int       `json:"recovery_codes"` // number of unused recovery codes
+}
+
 // Info represents information about an individual user.
 type User struct {
 	ID             string    `json:"id"`
@@ -41,6 +156,10 @@ type User struct {
 	HashedPassword string    `json:"-"`
 	DateCreated    time.Time `json:"date_created"`
 	DateUpdated    time.Time `json:"date_updated"`
+	Verified       bool      `json:"verified"`
+	// SSOOnly marks an account provisioned by single sign-on, it has no
+	// password and its user logs in with the identity provider only.
+	SSOOnly bool `json:"sso_only"`
 }
 
 // NewUser contains information needed to create a new User.
@@ -48,7 +167,7 @@ type NewUser struct {
 	Name            string   `json:"name" validate:"required"`
 	Email           string   `json:"email" validate:"required"`
 	Roles           []string `json:"roles" validate:"required"`
-	Password        string   `json:"password" validate:"required"`
+	Password        string   `json:"password" validate:"required"` // empty for accounts provisioned by single sign-on
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
 }
 
@@ -59,8 +178,44 @@ type NewUser struct {
 type UpdateUser struct {
 	Name            *string  `json:"name"`
 	Email           *string  `json:"email"`
-	Active          bool     `json:"active"`
+	Active          *bool    `json:"active"`
 	Roles           []string `json:"roles"`
 	Password        *string  `json:"password"`
 	PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
 }
+
+// Session describes a logged in session of a user, e.g. on one of their
+// devices.
+type Session struct {
+	ID           string    `json:"id"`      // derived from the session token, which is kept secret
+	Current      bool      `json:"current"` // the session making the request
+	DateCreated  time.Time `json:"date_created"`
+	DateLastSeen time.Time `json:"date_last_seen"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+}
+
+// AuditEvent records a security relevant event, e.g. a login.
+type AuditEvent struct {
+	ID          string            `json:"id"`
+	Type        string            `json:"type"`
+	ActorID     string            `json:"actor_id"`  // the user causing the event, empty if anonymous
+	TargetID    string            `json:"target_id"` // the user or snippet affected by the event
+	IP          string            `json:"ip"`
+	UserAgent   string            `json:"user_agent"`
+	Details     map[string]string `json:"details"`
+	DateCreated time.Time         `json:"date_created"`
+}
+
+// AuditFilter defines which audit events are listed, the latest first. The
+// zero value lists the latest events of all types.
+type AuditFilter struct {
+	Type   string
+	UserID string // lists the events caused by or affecting the user
+	// Before and BeforeID list the events following the event with the ID
+	// created at Before, for paging. Events created at the same time are
+	// ordered by their IDs.
+	Before   time.Time
+	BeforeID string
+	Limit    int
+}
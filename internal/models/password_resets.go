@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/tullo/snptx/internal/db"
+	"github.com/tullo/snptx/internal/platform/database"
+	"go.opencensus.io/trace"
+)
+
+type PasswordResetModelInterface interface {
+	Create(context.Context, string, time.Time, time.Duration) (string, *User, error)
+	Check(context.Context, string, time.Time) error
+	Reset(context.Context, string, string, time.Time) (string, error)
+}
+
+// PasswordResetStore manages the one-time tokens sent to users who forgot
+// their password. Only the SHA-256 hashes of the tokens are stored.
+type PasswordResetStore struct {
+	db *database.DB
+	hp *argon2id.Params
+	q  *db.Queries
+}
+
+// NewPasswordResetStore constructs a PasswordResetStore for api access.
+func NewPasswordResetStore(d *database.DB, hp *argon2id.Params) PasswordResetStore {
+	return PasswordResetStore{
+		db: d,
+		hp: hp,
+		q:  db.New(d),
+	}
+}
+
+// Create generates a reset token for the user with the email which is valid
+// for ttl. It returns the token and the user to send it to. Unknown emails
+// fail with ErrNoRecord, deactivated users with ErrInactiveUser. Accounts
+// provisioned by single sign-on have no password to reset, they fail with
+// ErrNoPassword and the user is returned, to be told to log in with single
+// sign-on.
+func (s PasswordResetStore) Create(ctx context.Context, email string, now time.Time, ttl time.Duration) (string, *User, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.passwordReset.Create")
+	defer span.End()
+
+	u, err := s.q.GetUserByEmail(ctx, db.AsText(email))
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return "", nil, ErrNoRecord
+		}
+		return "", nil, fmt.Errorf("selecting user %q: [%w]", email, err)
+	}
+	if !u.Active.Bool {
+		return "", nil, ErrInactiveUser
+	}
+	usr := User{
+		ID:      u.UserID,
+		Name:    u.Name.String,
+		Email:   u.Email.String,
+		SSOOnly: !u.PasswordHash.Valid,
+	}
+	if usr.SSOOnly {
+		return "", &usr, ErrNoPassword
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = s.q.CreatePasswordReset(ctx, db.GetCreatePasswordResetParams(hashToken(token), u.UserID, now.Add(ttl), now))
+	if err != nil {
+		return "", nil, fmt.Errorf("inserting password reset: [%w]", err)
+	}
+
+	return token, &usr, nil
+}
+
+// Check verifies that the token may be used to reset a password.
+func (s PasswordResetStore) Check(ctx context.Context, token string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.passwordReset.Check")
+	defer span.End()
+
+	pr, err := s.q.GetPasswordReset(ctx, hashToken(token))
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return ErrInvalidToken
+		}
+		return fmt.Errorf("selecting password reset: [%w]", err)
+	}
+	if !pr.DateExpires.Time.After(now) {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// Reset uses up the token and replaces the password of its user. All reset
+// tokens and sessions of the user are deleted, logging out whoever may have
+// used the old password. It returns the ID of the user.
+func (s PasswordResetStore) Reset(ctx context.Context, token, password string, now time.Time) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.passwordReset.Reset")
+	defer span.End()
+
+	hash, err := argon2id.CreateHash(password, s.hp)
+	if err != nil {
+		return "", fmt.Errorf("generating password hash: [%w]", err)
+	}
+
+	var userID string
+	err = runInTx(ctx, s.db, s.q, func(q *db.Queries) error {
+		// deleting the token makes sure it is used once only
+		pr, err := q.ConsumePasswordReset(ctx, hashToken(token))
+		if err != nil {
+			if pgxscan.NotFound(err) {
+				return ErrInvalidToken
+			}
+			return fmt.Errorf("deleting password reset: [%w]", err)
+		}
+		if !pr.DateExpires.Time.After(now) {
+			return ErrInvalidToken
+		}
+		userID = pr.UserID
+
+		if err := q.ChangePassword(ctx, db.GetChangePasswordParams(hash, userID)); err != nil {
+			return fmt.Errorf("changing the password: [%w]", err)
+		}
+		if err := q.DeleteUserPasswordResets(ctx, userID); err != nil {
+			return fmt.Errorf("deleting password resets of user %q: [%w]", userID, err)
+		}
+		if _, err := q.DeleteUserSessions(ctx, userID); err != nil {
+			return fmt.Errorf("deleting sessions of user %q: [%w]", userID, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return userID, nil
+}
+
+// DeleteExpired removes at most limit expired reset tokens, the oldest
+// first. It returns the number of tokens removed.
+func (s PasswordResetStore) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.passwordReset.DeleteExpired")
+	defer span.End()
+
+	n, err := s.q.DeleteExpiredPasswordResets(ctx, int32(limit))
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired password resets: [%w]", err)
+	}
+
+	return int(n), nil
+}
@@ -1,16 +1,177 @@
 package models
 
 import (
+	"context"
+	"encoding/hex"
+	"slices"
+	"time"
+
 	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tullo/snptx/internal/db"
 	"github.com/tullo/snptx/internal/platform/database"
+	"go.opencensus.io/trace"
 )
 
+// SessionsStore stores the sessions of the session manager. Expired sessions
+// are not cleaned up by the store itself but with DeleteExpired. Sessions
+// are stored with the ID of their authenticated user, so all sessions of a
+// user can be deleted at once.
 type SessionsStore struct {
 	*postgresstore.PostgresStore
+	q *db.Queries
+}
+
+type SessionModelInterface interface {
+	List(context.Context, string, string) ([]Session, error)
+	Revoke(context.Context, string, string) error
+	RevokeOthers(context.Context, string, string) (int, error)
+}
+
+// Session keys stored along with the session, times are stored as Unix
+// seconds.
+const (
+	SessionUserIDKey    = "authenticatedUserID" // ID of the authenticated user
+	SessionCreatedKey   = "sessionCreated"      // time of the login
+	SessionLastSeenKey  = "sessionLastSeen"
+	SessionIPKey        = "sessionIP"
+	SessionUserAgentKey = "sessionUserAgent"
+)
+
+// SessionID derives the ID of a session shown to its user from the token.
+func SessionID(token string) string {
+	return hex.EncodeToString(hashToken(token)[:16])
 }
 
-func NewSessionsStore(db *database.DB) SessionsStore {
+func NewSessionsStore(d *database.DB) SessionsStore {
 	return SessionsStore{
-		postgresstore.New(database.StdLibConnection(db.Pool)),
+		PostgresStore: postgresstore.NewWithCleanupInterval(database.StdLibConnection(d.Pool), 0),
+		q:             db.New(d),
+	}
+}
+
+// DeleteExpired removes at most limit expired sessions, the oldest first.
+// It returns the number of sessions removed.
+func (s SessionsStore) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.session.DeleteExpired")
+	defer span.End()
+
+	n, err := s.q.DeleteExpiredSessions(ctx, int32(limit))
+	if err != nil {
+		return 0, errors.Wrap(err, "deleting expired sessions")
+	}
+
+	return int(n), nil
+}
+
+// Commit adds or replaces the session. The session data is decoded with the
+// default codec of the session manager to find the ID of the authenticated
+// user and the metadata of the session.
+func (s SessionsStore) Commit(token string, b []byte, expiry time.Time) error {
+	var (
+		userID, ip, userAgent string
+		created, lastSeen     time.Time
+	)
+	if _, values, err := (scs.GobCodec{}).Decode(b); err == nil {
+		userID, _ = values[SessionUserIDKey].(string)
+		ip, _ = values[SessionIPKey].(string)
+		userAgent, _ = values[SessionUserAgentKey].(string)
+		if sec, ok := values[SessionCreatedKey].(int64); ok {
+			created = time.Unix(sec, 0)
+		}
+		if sec, ok := values[SessionLastSeenKey].(int64); ok {
+			lastSeen = time.Unix(sec, 0)
+		}
+	}
+
+	err := s.q.CommitSession(context.Background(), db.GetCommitSessionParams(token, b, expiry, userID, created, lastSeen, ip, userAgent))
+	if err != nil {
+		return errors.Wrap(err, "committing session")
+	}
+
+	return nil
+}
+
+// List returns the sessions of the user, the most recently seen first. The
+// session with the current token is marked as current.
+func (s SessionsStore) List(ctx context.Context, userID, currentToken string) ([]Session, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.session.List")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	rows, err := s.q.ListUserSessions(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting sessions")
+	}
+
+	sessions := make([]Session, len(rows))
+	for i, r := range rows {
+		sessions[i] = Session{
+			ID:           SessionID(r.Token),
+			Current:      r.Token == currentToken,
+			DateCreated:  localTime(r.DateCreated),
+			DateLastSeen: localTime(r.DateLastSeen),
+			IP:           r.Ip.String,
+			UserAgent:    r.UserAgent.String,
+		}
+	}
+
+	return sessions, nil
+}
+
+// Revoke deletes the session of the user with the ID, logging out the
+// device. Unknown sessions fail with ErrNoRecord.
+func (s SessionsStore) Revoke(ctx context.Context, userID, id string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.session.Revoke")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	rows, err := s.q.ListUserSessions(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "selecting sessions")
+	}
+
+	// the token is looked up by the ID, only the user knows the tokens
+	i := slices.IndexFunc(rows, func(r db.ListUserSessionsRow) bool {
+		return SessionID(r.Token) == id
+	})
+	if i < 0 {
+		return ErrNoRecord
 	}
+
+	n, err := s.q.DeleteUserSession(ctx, db.DeleteUserSessionParams{Token: rows[i].Token, UserID: userID})
+	if err != nil {
+		return errors.Wrap(err, "deleting session")
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// RevokeOthers deletes all sessions of the user but the one with the current
+// token and returns the number of sessions deleted.
+func (s SessionsStore) RevokeOthers(ctx context.Context, userID, currentToken string) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.session.RevokeOthers")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return 0, ErrInvalidID
+	}
+
+	n, err := s.q.DeleteOtherUserSessions(ctx, db.DeleteOtherUserSessionsParams{UserID: userID, Token: currentToken})
+	if err != nil {
+		return 0, errors.Wrap(err, "deleting sessions")
+	}
+
+	return int(n), nil
 }
@@ -2,6 +2,11 @@ package models
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
@@ -9,15 +14,20 @@ import (
 	"github.com/pkg/errors"
 	"github.com/tullo/snptx/internal/db"
 	"github.com/tullo/snptx/internal/platform/database"
+	"github.com/tullo/snptx/internal/platform/search"
 	"go.opencensus.io/trace"
 )
 
 type SnippetModelInterface interface {
 	Create(context.Context, NewSnippet, time.Time) (*Snippet, error)
 	Delete(context.Context, string) error
-	Latest(context.Context) ([]Snippet, error)
-	Update(context.Context, string, UpdateSnippet, time.Time) error
+	List(context.Context, SnippetFilter) (*SnippetPage, error)
+	Search(context.Context, SnippetSearch) ([]SearchResult, error)
+	SuggestTags(context.Context, string, string) ([]string, error)
+	Update(context.Context, string, UpdateSnippet, string, time.Time) error
 	Retrieve(context.Context, string) (*Snippet, error)
+	Revisions(context.Context, string) ([]Revision, error)
+	Revision(context.Context, string, string) (*Revision, error)
 }
 
 // Store manages the set of API's for snippet access. It wraps a pgxpool.Pool
@@ -35,27 +45,61 @@ func NewSnippetStore(d *database.DB) SnippetStore {
 	}
 }
 
-// Create inserts a new snippet record into the database.
+// Create inserts a new snippet record into the database and records its
+// content as the first revision. The tags must be normalized, see
+// NormalizeTags.
 func (s SnippetStore) Create(ctx context.Context, n NewSnippet, now time.Time) (*Snippet, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.snippet.Create")
 	defer span.End()
 
-	sn, err := s.q.CreateSnippet(ctx, db.GetCreateSnippetParams(
-		uuid.New().String(),
-		n.Title,
-		n.Content,
-		n.DateExpires,
-		now,
-		now,
-	))
+	var sn db.Snippet
+	err := s.withTx(ctx, func(q *db.Queries) error {
+		var err error
+		sn, err = q.CreateSnippet(ctx, db.GetCreateSnippetParams(
+			uuid.New().String(),
+			n.OwnerID,
+			n.Title,
+			n.Content,
+			n.Visibility,
+			n.Language,
+			n.DateExpires,
+			now,
+			now,
+		))
+		if err != nil {
+			return errors.Wrap(err, "inserting snippet")
+		}
+
+		if err := setTags(ctx, q, sn.SnippetID, n.Tags); err != nil {
+			return err
+		}
+
+		err = q.CreateSnippetRevision(ctx, db.GetCreateSnippetRevisionParams(
+			uuid.New().String(),
+			sn.SnippetID,
+			n.OwnerID,
+			n.Title,
+			n.Content,
+			now,
+		))
+		if err != nil {
+			return errors.Wrap(err, "inserting snippet revision")
+		}
+
+		return nil
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "inserting snippet")
+		return nil, err
 	}
 
 	spt := Snippet{
 		ID:          sn.SnippetID,
+		OwnerID:     sn.OwnerID,
 		Title:       sn.Title.String,
 		Content:     sn.Content.String,
+		Visibility:  sn.Visibility,
+		Language:    sn.Language,
+		Tags:        n.Tags,
 		DateExpires: sn.DateExpires.Time,
 		DateCreated: sn.DateCreated.Time,
 		DateUpdated: sn.DateUpdated.Time,
@@ -82,50 +126,98 @@ func (s SnippetStore) Retrieve(ctx context.Context, id string) (*Snippet, error)
 		return nil, errors.Wrapf(err, "selecting snippet %q", id)
 	}
 
+	tags, err := s.q.ListSnippetTags(ctx, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "selecting tags of snippet %q", id)
+	}
+
 	return &Snippet{
 		ID:          snip.SnippetID,
+		OwnerID:     snip.OwnerID,
 		Title:       snip.Title.String,
 		Content:     snip.Content.String,
+		Visibility:  snip.Visibility,
+		Language:    snip.Language,
+		Tags:        tags,
 		DateExpires: snip.DateExpires.Time.In(copenhagen),
 		DateCreated: snip.DateCreated.Time.In(copenhagen),
 		DateUpdated: snip.DateUpdated.Time.In(copenhagen),
 	}, nil
 }
 
-// Update updates a snippet record in the database.
-func (s SnippetStore) Update(ctx context.Context, id string, upd UpdateSnippet, up time.Time) error {
+// Update updates a snippet record in the database. Every update appends a
+// revision authored by the given user, previous revisions are never modified.
+// The snippet is read and written in one transaction, concurrent updates do
+// not lose each other's changes.
+func (s SnippetStore) Update(ctx context.Context, id string, upd UpdateSnippet, author string, up time.Time) error {
 	ctx, span := trace.StartSpan(ctx, "internal.snippet.Update")
 	defer span.End()
 
-	spt, err := s.Retrieve(ctx, id)
-	if err != nil {
-		return err
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
 	}
 
-	if upd.Title != nil {
-		spt.Title = *upd.Title
-	}
-	if upd.Content != nil {
-		spt.Content = *upd.Content
-	}
-	if upd.DateExpires != nil {
-		spt.DateExpires = *upd.DateExpires
-	}
+	return s.withTx(ctx, func(q *db.Queries) error {
+		snip, err := q.GetSnippetForUpdate(ctx, id)
+		if err != nil {
+			if pgxscan.NotFound(err) {
+				return ErrNoRecord
+			}
+			return errors.Wrapf(err, "selecting snippet %q", id)
+		}
 
-	spt.DateUpdated = up
+		title, content := snip.Title.String, snip.Content.String
+		visibility, language := snip.Visibility, snip.Language
+		expires := snip.DateExpires.Time
+		if upd.Title != nil {
+			title = *upd.Title
+		}
+		if upd.Content != nil {
+			content = *upd.Content
+		}
+		if upd.Visibility != nil {
+			visibility = *upd.Visibility
+		}
+		if upd.Language != nil {
+			language = *upd.Language
+		}
+		if upd.DateExpires != nil {
+			expires = *upd.DateExpires
+		}
 
-	err = s.q.UpdateSnippet(ctx, db.GetUpdateSnippetParams(
-		id,
-		spt.Title,
-		spt.Content,
-		spt.DateExpires,
-		spt.DateUpdated,
-	))
-	if err != nil {
-		return errors.Wrap(err, "updating snippet")
-	}
+		err = q.UpdateSnippet(ctx, db.GetUpdateSnippetParams(
+			id,
+			title,
+			content,
+			visibility,
+			language,
+			expires,
+			up,
+		))
+		if err != nil {
+			return errors.Wrap(err, "updating snippet")
+		}
 
-	return nil
+		if upd.Tags != nil {
+			if err := setTags(ctx, q, id, *upd.Tags); err != nil {
+				return err
+			}
+		}
+
+		err = q.CreateSnippetRevision(ctx, db.GetCreateSnippetRevisionParams(
+			uuid.New().String(),
+			id,
+			author,
+			title,
+			content,
+			up,
+		))
+		if err != nil {
+			return errors.Wrap(err, "inserting snippet revision")
+		}
+
+		return nil
+	})
 }
 
 // Delete removes a snippet record from the database.
@@ -145,27 +237,448 @@ func (s SnippetStore) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-// Latest gets the latest snippets from the database.
-func (s SnippetStore) Latest(ctx context.Context) ([]Snippet, error) {
-	ctx, span := trace.StartSpan(ctx, "internal.snippet.Latest")
+// DeleteExpired removes at most limit expired snippets, the oldest first,
+// together with their revisions and tags. It returns the number of snippets
+// removed.
+func (s SnippetStore) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.snippet.DeleteExpired")
 	defer span.End()
 
-	ss, err := s.q.ListLatestSnippets(ctx)
+	n, err := s.q.DeleteExpiredSnippets(ctx, int32(limit))
 	if err != nil {
+		return 0, errors.Wrap(err, "deleting expired snippets")
+	}
+
+	return int(n), nil
+}
+
+// snippetSorts maps the supported sort orders to the column used as
+// pagination key and its direction.
+var snippetSorts = map[string]struct {
+	column string
+	desc   bool
+}{
+	SortCreated: {"date_created", true},
+	SortUpdated: {"date_updated", true},
+	SortTitle:   {"title", false},
+	SortExpires: {"date_expires", false},
+}
+
+// defaultPageSize is the number of snippets listed when no limit is given.
+const defaultPageSize = 10
+
+// List gets a page of snippets matching the filter. Pages are selected with
+// keyset pagination: the cursor holds the sort key and ID of the row next to
+// the requested page, so that deep pages are as cheap as the first one.
+func (s SnippetStore) List(ctx context.Context, f SnippetFilter) (*SnippetPage, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.snippet.List")
+	defer span.End()
+
+	if f.Sort == "" {
+		f.Sort = SortCreated
+	}
+	sort, ok := snippetSorts[f.Sort]
+	if !ok {
+		return nil, errors.Errorf("unsupported sort order %q", f.Sort)
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultPageSize
+	}
+
+	var c cursor
+	if f.Cursor != "" {
+		var err error
+		if c, err = decodeCursor(f.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	own := f.All || (f.OwnerID != "" && f.OwnerID == f.ViewerID)
+	if !own {
+		where = append(where, "visibility = "+arg(VisibilityPublic))
+	}
+	if !own || !f.IncludeExpired {
+		where = append(where, "date_expires > NOW()")
+	}
+	if f.OwnerID != "" {
+		where = append(where, "owner_id = "+arg(f.OwnerID))
+	}
+	if f.Tag != "" {
+		where = append(where, "snippet_id IN (SELECT st.snippet_id FROM snippet_tags st JOIN tags t ON t.tag_id = st.tag_id WHERE t.name = "+arg(f.Tag)+")")
+	}
+	if !f.CreatedAfter.IsZero() {
+		where = append(where, "date_created >= "+arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		where = append(where, "date_created < "+arg(f.CreatedBefore))
+	}
+
+	// walking backwards flips both the comparison and the order,
+	// the rows are reversed again after the query
+	desc := sort.desc != c.Backward
+	if f.Cursor != "" {
+		key, err := c.key(sort.column)
+		if err != nil {
+			return nil, err
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		where = append(where, fmt.Sprintf("(%s, snippet_id) %s (%s, %s)", sort.column, op, arg(key), arg(c.ID)))
+	}
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+
+	q := "SELECT * FROM snippets"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += fmt.Sprintf(" ORDER BY %[1]s %[2]s, snippet_id %[2]s LIMIT %[3]d", sort.column, dir, f.Limit+1)
+
+	var rows []db.Snippet
+	if err := pgxscan.Select(ctx, s.db, &rows, q, args...); err != nil {
 		return nil, errors.Wrap(err, "selecting snippets")
 	}
 
-	is := make([]Snippet, len(ss))
-	for i, v := range ss {
-		is[i] = Snippet{
+	more := len(rows) > f.Limit
+	if more {
+		rows = rows[:f.Limit]
+	}
+	if c.Backward {
+		slices.Reverse(rows)
+	}
+
+	p := SnippetPage{Snippets: make([]Snippet, len(rows))}
+	for i, v := range rows {
+		p.Snippets[i] = Snippet{
 			ID:          v.SnippetID,
+			OwnerID:     v.OwnerID,
 			Title:       v.Title.String,
 			Content:     v.Content.String,
+			Visibility:  v.Visibility,
+			Language:    v.Language,
 			DateExpires: v.DateExpires.Time,
 			DateCreated: v.DateCreated.Time,
 			DateUpdated: v.DateUpdated.Time,
 		}
 	}
 
-	return is, nil
+	if len(rows) == 0 {
+		return &p, nil
+	}
+
+	if err := s.loadTags(ctx, p.Snippets); err != nil {
+		return nil, err
+	}
+
+	first, last := rows[0], rows[len(rows)-1]
+	switch {
+	case c.Backward:
+		// there is always a next page when walking backwards
+		p.Next = newCursor(sort.column, last, false)
+		if more {
+			p.Prev = newCursor(sort.column, first, true)
+		}
+	default:
+		if more {
+			p.Next = newCursor(sort.column, last, false)
+		}
+		if f.Cursor != "" {
+			p.Prev = newCursor(sort.column, first, true)
+		}
+	}
+
+	return &p, nil
+}
+
+// Search limits applied when no limit is given and to the excerpts.
+const (
+	defaultSearchResults = 20
+	excerptWidth         = 200
+)
+
+// Search gets the snippets matching the query, best matches first. Ranking
+// and matching are done by the database using the inverted index on title
+// and content, the excerpts are marked up afterwards.
+func (s SnippetStore) Search(ctx context.Context, ss SnippetSearch) ([]SearchResult, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.snippet.Search")
+	defer span.End()
+
+	q := search.Parse(ss.Query)
+	if len(q) == 0 {
+		return nil, nil
+	}
+	if ss.Limit <= 0 {
+		ss.Limit = defaultSearchResults
+	}
+
+	rows, err := s.q.SearchSnippets(ctx, db.SearchSnippetsParams{
+		Query:      q.TSQuery(),
+		ViewerID:   ss.ViewerID,
+		MaxResults: int32(ss.Limit),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "searching snippets for %q", ss.Query)
+	}
+
+	res := make([]SearchResult, len(rows))
+	for i, v := range rows {
+		res[i] = SearchResult{
+			Snippet: Snippet{
+				ID:          v.SnippetID,
+				OwnerID:     v.OwnerID,
+				Title:       v.Title.String,
+				Content:     v.Content.String,
+				Visibility:  v.Visibility,
+				Language:    v.Language,
+				DateExpires: v.DateExpires.Time.In(copenhagen),
+				DateCreated: v.DateCreated.Time.In(copenhagen),
+				DateUpdated: v.DateUpdated.Time.In(copenhagen),
+			},
+			Rank:     v.Rank,
+			Headline: q.Highlight(v.Title.String),
+			Excerpt:  q.Excerpt(v.Content.String, excerptWidth),
+		}
+	}
+
+	return res, nil
+}
+
+// Revisions gets the revisions of the specified snippet, newest first.
+func (s SnippetStore) Revisions(ctx context.Context, id string) ([]Revision, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.snippet.Revisions")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	rs, err := s.q.ListSnippetRevisions(ctx, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "selecting revisions of snippet %q", id)
+	}
+
+	revs := make([]Revision, len(rs))
+	for i, v := range rs {
+		revs[i] = Revision{
+			ID:          v.RevisionID,
+			SnippetID:   v.SnippetID,
+			Number:      len(rs) - i,
+			AuthorID:    v.AuthorID,
+			AuthorName:  v.AuthorName.String,
+			Title:       v.Title.String,
+			Content:     v.Content.String,
+			DateCreated: v.DateCreated.Time.In(copenhagen),
+		}
+	}
+
+	return revs, nil
+}
+
+// Revision gets the specified revision of a snippet.
+func (s SnippetStore) Revision(ctx context.Context, id, revisionID string) (*Revision, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.snippet.Revision")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(revisionID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	r, err := s.q.GetSnippetRevision(ctx, db.GetSnippetRevisionParams{
+		SnippetID:  id,
+		RevisionID: revisionID,
+	})
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, ErrNoRecord
+		}
+
+		return nil, errors.Wrapf(err, "selecting revision %q of snippet %q", revisionID, id)
+	}
+
+	return &Revision{
+		ID:          r.RevisionID,
+		SnippetID:   r.SnippetID,
+		AuthorID:    r.AuthorID,
+		AuthorName:  r.AuthorName.String,
+		Title:       r.Title.String,
+		Content:     r.Content.String,
+		DateCreated: r.DateCreated.Time.In(copenhagen),
+	}, nil
+}
+
+// maxTagSuggestions is the number of tags suggested for a prefix.
+const maxTagSuggestions = 10
+
+// SuggestTags gets the tags starting with prefix, most used first. Only tags
+// of snippets readable by the viewer are suggested.
+func (s SnippetStore) SuggestTags(ctx context.Context, viewerID, prefix string) ([]string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.snippet.SuggestTags")
+	defer span.End()
+
+	// escape the LIKE wildcards which are valid tag characters
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	pattern := r.Replace(NormalizeTag(prefix)) + "%"
+
+	tags, err := s.q.SuggestTags(ctx, db.SuggestTagsParams{
+		Pattern:    pattern,
+		ViewerID:   viewerID,
+		MaxResults: maxTagSuggestions,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "selecting tags starting with %q", prefix)
+	}
+
+	return tags, nil
+}
+
+// loadTags sets the tags of the snippets with a single query.
+func (s SnippetStore) loadTags(ctx context.Context, spts []Snippet) error {
+	ids := make([]string, len(spts))
+	for i, spt := range spts {
+		ids[i] = spt.ID
+	}
+
+	rows, err := s.q.ListTagsOfSnippets(ctx, ids)
+	if err != nil {
+		return errors.Wrap(err, "selecting tags of snippets")
+	}
+
+	tags := make(map[string][]string)
+	for _, r := range rows {
+		tags[r.SnippetID] = append(tags[r.SnippetID], r.Name)
+	}
+	for i := range spts {
+		spts[i].Tags = tags[spts[i].ID]
+	}
+
+	return nil
+}
+
+// setTags replaces the tags of a snippet, creating tags not used before.
+func setTags(ctx context.Context, q *db.Queries, snippetID string, tags []string) error {
+	if err := q.DeleteSnippetTags(ctx, snippetID); err != nil {
+		return errors.Wrap(err, "deleting snippet tags")
+	}
+
+	for _, name := range tags {
+		tagID, err := q.UpsertTag(ctx, db.UpsertTagParams{
+			TagID: uuid.New().String(),
+			Name:  name,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "inserting tag %q", name)
+		}
+
+		err = q.AddSnippetTag(ctx, db.AddSnippetTagParams{
+			SnippetID: snippetID,
+			TagID:     tagID,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "tagging snippet with %q", name)
+		}
+	}
+
+	return nil
+}
+
+// withTx runs fn in a database transaction. The transaction is rolled back
+// if fn returns an error.
+func (s SnippetStore) withTx(ctx context.Context, fn func(*db.Queries) error) error {
+	return runInTx(ctx, s.db, s.q, fn)
+}
+
+// runInTx runs fn with queries bound to a new transaction, which is committed
+// if fn succeeds and rolled back otherwise.
+func runInTx(ctx context.Context, d *database.DB, q *db.Queries, fn func(*db.Queries) error) error {
+	tx, err := d.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+
+	if err := fn(q.WithTx(tx)); err != nil {
+		if rerr := tx.Rollback(ctx); rerr != nil {
+			return errors.Wrapf(err, "rolling back transaction: %v", rerr)
+		}
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// cursor identifies the row next to a page of a snippet listing. It records
+// the sort column, a cursor is only valid for the sort order it was created
+// with.
+type cursor struct {
+	Column   string `json:"c"`
+	Key      string `json:"k"`
+	ID       string `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// newCursor constructs the encoded cursor pointing at row.
+func newCursor(column string, row db.Snippet, backward bool) string {
+	c := cursor{Column: column, ID: row.SnippetID, Backward: backward}
+	switch column {
+	case "title":
+		c.Key = row.Title.String
+	case "date_updated":
+		c.Key = row.DateUpdated.Time.UTC().Format(time.RFC3339Nano)
+	case "date_expires":
+		c.Key = row.DateExpires.Time.UTC().Format(time.RFC3339Nano)
+	default:
+		c.Key = row.DateCreated.Time.UTC().Format(time.RFC3339Nano)
+	}
+
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor created by newCursor.
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// key returns the sort key of the cursor typed for the given column. It fails
+// with ErrInvalidCursor if the cursor was created for another column.
+func (c cursor) key(column string) (any, error) {
+	if c.Column != column {
+		return nil, ErrInvalidCursor
+	}
+	if column == "title" {
+		return c.Key, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, c.Key)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return t, nil
 }
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tullo/snptx/internal/db"
+)
+
+func TestCursor(t *testing.T) {
+	row := db.Snippet{
+		SnippetID:   "8f0c1c52-3b6f-4a2e-9d0a-2f7d1e5b6c3a",
+		Title:       pgtype.Text{String: "An old silent pond", Valid: true},
+		DateCreated: pgtype.Timestamptz{Time: time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), Valid: true},
+	}
+
+	tests := []struct {
+		name    string
+		created string // column the cursor is created for
+		used    string // column the cursor is used with
+		want    any
+		wantErr error
+	}{
+		{"Title", "title", "title", "An old silent pond", nil},
+		{"Created", "date_created", "date_created", row.DateCreated.Time, nil},
+		{"Other Column", "title", "date_created", nil, ErrInvalidCursor},
+		{"Other Time Column", "date_created", "date_updated", nil, ErrInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := decodeCursor(newCursor(tt.created, row, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.ID != row.SnippetID {
+				t.Errorf("want ID %q; got %q", row.SnippetID, c.ID)
+			}
+
+			got, err := c.key(tt.used)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v; got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want key %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, s := range []string{"page2", "e30", "eyJpIjoiMSJ9"} {
+		if _, err := decodeCursor(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("want ErrInvalidCursor for %q; got %v", s, err)
+		}
+	}
+}
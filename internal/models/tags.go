@@ -0,0 +1,34 @@
+package models
+
+import (
+	"slices"
+	"strings"
+)
+
+// Limits on the tags of a snippet.
+const (
+	MaxTags      = 10
+	MaxTagLength = 32
+)
+
+// NormalizeTags splits a comma separated list of tags and normalizes each
+// tag: it is trimmed, lower cased and inner whitespace is replaced by '-'.
+// Empty and duplicate tags are dropped and the result is sorted. The result
+// is never nil, so it can be used to clear the tags of a snippet.
+func NormalizeTags(s string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(s, ",") {
+		t = NormalizeTag(t)
+		if t != "" && !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	slices.Sort(tags)
+
+	return tags
+}
+
+// NormalizeTag normalizes a single tag as described for NormalizeTags.
+func NormalizeTag(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), "-")
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags string
+		want []string
+	}{
+		{"Empty", "", []string{}},
+		{"Blank", " , ,", []string{}},
+		{"Lower Case", "SQL,K8s", []string{"k8s", "sql"}},
+		{"Trimmed", "  sql ,\tk8s\n", []string{"k8s", "sql"}},
+		{"Inner Whitespace", "getting  started", []string{"getting-started"}},
+		{"Duplicates", "sql, SQL ,k8s", []string{"k8s", "sql"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NormalizeTags(tt.tags)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tullo/snptx/internal/db"
+	"github.com/tullo/snptx/internal/platform/database"
+	"github.com/tullo/snptx/internal/platform/sec"
+	"github.com/tullo/snptx/internal/platform/totp"
+	"go.opencensus.io/trace"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated at once.
+const RecoveryCodeCount = 10
+
+type TwoFactorModelInterface interface {
+	Disable(context.Context, string) error
+	Enable(context.Context, string, string, time.Time) ([]string, error)
+	Enroll(context.Context, string, time.Time) ([]byte, error)
+	RegenerateRecoveryCodes(context.Context, string, time.Time) ([]string, error)
+	Secret(context.Context, string) ([]byte, error)
+	Status(context.Context, string) (*TwoFactor, error)
+	Verify(context.Context, string, string, time.Time) error
+}
+
+// TwoFactorStore manages the TOTP secrets and recovery codes of the users.
+// The secrets are encrypted, only the SHA-256 hashes of the recovery codes
+// are stored.
+type TwoFactorStore struct {
+	db *database.DB
+	c  *sec.Cipher
+	q  *db.Queries
+}
+
+// NewTwoFactorStore constructs a TwoFactorStore for api access. The cipher
+// is needed to enroll users and verify their codes only.
+func NewTwoFactorStore(d *database.DB, c *sec.Cipher) TwoFactorStore {
+	return TwoFactorStore{
+		db: d,
+		c:  c,
+		q:  db.New(d),
+	}
+}
+
+// Status gets the state of the two-factor authentication of the user.
+func (s TwoFactorStore) Status(ctx context.Context, userID string) (*TwoFactor, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.twoFactor.Status")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	t, err := s.q.GetTOTP(ctx, userID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return &TwoFactor{}, nil
+		}
+		return nil, fmt.Errorf("selecting TOTP of user %q: [%w]", userID, err)
+	}
+	if !t.DateEnabled.Valid {
+		return &TwoFactor{Pending: true}, nil
+	}
+
+	n, err := s.q.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("counting recovery codes of user %q: [%w]", userID, err)
+	}
+
+	return &TwoFactor{
+		Enabled:       true,
+		DateEnabled:   localTime(t.DateEnabled),
+		RecoveryCodes: int(n),
+	}, nil
+}
+
+// Enroll returns the TOTP secret the user has to add to their authenticator
+// app. The secret is generated on the first call and returned again until
+// the enrollment is completed by Enable.
+func (s TwoFactorStore) Enroll(ctx context.Context, userID string, now time.Time) ([]byte, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.twoFactor.Enroll")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	t, err := s.q.GetTOTP(ctx, userID)
+	switch {
+	case err == nil && t.DateEnabled.Valid:
+		return nil, ErrTwoFactorEnabled
+	case err == nil:
+		return s.open(t.Secret)
+	case !pgxscan.NotFound(err):
+		return nil, fmt.Errorf("selecting TOTP of user %q: [%w]", userID, err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.c.Seal(secret)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting TOTP secret: [%w]", err)
+	}
+
+	err = s.q.CreateTOTP(ctx, db.CreateTOTPParams{
+		UserID:      userID,
+		Secret:      sealed,
+		DateCreated: pgtype.Timestamptz{Time: now, Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("inserting TOTP of user %q: [%w]", userID, err)
+	}
+
+	return secret, nil
+}
+
+// Secret returns the TOTP secret of the pending enrollment of the user. It
+// fails with ErrNoRecord if the user has not enrolled, and with
+// ErrTwoFactorEnabled once the enrollment is completed.
+func (s TwoFactorStore) Secret(ctx context.Context, userID string) ([]byte, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.twoFactor.Secret")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	t, err := s.q.GetTOTP(ctx, userID)
+	switch {
+	case pgxscan.NotFound(err):
+		return nil, ErrNoRecord
+	case err != nil:
+		return nil, fmt.Errorf("selecting TOTP of user %q: [%w]", userID, err)
+	case t.DateEnabled.Valid:
+		return nil, ErrTwoFactorEnabled
+	}
+
+	return s.open(t.Secret)
+}
+
+// Enrolled returns the number of users holding a TOTP secret, whether their
+// enrollment is completed or not.
+func (s TwoFactorStore) Enrolled(ctx context.Context) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.twoFactor.Enrolled")
+	defer span.End()
+
+	n, err := s.q.CountTOTP(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("counting TOTP secrets: [%w]", err)
+	}
+
+	return int(n), nil
+}
+
+// Enable completes the enrollment if the code matches the secret returned by
+// Enroll. It returns the recovery codes, which cannot be retrieved later.
+func (s TwoFactorStore) Enable(ctx context.Context, userID, code string, now time.Time) ([]string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.twoFactor.Enable")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	t, err := s.q.GetTOTP(ctx, userID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("selecting TOTP of user %q: [%w]", userID, err)
+	}
+	if t.DateEnabled.Valid {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := s.open(t.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, normalizeCode(code), now)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	var codes []string
+	err = runInTx(ctx, s.db, s.q, func(q *db.Queries) error {
+		n, err := q.EnableTOTP(ctx, db.EnableTOTPParams{
+			Now:    pgtype.Timestamptz{Time: now, Valid: true},
+			Step:   step,
+			UserID: userID,
+		})
+		if err != nil {
+			return fmt.Errorf("enabling TOTP of user %q: [%w]", userID, err)
+		}
+		if n == 0 {
+			// enabled concurrently
+			return ErrTwoFactorEnabled
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, q, userID, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Verify checks a code of the authenticator app or a recovery code of the
+// user. Each code is accepted once only.
+func (s TwoFactorStore) Verify(ctx context.Context, userID, code string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.twoFactor.Verify")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	code = normalizeCode(code)
+	if len(code) != totp.Digits {
+		n, err := s.q.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{UserID: userID, CodeHash: hashToken(code)})
+		if err != nil {
+			return fmt.Errorf("deleting recovery code of user %q: [%w]", userID, err)
+		}
+		if n == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	t, err := s.q.GetTOTP(ctx, userID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return ErrInvalidCode
+		}
+		return fmt.Errorf("selecting TOTP of user %q: [%w]", userID, err)
+	}
+	if !t.DateEnabled.Valid {
+		return ErrInvalidCode
+	}
+
+	secret, err := s.open(t.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, code, now)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	// a code seen by someone looking over the shoulder must not work again
+	n, err := s.q.UseTOTPStep(ctx, db.UseTOTPStepParams{Step: step, UserID: userID})
+	if err != nil {
+		return fmt.Errorf("recording TOTP step of user %q: [%w]", userID, err)
+	}
+	if n == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user.
+func (s TwoFactorStore) RegenerateRecoveryCodes(ctx context.Context, userID string, now time.Time) ([]string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.twoFactor.RegenerateRecoveryCodes")
+	defer span.End()
+
+	tf, err := s.Status(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !tf.Enabled {
+		return nil, ErrNoRecord
+	}
+
+	var codes []string
+	err = runInTx(ctx, s.db, s.q, func(q *db.Queries) error {
+		codes, err = replaceRecoveryCodes(ctx, q, userID, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable removes the TOTP secret and the recovery codes of the user, e.g.
+// when they have lost both. It fails with ErrNoRecord if the user has not
+// enrolled.
+func (s TwoFactorStore) Disable(ctx context.Context, userID string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.twoFactor.Disable")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	return runInTx(ctx, s.db, s.q, func(q *db.Queries) error {
+		n, err := q.DeleteTOTP(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("deleting TOTP of user %q: [%w]", userID, err)
+		}
+		if n == 0 {
+			return ErrNoRecord
+		}
+		if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+			return fmt.Errorf("deleting recovery codes of user %q: [%w]", userID, err)
+		}
+		return nil
+	})
+}
+
+func (s TwoFactorStore) open(sealed []byte) ([]byte, error) {
+	secret, err := s.c.Open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting TOTP secret: [%w]", err)
+	}
+	return secret, nil
+}
+
+// replaceRecoveryCodes generates new recovery codes for the user, replacing
+// the unused ones. The codes are formatted in groups of four characters,
+// e.g. ABCD-EFGH-IJKL-MNOP.
+func replaceRecoveryCodes(ctx context.Context, q *db.Queries, userID string, now time.Time) ([]string, error) {
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, fmt.Errorf("deleting recovery codes of user %q: [%w]", userID, err)
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generating recovery code: [%w]", err)
+		}
+		code := base32.StdEncoding.EncodeToString(b)
+
+		err := q.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{
+			UserID:      userID,
+			CodeHash:    hashToken(code),
+			DateCreated: pgtype.Timestamptz{Time: now, Valid: true},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("inserting recovery code: [%w]", err)
+		}
+
+		codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+	}
+
+	return codes, nil
+}
+
+// normalizeCode removes the separators users may type or copy along with a
+// code and ignores the case of recovery codes.
+func normalizeCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
@@ -0,0 +1,24 @@
+package models
+
+import "testing"
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{"TOTP", "123456", "123456"},
+		{"TOTP With Space", " 123 456 ", "123456"},
+		{"Recovery Code", "ABCD-EFGH-IJKL-MNOP", "ABCDEFGHIJKLMNOP"},
+		{"Lower Case Recovery Code", "abcd-efgh ijkl-mnop", "ABCDEFGHIJKLMNOP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeCode(tt.code); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
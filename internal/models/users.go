@@ -10,9 +10,11 @@ import (
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/tullo/snptx/internal/db"
 	"github.com/tullo/snptx/internal/platform/auth"
 	"github.com/tullo/snptx/internal/platform/database"
+	"github.com/tullo/snptx/internal/platform/sec"
 	"go.opencensus.io/trace"
 )
 
@@ -20,8 +22,15 @@ type UserModelInterface interface {
 	Authenticate(context.Context, time.Time, string, string) (auth.Claims, error)
 	Create(context.Context, NewUser, time.Time) (*User, error)
 	ChangePassword(context.Context, string, string, string) error
+	CheckPassword(context.Context, string, string) error
+	Delete(context.Context, string, string) error
 	Exists(ctx context.Context, id string) (bool, error)
+	List(context.Context) ([]User, error)
+	QueryByEmail(context.Context, string) (*User, error)
 	QueryByID(context.Context, string) (*User, error)
+	RecordVerificationSent(context.Context, string, time.Time, time.Duration) error
+	Update(context.Context, string, UpdateUser, time.Time) error
+	VerifyEmail(context.Context, string, string, time.Time) error
 }
 
 // Store manages the set of API's for user access. It wraps a pgxpool.Pool and
@@ -30,15 +39,23 @@ type UserStore struct {
 	db *database.DB
 	hp *argon2id.Params
 	q  *db.Queries
+	// dummyHash is compared against the password of unknown emails, so
+	// Authenticate takes as long as for known ones.
+	dummyHash string
 }
 
 // NewStore constructs a Store for api access.
 func NewUserStore(d *database.DB, hp *argon2id.Params) UserStore {
-	return UserStore{
+	s := UserStore{
 		db: d,
 		hp: hp,
 		q:  db.New(d),
 	}
+	if hp != nil {
+		// without a dummy hash unknown emails are only rejected faster
+		s.dummyHash, _ = argon2id.CreateHash("dummy password", hp)
+	}
+	return s
 }
 
 // List retrieves a list of existing users from the database.
@@ -61,6 +78,8 @@ func (s UserStore) List(ctx context.Context) ([]User, error) {
 			Roles:          v.Roles,
 			DateCreated:    v.DateCreated.Time,
 			DateUpdated:    v.DateUpdated.Time,
+			Verified:       v.DateVerified.Valid,
+			SSOOnly:        !v.PasswordHash.Valid,
 		}
 	}
 
@@ -72,9 +91,14 @@ func (s UserStore) Create(ctx context.Context, n NewUser, now time.Time) (*User,
 	ctx, span := trace.StartSpan(ctx, "internal.user.Create")
 	defer span.End()
 
-	hash, err := argon2id.CreateHash(n.Password, s.hp)
-	if err != nil {
-		return nil, fmt.Errorf("generating password hash: [%w]", err)
+	// an account provisioned by single sign-on has no password
+	var hash string
+	if n.Password != "" {
+		var err error
+		hash, err = argon2id.CreateHash(n.Password, s.hp)
+		if err != nil {
+			return nil, fmt.Errorf("generating password hash: [%w]", err)
+		}
 	}
 
 	u, err := s.q.CreateUser(ctx, db.GetCreateUserParams(
@@ -108,6 +132,8 @@ func (s UserStore) Create(ctx context.Context, n NewUser, now time.Time) (*User,
 		Roles:          u.Roles,
 		DateCreated:    u.DateCreated.Time,
 		DateUpdated:    u.DateUpdated.Time,
+		Verified:       u.DateVerified.Valid,
+		SSOOnly:        !u.PasswordHash.Valid,
 	}, nil
 }
 
@@ -138,73 +164,162 @@ func (s UserStore) QueryByID(ctx context.Context, id string) (*User, error) {
 		Roles:          u.Roles,
 		DateCreated:    u.DateCreated.Time,
 		DateUpdated:    u.DateUpdated.Time,
+		Verified:       u.DateVerified.Valid,
+		SSOOnly:        !u.PasswordHash.Valid,
 	}, nil
 }
 
-// Update replaces a user document in the database.
-func (s UserStore) Update(ctx context.Context, id string, upd UpdateUser, now time.Time) error {
-	ctx, span := trace.StartSpan(ctx, "internal.user.Update")
+// QueryByEmail gets the user with the email from the database.
+func (s UserStore) QueryByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.QueryByEmail")
 	defer span.End()
 
-	usr, err := s.QueryByID(ctx, id)
+	u, err := s.q.GetUserByEmail(ctx, db.AsText(email))
 	if err != nil {
-		return err
-	}
+		if pgxscan.NotFound(err) {
+			return nil, ErrNoRecord
+		}
 
-	if upd.Name != nil {
-		usr.Name = *upd.Name
-	}
-	if upd.Email != nil {
-		usr.Email = *upd.Email
+		return nil, fmt.Errorf("selecting user %q: [%w]", email, err)
 	}
-	if upd.Roles != nil {
-		usr.Roles = upd.Roles
+
+	return &User{
+		ID:             u.UserID,
+		Name:           u.Name.String,
+		Email:          u.Email.String,
+		Active:         u.Active.Bool,
+		HashedPassword: u.PasswordHash.String,
+		Roles:          u.Roles,
+		DateCreated:    u.DateCreated.Time,
+		DateUpdated:    u.DateUpdated.Time,
+		Verified:       u.DateVerified.Valid,
+		SSOOnly:        !u.PasswordHash.Valid,
+	}, nil
+}
+
+// Update replaces a user document in the database. Deactivating a user
+// deletes all of their sessions, a changed email address has to be verified
+// again. The user is read and written in one transaction, concurrent updates
+// do not lose each other's changes.
+func (s UserStore) Update(ctx context.Context, id string, upd UpdateUser, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.user.Update")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
 	}
+
+	// hashing takes a while, the row is not locked meanwhile
+	var hash string
 	if upd.Password != nil {
-		hash, err := argon2id.CreateHash(*upd.Password, s.hp)
+		var err error
+		hash, err = argon2id.CreateHash(*upd.Password, s.hp)
 		if err != nil {
 			return fmt.Errorf("generating password hash: [%w]", err)
 		}
-		usr.HashedPassword = hash
 	}
 
-	usr.DateUpdated = now
+	return runInTx(ctx, s.db, s.q, func(q *db.Queries) error {
+		u, err := q.GetUserForUpdate(ctx, id)
+		if err != nil {
+			if pgxscan.NotFound(err) {
+				return ErrNoRecord
+			}
+			return fmt.Errorf("selecting user %q: [%w]", id, err)
+		}
 
-	err = s.q.UpdateUser(ctx, db.GetUpdateUserParams(
-		id,
-		usr.Name,
-		usr.Email,
-		usr.Roles,
-		usr.HashedPassword,
-		usr.DateUpdated,
-	))
-	if err != nil {
-		return fmt.Errorf("updating user: [%w]", err)
-	}
+		name, email, active, roles := u.Name.String, u.Email.String, u.Active.Bool, u.Roles
+		if upd.Name != nil {
+			name = *upd.Name
+		}
+		if upd.Email != nil {
+			email = *upd.Email
+		}
+		if upd.Active != nil {
+			active = *upd.Active
+		}
+		if upd.Roles != nil {
+			roles = upd.Roles
+		}
+		if upd.Password == nil {
+			hash = u.PasswordHash.String
+		}
 
-	return nil
+		err = q.UpdateUser(ctx, db.GetUpdateUserParams(
+			id,
+			name,
+			email,
+			active,
+			roles,
+			hash,
+			now,
+		))
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+				// violates unique constraint "users_email_key"
+				return ErrDuplicateEmail
+			}
+			return fmt.Errorf("updating user: [%w]", err)
+		}
+
+		if !active {
+			if _, err := q.DeleteUserSessions(ctx, id); err != nil {
+				return fmt.Errorf("deleting sessions of user %q: [%w]", id, err)
+			}
+		}
+
+		return nil
+	})
 }
 
-// Delete removes a user from the database.
-func (s UserStore) Delete(ctx context.Context, id string) error {
+// Delete removes a user from the database together with their sessions. The
+// snippets of the user are handed over to the successor, or deleted if
+// successorID is empty. Revisions the user made of the snippets of others are
+// attributed to the owners of the snippets.
+func (s UserStore) Delete(ctx context.Context, id, successorID string) error {
 	ctx, span := trace.StartSpan(ctx, "internal.user.Delete")
 	defer span.End()
 
 	if _, err := uuid.Parse(id); err != nil {
 		return ErrInvalidID
 	}
-
-	err := s.q.DeleteUser(ctx, id)
-	if err != nil {
-		return fmt.Errorf("deleting user %s: [%w]", id, err)
+	if successorID != "" {
+		if _, err := uuid.Parse(successorID); err != nil || successorID == id {
+			return ErrInvalidID
+		}
 	}
 
-	return nil
+	return runInTx(ctx, s.db, s.q, func(q *db.Queries) error {
+		if successorID != "" {
+			_, err := q.ReassignUserSnippets(ctx, db.ReassignUserSnippetsParams{SuccessorID: successorID, UserID: id})
+			if err != nil {
+				return fmt.Errorf("reassigning snippets of user %q: [%w]", id, err)
+			}
+		} else {
+			if _, err := q.DeleteUserSnippets(ctx, id); err != nil {
+				return fmt.Errorf("deleting snippets of user %q: [%w]", id, err)
+			}
+		}
+
+		if _, err := q.ReassignUserRevisions(ctx, id); err != nil {
+			return fmt.Errorf("reassigning revisions of user %q: [%w]", id, err)
+		}
+		if _, err := q.DeleteUserSessions(ctx, id); err != nil {
+			return fmt.Errorf("deleting sessions of user %q: [%w]", id, err)
+		}
+		if err := q.DeleteUser(ctx, id); err != nil {
+			return fmt.Errorf("deleting user %s: [%w]", id, err)
+		}
+
+		return nil
+	})
 }
 
 // Authenticate finds a user by their email and verifies their password. On
 // success it returns a Claims value representing this user. The claims can be
-// used to generate a token for future authentication.
+// used to generate a token for future authentication. A password hashed with
+// outdated parameters is hashed again with the configured ones.
 func (s UserStore) Authenticate(ctx context.Context, now time.Time, email, password string) (auth.Claims, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.user.Authenticate")
 	defer span.End()
@@ -214,13 +329,24 @@ func (s UserStore) Authenticate(ctx context.Context, now time.Time, email, passw
 		// Normally we would return ErrNotFound in this scenario but we do not want
 		// to leak to an unauthenticated user which emails are in the system.
 		if pgxscan.NotFound(err) {
+			if s.dummyHash != "" {
+				argon2id.ComparePasswordAndHash(password, s.dummyHash)
+			}
 			return auth.Claims{}, ErrAuthenticationFailure
 		}
 
 		return auth.Claims{}, fmt.Errorf("selecting single user: [%w]", err)
 	}
+	if !u.PasswordHash.Valid {
+		// the account was provisioned by single sign-on
+		if s.dummyHash != "" {
+			argon2id.ComparePasswordAndHash(password, s.dummyHash)
+		}
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
 	usr := User{
 		ID:             u.UserID,
+		Active:         u.Active.Bool,
 		HashedPassword: u.PasswordHash.String,
 		Roles:          u.Roles,
 	}
@@ -231,25 +357,75 @@ func (s UserStore) Authenticate(ctx context.Context, now time.Time, email, passw
 		return auth.Claims{}, ErrAuthenticationFailure
 	}
 
+	// Only reveal the deactivation to someone knowing the password, so the
+	// error cannot be used to find out which emails are in the system.
+	if !usr.Active {
+		return auth.Claims{}, ErrInactiveUser
+	}
+
+	s.rehash(ctx, usr.ID, password, usr.HashedPassword)
+
 	// If we are this far the request is valid. Create some claims for the user
 	// and generate their token.
 	claims := auth.NewClaims(usr.ID, usr.Roles, now, time.Hour)
 	return claims, nil
 }
 
-// ChangePassword generates a hash based on the new password and saves it to the db.
-func (s UserStore) ChangePassword(ctx context.Context, id string, currentPassword, newPassword string) error {
+// rehash hashes the password again if its hash was created with parameters
+// other than the configured ones. A failure does not fail the login, the
+// password is hashed again on the next one.
+func (s UserStore) rehash(ctx context.Context, id, password, hash string) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.rehash")
+	defer span.End()
+
+	if s.hp == nil {
+		return
+	}
+	outdated, err := sec.Outdated(hash, s.hp)
+	if err != nil || !outdated {
+		return
+	}
+
+	newHash, err := argon2id.CreateHash(password, s.hp)
+	if err == nil {
+		// a password changed in the meantime is kept
+		_, err = s.q.RehashPassword(ctx, db.RehashPasswordParams{
+			NewHash: db.AsText(newHash),
+			UserID:  id,
+			OldHash: db.AsText(hash),
+		})
+	}
+	if err != nil {
+		span.Annotate([]trace.Attribute{trace.StringAttribute("error", err.Error())}, "rehashing password failed")
+	}
+}
+
+// CheckPassword compares the password with the saved hash of the user. It
+// fails with ErrInvalidCredentials if they do not match, and with
+// ErrNoPassword for an account provisioned by single sign-on.
+func (s UserStore) CheckPassword(ctx context.Context, id, password string) error {
 	usr, err := s.QueryByID(ctx, id)
 	if err != nil {
 		return err
 	}
+	if usr.SSOOnly {
+		return ErrNoPassword
+	}
 
-	// compare the provided password with the saved hash
-	if match, err := argon2id.ComparePasswordAndHash(currentPassword, usr.HashedPassword); err != nil || !match {
-		if !match {
-			return ErrInvalidCredentials
-		}
+	match, err := argon2id.ComparePasswordAndHash(password, usr.HashedPassword)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return ErrInvalidCredentials
+	}
 
+	return nil
+}
+
+// ChangePassword generates a hash based on the new password and saves it to the db.
+func (s UserStore) ChangePassword(ctx context.Context, id string, currentPassword, newPassword string) error {
+	if err := s.CheckPassword(ctx, id, currentPassword); err != nil {
 		return err
 	}
 
@@ -268,6 +444,57 @@ func (s UserStore) ChangePassword(ctx context.Context, id string, currentPasswor
 	return nil
 }
 
+// VerifyEmail marks the email of the user as verified. It fails with
+// ErrInvalidToken if the user has changed their email in the meantime.
+func (s UserStore) VerifyEmail(ctx context.Context, id, email string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.user.VerifyEmail")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	n, err := s.q.VerifyUserEmail(ctx, db.VerifyUserEmailParams{
+		Now:    pgtype.Timestamptz{Time: now, Valid: true},
+		UserID: id,
+		Email:  db.AsText(email),
+	})
+	if err != nil {
+		return fmt.Errorf("verifying email of user %q: [%w]", id, err)
+	}
+	if n == 0 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// RecordVerificationSent records that a verification link is sent to the
+// user. It fails with ErrRateLimited if the last link was sent less than
+// interval ago or the email is verified already.
+func (s UserStore) RecordVerificationSent(ctx context.Context, id string, now time.Time, interval time.Duration) error {
+	ctx, span := trace.StartSpan(ctx, "internal.user.RecordVerificationSent")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	n, err := s.q.RecordVerificationSent(ctx, db.RecordVerificationSentParams{
+		Now:        pgtype.Timestamptz{Time: now, Valid: true},
+		UserID:     id,
+		SentBefore: pgtype.Timestamptz{Time: now.Add(-interval), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("recording verification of user %q: [%w]", id, err)
+	}
+	if n == 0 {
+		return ErrRateLimited
+	}
+
+	return nil
+}
+
 func (s UserStore) Exists(ctx context.Context, id string) (bool, error) {
 	return s.q.UserExists(ctx, id)
 }
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/tullo/snptx/internal/platform/auth"
+	"github.com/tullo/snptx/internal/platform/sec"
+	"github.com/tullo/snptx/internal/tests"
+)
+
+func TestUserRehash(t *testing.T) {
+	if _, ok := os.LookupEnv("DATABASE_URL"); !ok {
+		t.Skip("DATABASE_URL not defined")
+	}
+	db, teardown := tests.NewUnit(t, t.Context())
+	defer teardown()
+
+	old := &argon2id.Params{Memory: 16 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hp := &argon2id.Params{Memory: 32 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	now := time.Now()
+
+	// the users were created while the old parameters were configured
+	create := func(email string) *User {
+		t.Helper()
+		nu := NewUser{Name: "Gopher", Email: email, Roles: []string{auth.RoleUser}, Password: "gophers"}
+		usr, err := NewUserStore(db, old).Create(t.Context(), nu, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return usr
+	}
+	s := NewUserStore(db, hp)
+
+	t.Run("Outdated", func(t *testing.T) {
+		usr := create("outdated@example.com")
+
+		if _, err := s.Authenticate(t.Context(), now, usr.Email, "gophers"); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.QueryByID(t.Context(), usr.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if outdated, err := sec.Outdated(got.HashedPassword, hp); err != nil || outdated {
+			t.Errorf("want hash with %s; got %q", sec.FormatParams(hp), got.HashedPassword)
+		}
+		if match, err := argon2id.ComparePasswordAndHash("gophers", got.HashedPassword); err != nil || !match {
+			t.Error("want password to match the new hash")
+		}
+	})
+
+	t.Run("Changed In The Meantime", func(t *testing.T) {
+		usr := create("changed@example.com")
+
+		// the password changes between reading and replacing the hash
+		if err := NewUserStore(db, old).ChangePassword(t.Context(), usr.ID, "gophers", "gophers again"); err != nil {
+			t.Fatal(err)
+		}
+		changed, err := s.QueryByID(t.Context(), usr.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.rehash(t.Context(), usr.ID, "gophers", usr.HashedPassword)
+
+		got, err := s.QueryByID(t.Context(), usr.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.HashedPassword != changed.HashedPassword {
+			t.Errorf("want the changed hash kept; got %q", got.HashedPassword)
+		}
+	})
+}
+
+func TestUserUpdate(t *testing.T) {
+	if _, ok := os.LookupEnv("DATABASE_URL"); !ok {
+		t.Skip("DATABASE_URL not defined")
+	}
+	db, teardown := tests.NewUnit(t, t.Context())
+	defer teardown()
+
+	hp := &argon2id.Params{Memory: 16 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	s := NewUserStore(db, hp)
+	now := time.Now()
+
+	nu := NewUser{Name: "Gopher", Email: "gopher@example.com", Roles: []string{auth.RoleUser}, Password: "gophers"}
+	usr, err := s.Create(t.Context(), nu, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the password changes after an admin loaded the user to deactivate it
+	if err := s.ChangePassword(t.Context(), usr.ID, "gophers", "gophers again"); err != nil {
+		t.Fatal(err)
+	}
+	active := false
+	if err := s.Update(t.Context(), usr.ID, UpdateUser{Active: &active}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.QueryByID(t.Context(), usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Active {
+		t.Error("want the user deactivated")
+	}
+	if match, err := argon2id.ComparePasswordAndHash("gophers again", got.HashedPassword); err != nil || !match {
+		t.Error("want the changed password kept")
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"slices"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/pkg/errors"
+)
+
+// KeyLookupFunc is used to map a JWT key id (kid) to the corresponding public key.
+// It is a requirement for creating an Authenticator.
+//
+// * Private keys should be rotated. During the transition period, tokens
+// signed with the old and new keys can coexist by looking up the correct
+// public key by key id (kid).
+//
+// * Key-id-to-public-key resolution is usually accomplished via a public JWKS
+// endpoint. See https://auth0.com/docs/jwks for more details.
+type KeyLookupFunc func(kid string) (*rsa.PublicKey, error)
+
+// Algorithms are the signing algorithms supported with the RSA keys of a
+// KeyStore.
+var Algorithms = []string{"RS256", "RS384", "RS512"}
+
+// Authenticator is used to authenticate clients. It can generate a token for a
+// set of user claims and recreate the claims by parsing the token.
+type Authenticator struct {
+	privateKey       *rsa.PrivateKey
+	activeKID        string
+	algorithm        string
+	pubKeyLookupFunc KeyLookupFunc
+	parser           *jwt.Parser
+}
+
+// New creates an *Authenticator for use. It will error if:
+// - The private key is nil.
+// - The public key func is nil.
+// - The key ID is blank.
+// - The specified algorithm is not one of Algorithms.
+func New(privateKey *rsa.PrivateKey, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
+	if privateKey == nil {
+		return nil, errors.New("private key cannot be nil")
+	}
+	if activeKID == "" {
+		return nil, errors.New("active kid cannot be blank")
+	}
+	if !slices.Contains(Algorithms, algorithm) {
+		return nil, errors.Errorf("unsupported algorithm %v, the keys are RSA keys signing with one of %v", algorithm, Algorithms)
+	}
+	if publicKeyLookupFunc == nil {
+		return nil, errors.New("public key function cannot be nil")
+	}
+
+	// Create the token parser to use. The algorithm used to sign the JWT must be
+	// validated to avoid a critical vulnerability:
+	// https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{algorithm}))
+
+	a := Authenticator{
+		privateKey:       privateKey,
+		activeKID:        activeKID,
+		algorithm:        algorithm,
+		pubKeyLookupFunc: publicKeyLookupFunc,
+		parser:           parser,
+	}
+
+	return &a, nil
+}
+
+// GenerateToken generates a signed JWT token string representing the user Claims.
+// The token header names the key ID of the signing key.
+func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
+	method := jwt.GetSigningMethod(a.algorithm)
+
+	tkn := jwt.NewWithClaims(method, claims)
+	tkn.Header["kid"] = a.activeKID
+
+	str, err := tkn.SignedString(a.privateKey)
+	if err != nil {
+		return "", errors.Wrap(err, "signing token")
+	}
+
+	return str, nil
+}
+
+// ParseClaims recreates the Claims that were used to generate a token. It
+// verifies that the token was signed using our key.
+func (a *Authenticator) ParseClaims(tknStr string) (Claims, error) {
+
+	// keyFunc is a function that returns the public key for validating a token.
+	// We use the parsed (but unverified) token to find the key id. That ID is
+	// passed to our KeyFunc to find the public key to use for verification.
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"]
+		if !ok {
+			return nil, errors.New("missing key id (kid) in token header")
+		}
+		kidID, ok := kid.(string)
+		if !ok {
+			return nil, errors.New("user token key id (kid) must be string")
+		}
+
+		return a.pubKeyLookupFunc(kidID)
+	}
+
+	var claims Claims
+	tkn, err := a.parser.ParseWithClaims(tknStr, &claims, keyFunc)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "parsing token")
+	}
+
+	if !tkn.Valid {
+		return Claims{}, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
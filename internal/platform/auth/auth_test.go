@@ -0,0 +1,181 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tullo/snptx/internal/platform/auth"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAuthenticator(t *testing.T) {
+	ks := auth.NewKeyStore(map[string]*rsa.PrivateKey{
+		"old": generateKey(t),
+		"new": generateKey(t),
+	})
+
+	newAuthenticator := func(kid string) *auth.Authenticator {
+		key, err := ks.PrivateKey(kid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := auth.New(key, kid, "RS256", ks.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	old := newAuthenticator("old")
+	a := newAuthenticator("new")
+
+	claims := auth.NewClaims("1", []string{auth.RoleAdmin}, time.Now(), time.Minute)
+
+	t.Run("Round Trip", func(t *testing.T) {
+		tkn, err := a.GenerateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := a.ParseClaims(tkn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Subject != "1" || !parsed.HasRole(auth.RoleAdmin) {
+			t.Errorf("want subject %q with role %q; got %+v", "1", auth.RoleAdmin, parsed)
+		}
+	})
+
+	t.Run("Rotated Key", func(t *testing.T) {
+		tkn, err := old.GenerateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.ParseClaims(tkn); err != nil {
+			t.Errorf("want token signed with the old key to be valid; got %v", err)
+		}
+	})
+
+	t.Run("Unknown Key", func(t *testing.T) {
+		key := generateKey(t)
+		other, err := auth.New(key, "other", "RS256", func(string) (*rsa.PublicKey, error) {
+			return &key.PublicKey, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tkn, err := other.GenerateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.ParseClaims(tkn); err == nil {
+			t.Error("want token signed with an unknown key to be rejected")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := auth.NewClaims("1", []string{auth.RoleUser}, time.Now().Add(-2*time.Hour), time.Hour)
+		tkn, err := a.GenerateToken(expired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.ParseClaims(tkn); err == nil {
+			t.Error("want expired token to be rejected")
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tkn, err := a.GenerateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(tkn, ".")
+		forged, err := a.GenerateToken(auth.NewClaims("2", []string{auth.RoleAdmin}, time.Now(), time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[1] = strings.Split(forged, ".")[1]
+		if _, err := a.ParseClaims(strings.Join(parts, ".")); err == nil {
+			t.Error("want token with tampered claims to be rejected")
+		}
+	})
+}
+
+func TestNewAlgorithm(t *testing.T) {
+	key := generateKey(t)
+	lookup := func(string) (*rsa.PublicKey, error) { return &key.PublicKey, nil }
+
+	for _, alg := range auth.Algorithms {
+		if _, err := auth.New(key, "1", alg, lookup); err != nil {
+			t.Errorf("want algorithm %s supported; got %v", alg, err)
+		}
+	}
+	for _, alg := range []string{"HS256", "ES256", "PS256", "none", ""} {
+		if _, err := auth.New(key, "1", alg, lookup); err == nil {
+			t.Errorf("want algorithm %q rejected", alg)
+		}
+	}
+}
+
+func TestLoadKeyStore(t *testing.T) {
+	dir := t.TempDir()
+
+	key := generateKey(t)
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(filepath.Join(dir, "2024-05.pem"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := auth.LoadKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kids := ks.KIDs(); len(kids) != 1 || kids[0] != "2024-05" {
+		t.Fatalf("want key IDs [2024-05]; got %v", kids)
+	}
+	pub, err := ks.PublicKey("2024-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(&key.PublicKey) {
+		t.Error("want the public key of the loaded key")
+	}
+	if _, err := ks.PublicKey("2024-06"); err == nil {
+		t.Error("want lookup of an unknown key to fail")
+	}
+}
+
+func TestClaimsHasScope(t *testing.T) {
+	claims := auth.NewClaims("1", []string{auth.RoleUser}, time.Now(), time.Minute)
+	if !claims.HasScope(auth.ScopeSnippetsWrite) {
+		t.Error("want claims without scopes to be unrestricted")
+	}
+
+	claims.Scopes = []string{auth.ScopeSnippetsRead}
+	if !claims.HasScope(auth.ScopeSnippetsRead) {
+		t.Errorf("want scope %q", auth.ScopeSnippetsRead)
+	}
+	if claims.HasScope(auth.ScopeSnippetsWrite) {
+		t.Errorf("want no scope %q", auth.ScopeSnippetsWrite)
+	}
+}
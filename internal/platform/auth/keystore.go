@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/pkg/errors"
+)
+
+// KeyStore holds the RSA keys used to sign and verify tokens by key ID. A key
+// is rotated by adding a new key and signing with it, while the old key stays
+// in the store until the tokens signed with it have expired.
+type KeyStore struct {
+	keys map[string]*rsa.PrivateKey
+}
+
+// NewKeyStore constructs a KeyStore holding the keys by key ID.
+func NewKeyStore(keys map[string]*rsa.PrivateKey) *KeyStore {
+	return &KeyStore{keys: keys}
+}
+
+// LoadKeyStore reads the PEM encoded RSA private keys from the "*.pem" files
+// in dir. The file name without the extension is the ID of a key, e.g. the
+// key in "2024-05.pem" has the ID "2024-05".
+func LoadKeyStore(dir string) (*KeyStore, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing key files")
+	}
+
+	keys := make(map[string]*rsa.PrivateKey)
+	for _, f := range files {
+		pem, err := os.ReadFile(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading key file")
+		}
+
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing key file %s", f)
+		}
+
+		keys[strings.TrimSuffix(filepath.Base(f), ".pem")] = key
+	}
+
+	return NewKeyStore(keys), nil
+}
+
+// KIDs returns the sorted IDs of the keys in the store.
+func (ks *KeyStore) KIDs() []string {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	slices.Sort(kids)
+
+	return kids
+}
+
+// PrivateKey returns the private key identified by kid.
+func (ks *KeyStore) PrivateKey(kid string) (*rsa.PrivateKey, error) {
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, errors.Errorf("key %q not found", kid)
+	}
+
+	return key, nil
+}
+
+// PublicKey returns the public key identified by kid. It is a KeyLookupFunc.
+func (ks *KeyStore) PublicKey(kid string) (*rsa.PublicKey, error) {
+	key, err := ks.PrivateKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key.PublicKey, nil
+}
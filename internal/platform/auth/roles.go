@@ -14,15 +14,27 @@ const (
 	RoleUser  = "USER"
 )
 
+// These are the scopes which may be granted to personal access tokens.
+const (
+	ScopeSnippetsRead  = "snippets:read"
+	ScopeSnippetsWrite = "snippets:write"
+)
+
+// Scopes lists all scopes.
+var Scopes = []string{ScopeSnippetsRead, ScopeSnippetsWrite}
+
 // ctxKey represents the type of value for the context key.
 type ctxKey int
 
 // Key is used to store/retrieve a Claims value from a context.Context.
 const Key ctxKey = 1
 
-// Claims represents the authorization claims transmitted via a JWT.
+// Claims represents the authorization claims transmitted via a JWT. Scopes
+// restrict the claims of personal access tokens, tokens issued for a login
+// are not restricted.
 type Claims struct {
-	Roles []string `json:"roles"`
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -51,6 +63,13 @@ func (c Claims) Valid(h *jwt.ValidationHelper) error {
 			return fmt.Errorf("invalid role %q", r)
 		}
 	}
+	for _, s := range c.Scopes {
+		switch s {
+		case ScopeSnippetsRead, ScopeSnippetsWrite: // Scope is valid.
+		default:
+			return fmt.Errorf("invalid scope %q", s)
+		}
+	}
 	if err := c.StandardClaims.Valid(h); err != nil {
 		return errors.Wrap(err, "validating standard claims")
 	}
@@ -68,3 +87,17 @@ func (c Claims) HasRole(roles ...string) bool {
 	}
 	return false
 }
+
+// HasScope returns true if the claims are not restricted by scopes or
+// include the provided scope.
+func (c Claims) HasScope(scope string) bool {
+	if c.Scopes == nil {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
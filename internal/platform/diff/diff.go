@@ -0,0 +1,271 @@
+// Package diff computes line based differences between two texts and groups
+// them into the hunks of a unified diff.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxEdits bounds the work done to find a minimal edit script. Texts that
+// differ in more lines are reported as a complete replacement.
+const maxEdits = 1000
+
+// Op is the kind of change applied to a line.
+type Op int
+
+// These are the supported line operations.
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// String returns the name of the operation. It is used as a CSS class name
+// when rendering diffs.
+func (o Op) String() string {
+	switch o {
+	case Delete:
+		return "delete"
+	case Insert:
+		return "insert"
+	default:
+		return "equal"
+	}
+}
+
+// Prefix returns the marker used for the operation in unified diffs.
+func (o Op) Prefix() string {
+	switch o {
+	case Delete:
+		return "-"
+	case Insert:
+		return "+"
+	default:
+		return " "
+	}
+}
+
+// Line is a single line of a diff.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Hunk is a group of changed lines together with their surrounding context.
+// Line numbers are 1-based like in unified diffs.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// Header returns the range information of the hunk, e.g. "@@ -1,3 +1,4 @@".
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Lines returns the edit script that turns text a into text b.
+func Lines(a, b string) []Line {
+	return edits(split(a), split(b))
+}
+
+// Unified groups the changes between text a and text b into hunks with the
+// given number of context lines. It returns nil if the texts are equal.
+func Unified(a, b string, context int) []Hunk {
+	ls := Lines(a, b)
+
+	// oldPos and newPos hold the number of lines consumed on either side
+	// before the line at the same index.
+	oldPos := make([]int, len(ls)+1)
+	newPos := make([]int, len(ls)+1)
+	for i, l := range ls {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if l.Op != Insert {
+			oldPos[i+1]++
+		}
+		if l.Op != Delete {
+			newPos[i+1]++
+		}
+	}
+
+	var hunks []Hunk
+	for i := 0; i < len(ls); i++ {
+		if ls[i].Op == Equal {
+			continue
+		}
+
+		// extend the hunk while the next change is close enough
+		// for the context lines to overlap
+		start := max(i-context, 0)
+		end := i
+		for j := i + 1; j < len(ls) && j <= end+2*context+1; j++ {
+			if ls[j].Op != Equal {
+				end = j
+			}
+		}
+		stop := min(end+context+1, len(ls))
+
+		h := Hunk{
+			OldStart: oldPos[start] + 1,
+			OldLines: oldPos[stop] - oldPos[start],
+			NewStart: newPos[start] + 1,
+			NewLines: newPos[stop] - newPos[start],
+			Lines:    ls[start:stop],
+		}
+		// empty ranges refer to the line before the change
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+		hunks = append(hunks, h)
+
+		i = stop - 1
+	}
+
+	return hunks
+}
+
+// String formats hunks in the unified diff format.
+func String(hunks []Hunk) string {
+	var sb strings.Builder
+	for _, h := range hunks {
+		sb.WriteString(h.Header())
+		sb.WriteByte('\n')
+		for _, l := range h.Lines {
+			sb.WriteString(l.Op.Prefix())
+			sb.WriteString(l.Text)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// edits computes a minimal edit script using the greedy algorithm described
+// in "An O(ND) Difference Algorithm and Its Variations" by Eugene W. Myers.
+// The common prefix and suffix are stripped first as most revisions only
+// touch a few lines.
+func edits(a, b []string) []Line {
+	var prefix, suffix []Line
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, Line{Op: Equal, Text: a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append(suffix, Line{Op: Equal, Text: a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	ls := prefix
+	ls = append(ls, myers(a, b)...)
+	for i := len(suffix) - 1; i >= 0; i-- {
+		ls = append(ls, suffix[i])
+	}
+
+	return ls
+}
+
+// myers returns the edit script for a and b. The furthest reaching
+// x coordinate of every diagonal is recorded for each step d, so that the
+// path can be traced back once the end has been reached.
+func myers(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(a, b)
+	}
+
+	limit := min(n+m, maxEdits)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // move down: insertion
+			} else {
+				x = v[offset+k-1] + 1 // move right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, offset, a, b)
+			}
+		}
+	}
+
+	return replace(a, b)
+}
+
+// backtrack walks the recorded steps from the end of both texts back to the
+// start and returns the resulting edit script in forward order.
+func backtrack(trace [][]int, offset int, a, b []string) []Line {
+	var ls []Line
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ls = append(ls, Line{Op: Equal, Text: a[x-1]})
+			x, y = x-1, y-1
+		}
+		if d > 0 {
+			if x == prevX {
+				ls = append(ls, Line{Op: Insert, Text: b[y-1]})
+			} else {
+				ls = append(ls, Line{Op: Delete, Text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ls)-1; i < j; i, j = i+1, j-1 {
+		ls[i], ls[j] = ls[j], ls[i]
+	}
+
+	return ls
+}
+
+// replace returns an edit script deleting all of a and inserting all of b.
+func replace(a, b []string) []Line {
+	ls := make([]Line, 0, len(a)+len(b))
+	for _, s := range a {
+		ls = append(ls, Line{Op: Delete, Text: s})
+	}
+	for _, s := range b {
+		ls = append(ls, Line{Op: Insert, Text: s})
+	}
+	return ls
+}
+
+// split breaks a text into lines. A trailing newline does not produce an
+// additional empty line.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,78 @@
+package diff
+
+import (
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{
+			name: "Equal",
+			a:    "a\nb\nc\n",
+			b:    "a\nb\nc\n",
+			want: "",
+		},
+		{
+			name: "Insert",
+			a:    "",
+			b:    "a\nb\n",
+			want: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "Delete",
+			a:    "a\nb\n",
+			b:    "",
+			want: "@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "Change",
+			a:    "a\nb\nc\nd\ne\nf\ng\nh\n",
+			b:    "a\nb\nc\nd\nE\nf\ng\nh\n",
+			want: "@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n",
+		},
+		{
+			name: "Separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			want: "@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -7,4 +8,3 @@\n 7\n 8\n 9\n-10\n",
+		},
+		{
+			name: "Merged hunks",
+			a:    "a\nb\nc\nd\n",
+			b:    "A\nb\nc\nD\n",
+			want: "@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n-d\n+D\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := String(Unified(tt.a, tt.b, 3))
+			if got != tt.want {
+				t.Errorf("want\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	ls := Lines("the\nquick\nbrown\nfox\n", "the\nslow\nbrown\ndog\nfox\n")
+
+	var inserts, deletes int
+	for _, l := range ls {
+		switch l.Op {
+		case Insert:
+			inserts++
+		case Delete:
+			deletes++
+		}
+	}
+
+	if inserts != 2 || deletes != 1 {
+		t.Errorf("want 2 inserts and 1 delete; got %d and %d: %v", inserts, deletes, ls)
+	}
+}
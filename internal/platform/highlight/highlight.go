@@ -0,0 +1,277 @@
+// Package highlight renders source code as syntax highlighted HTML. The
+// markup uses CSS classes only, so it is allowed by a strict content security
+// policy. The matching style sheet is written by Highlighter.CSS.
+package highlight
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"html/template"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/pkg/errors"
+)
+
+// Plaintext is the language of content which is not highlighted.
+const Plaintext = "plaintext"
+
+// Language is a language supported by the highlighter. The ID is the name of
+// the lexer used for it and Ext the usual file name extension.
+type Language struct {
+	ID   string
+	Name string
+	Ext  string
+}
+
+// Languages lists the supported languages in the order they are offered for
+// selection.
+var Languages = []Language{
+	{Plaintext, "Plain text", ".txt"},
+	{"bash", "Bash", ".sh"},
+	{"c", "C", ".c"},
+	{"cpp", "C++", ".cpp"},
+	{"csharp", "C#", ".cs"},
+	{"css", "CSS", ".css"},
+	{"diff", "Diff", ".diff"},
+	{"docker", "Dockerfile", ".dockerfile"},
+	{"go", "Go", ".go"},
+	{"html", "HTML", ".html"},
+	{"java", "Java", ".java"},
+	{"javascript", "JavaScript", ".js"},
+	{"json", "JSON", ".json"},
+	{"kotlin", "Kotlin", ".kt"},
+	{"lua", "Lua", ".lua"},
+	{"makefile", "Makefile", ".mk"},
+	{"markdown", "Markdown", ".md"},
+	{"perl", "Perl", ".pl"},
+	{"php", "PHP", ".php"},
+	{"powershell", "PowerShell", ".ps1"},
+	{"python", "Python", ".py"},
+	{"ruby", "Ruby", ".rb"},
+	{"rust", "Rust", ".rs"},
+	{"sql", "SQL", ".sql"},
+	{"terraform", "Terraform", ".tf"},
+	{"toml", "TOML", ".toml"},
+	{"typescript", "TypeScript", ".ts"},
+	{"yaml", "YAML", ".yaml"},
+}
+
+// byLexer maps the names of the lexers to the supported languages.
+var byLexer = func() map[string]string {
+	m := make(map[string]string)
+	for _, l := range Languages {
+		m[lexers.Get(l.ID).Config().Name] = l.ID
+	}
+	return m
+}()
+
+// Supported reports whether id identifies a supported language.
+func Supported(id string) bool {
+	_, ok := Lookup(id)
+	return ok
+}
+
+// Lookup returns the supported language identified by id.
+func Lookup(id string) (Language, bool) {
+	for _, l := range Languages {
+		if l.ID == id {
+			return l, true
+		}
+	}
+	return Language{}, false
+}
+
+// Detect guesses the language of content. The filename, e.g. a snippet title
+// like "main.go", is consulted first, then the interpreter named by a shebang
+// line and finally the content itself. Plaintext is returned if no supported
+// language is recognized.
+func Detect(filename, content string) string {
+	if l := lexers.Match(path.Base(strings.TrimSpace(filename))); l != nil {
+		if id, ok := byLexer[l.Config().Name]; ok {
+			return id
+		}
+	}
+
+	if l := shebang(content); l != nil {
+		if id, ok := byLexer[l.Config().Name]; ok {
+			return id
+		}
+	}
+
+	if l := lexers.Analyse(content); l != nil {
+		if id, ok := byLexer[l.Config().Name]; ok {
+			return id
+		}
+	}
+
+	return Plaintext
+}
+
+// shebang returns the lexer of the interpreter named in the first line of
+// content, e.g. "#!/usr/bin/env python3".
+func shebang(content string) chroma.Lexer {
+	line, _, _ := strings.Cut(content, "\n")
+	line, ok := strings.CutPrefix(line, "#!")
+	if !ok {
+		return nil
+	}
+
+	fs := strings.Fields(line)
+	if len(fs) == 0 {
+		return nil
+	}
+	interp := path.Base(fs[0])
+	if interp == "env" {
+		interp = ""
+		for _, f := range fs[1:] {
+			if !strings.HasPrefix(f, "-") {
+				interp = f
+				break
+			}
+		}
+	}
+
+	if l := lexers.Get(interp); l != nil {
+		return l
+	}
+	// python3.12 and the like
+	return lexers.Get(strings.TrimRight(interp, "0123456789."))
+}
+
+// Highlighter renders highlighted HTML and caches the results of the most
+// recent renderings. It is safe for concurrent use.
+type Highlighter struct {
+	style     *chroma.Style
+	formatter *html.Formatter
+	block     *html.Formatter
+
+	mu      sync.Mutex
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List
+}
+
+// entry is a cached rendering.
+type entry struct {
+	key  [sha256.Size]byte
+	html template.HTML
+}
+
+// New constructs a Highlighter using the named chroma style and caching up
+// to cacheSize renderings.
+func New(style string, cacheSize int) *Highlighter {
+	return &Highlighter{
+		style: styles.Get(style),
+		formatter: html.New(
+			html.WithClasses(true),
+			html.WithLineNumbers(true),
+			html.WithLinkableLineNumbers(true, "L"),
+		),
+		block:   html.New(html.WithClasses(true)),
+		size:    cacheSize,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// HTML returns content highlighted as the given language with numbered lines.
+// Each line number is an anchor with the id "L<n>". Renderings are cached by
+// the hash of the language and content.
+func (h *Highlighter) HTML(content, language string) (template.HTML, error) {
+	return h.render(h.formatter, "lines", content, language)
+}
+
+// Block returns content highlighted as the given language without line
+// numbers. It is meant for code blocks embedded in other documents.
+func (h *Highlighter) Block(content, language string) (template.HTML, error) {
+	return h.render(h.block, "block", content, language)
+}
+
+// render highlights content using the formatter f. The kind of rendering is
+// part of the cache key.
+func (h *Highlighter) render(f *html.Formatter, kind, content, language string) (template.HTML, error) {
+	key := cacheKey(kind, content, language)
+	if html, ok := h.get(key); ok {
+		return html, nil
+	}
+
+	l := lexers.Get(language)
+	if l == nil || language == Plaintext {
+		l = lexers.Fallback
+	}
+
+	it, err := chroma.Coalesce(l).Tokenise(nil, content)
+	if err != nil {
+		return "", errors.Wrapf(err, "tokenising %s", language)
+	}
+
+	var buf bytes.Buffer
+	if err := f.Format(&buf, h.style, it); err != nil {
+		return "", errors.Wrapf(err, "formatting %s", language)
+	}
+
+	// chroma escapes the content
+	html := template.HTML(buf.String())
+	h.put(key, html)
+
+	return html, nil
+}
+
+// CSS writes the style sheet for the rendered HTML.
+func (h *Highlighter) CSS(w io.Writer) error {
+	return h.formatter.WriteCSS(w, h.style)
+}
+
+// cacheKey returns the key of the rendering of content as language.
+func cacheKey(kind, content, language string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(kind + "\x00" + language + "\x00" + content))
+}
+
+func (h *Highlighter) get(key [sha256.Size]byte) (template.HTML, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.entries[key]
+	if !ok {
+		return "", false
+	}
+	h.lru.MoveToFront(e)
+
+	return e.Value.(*entry).html, true
+}
+
+func (h *Highlighter) put(key [sha256.Size]byte, html template.HTML) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.size <= 0 {
+		return
+	}
+	if e, ok := h.entries[key]; ok {
+		h.lru.MoveToFront(e)
+		return
+	}
+
+	h.entries[key] = h.lru.PushFront(&entry{key: key, html: html})
+	for h.lru.Len() > h.size {
+		e := h.lru.Back()
+		h.lru.Remove(e)
+		delete(h.entries, e.Value.(*entry).key)
+	}
+}
+
+// Len returns the number of cached renderings.
+func (h *Highlighter) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.lru.Len()
+}
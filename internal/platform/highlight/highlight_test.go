@@ -0,0 +1,85 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		want     string
+	}{
+		{"Filename", "main.go", "package main", "go"},
+		{"Path", "deploy/k8s/service.yaml", "kind: Service", "yaml"},
+		{"Dockerfile", "Dockerfile", "FROM scratch", "docker"},
+		{"Shebang", "backup", "#!/bin/bash\necho done", "bash"},
+		{"Env Shebang", "", "#!/usr/bin/env -S python3.12 -u\nprint(1)", "python"},
+		{"Unknown", "notes", "just some text", Plaintext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.filename, tt.content); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	for _, l := range Languages {
+		if !Supported(l.ID) {
+			t.Errorf("language %q is not supported", l.ID)
+		}
+	}
+	if Supported("cobol") {
+		t.Error("unexpected support for cobol")
+	}
+}
+
+func TestHTML(t *testing.T) {
+	h := New("monokai", 2)
+
+	got, err := h.HTML("package main\n\nvar s = \"<script>\"\n", "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<span class="ln" id="L3"><a class="lnlinks" href="#L3">3</a></span>`,
+		`<span class="kn">package</span>`,
+		`&lt;script&gt;`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("want %q to contain %q", got, want)
+		}
+	}
+	if strings.Contains(string(got), "style=") {
+		t.Errorf("want %q to contain no inline styles", got)
+	}
+}
+
+func TestCache(t *testing.T) {
+	h := New("monokai", 2)
+
+	for _, c := range []string{"a", "b", "a", "c"} {
+		if _, err := h.HTML(c, Plaintext); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if h.Len() != 2 {
+		t.Errorf("want %d cached renderings; got %d", 2, h.Len())
+	}
+
+	// "b" was the least recently used rendering
+	if _, ok := h.get(cacheKey("lines", "b", Plaintext)); ok {
+		t.Error("want rendering of \"b\" to be evicted")
+	}
+	if _, ok := h.get(cacheKey("lines", "a", Plaintext)); !ok {
+		t.Error("want rendering of \"a\" to be cached")
+	}
+}
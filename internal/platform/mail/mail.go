@@ -0,0 +1,224 @@
+// Package mail sends plain text email. Mail is delivered by an SMTP server in
+// production, during development it is written to a local mailbox instead.
+// Tests capture it with the SMTP server of package smtptest.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTP sends email through an SMTP server. The connection is upgraded with
+// STARTTLS when the server supports it.
+type SMTP struct {
+	host    string
+	addr    string
+	auth    smtp.Auth
+	from    string
+	timeout time.Duration
+}
+
+// defaultTimeout limits the delivery of a message if the context has no
+// deadline.
+const defaultTimeout = 30 * time.Second
+
+// NewSMTP constructs an SMTP mailer sending from the address from. Without a
+// username no authentication is attempted.
+func NewSMTP(host string, port int, username, password, from string) *SMTP {
+	s := SMTP{
+		host:    host,
+		addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+		from:    from,
+		timeout: defaultTimeout,
+	}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &s
+}
+
+// Send delivers the message to the SMTP server. Connecting and the whole
+// conversation with the server are bound by the deadline of the context, or
+// by a default timeout, and are aborted when the context is cancelled.
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	from, err := envelopeAddress(s.from)
+	if err != nil {
+		return err
+	}
+	to, err := envelopeAddress(m.To)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	if err := s.send(ctx, from, to, compose(s.from, m, time.Now())); err != nil {
+		return errors.Wrapf(err, "sending mail to %s", to)
+	}
+	return nil
+}
+
+// send runs the SMTP conversation delivering the message, following
+// smtp.SendMail.
+func (s *SMTP) send(ctx context.Context, from, to string, msg []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// unblock reads and writes in progress when the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Mailbox writes email to files in a directory instead of sending it, one
+// file per message named after the time it was sent. It is meant for
+// development. The recipient and subject of each message are logged as well,
+// never the body, which may hold one-time links.
+type Mailbox struct {
+	dir  string
+	from string
+	log  *log.Logger
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewMailbox constructs a Mailbox writing to dir.
+func NewMailbox(dir, from string, log *log.Logger) *Mailbox {
+	return &Mailbox{dir: dir, from: from, log: log}
+}
+
+// Send writes the message to the mailbox.
+func (mb *Mailbox) Send(ctx context.Context, m Message) error {
+	if _, err := envelopeAddress(m.To); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	b := compose(mb.from, m, now)
+
+	if mb.log != nil {
+		mb.log.Printf("mailbox: to %s: %s", m.To, m.Subject)
+	}
+
+	mb.mu.Lock()
+	mb.seq++
+	name := fmt.Sprintf("%s-%04d.eml", now.UTC().Format("20060102T150405"), mb.seq)
+	mb.mu.Unlock()
+
+	if err := os.MkdirAll(mb.dir, 0o700); err != nil {
+		return errors.Wrap(err, "creating mailbox")
+	}
+	if err := os.WriteFile(filepath.Join(mb.dir, name), b, 0o600); err != nil {
+		return errors.Wrapf(err, "writing mail to %s", m.To)
+	}
+	return nil
+}
+
+// compose formats the message as an RFC 5322 email.
+func compose(from string, m Message, now time.Time) []byte {
+	var b bytes.Buffer
+	header := func(k, v string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	header("From", from)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+
+	// SMTP requires CRLF line endings
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// envelopeAddress extracts the bare address from an address with an
+// optional display name, e.g. "Snippetbox <no-reply@example.com>".
+func envelopeAddress(addr string) (string, error) {
+	a, err := netmail.ParseAddress(addr)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing mail address %q", addr)
+	}
+	return a.Address, nil
+}
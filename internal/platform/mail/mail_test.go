@@ -0,0 +1,156 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tullo/snptx/internal/platform/mail/smtptest"
+)
+
+func TestCompose(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := Message{
+		To:      "alice@example.com",
+		Subject: "Reset your password",
+		Body:    "Hello\nAlice",
+	}
+
+	got := string(compose("Snippetbox <no-reply@example.com>", m, now))
+
+	want := "From: Snippetbox <no-reply@example.com>\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: Reset your password\r\n" +
+		"Date: Wed, 01 May 2024 12:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=\"utf-8\"\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Hello\r\nAlice"
+	if got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+
+	// line breaks must not inject headers
+	m.Subject = "Hi\r\nBcc: mallory@example.com"
+	got = string(compose("no-reply@example.com", m, now))
+	if strings.Contains(got, "\r\nBcc:") {
+		t.Errorf("want the subject to be encoded; got %q", got)
+	}
+}
+
+func TestEnvelopeAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{"alice@example.com", "alice@example.com", false},
+		{"Snippetbox <no-reply@example.com>", "no-reply@example.com", false},
+		{"alice@example.com\r\nBcc: mallory@example.com", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := envelopeAddress(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: want error %t; got %v", tt.addr, tt.wantErr, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: want %q; got %q", tt.addr, tt.want, got)
+		}
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mailbox")
+	mb := NewMailbox(dir, "no-reply@example.com", nil)
+
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		if err := mb.Send(context.Background(), Message{To: to, Subject: "Hi", Body: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("want 2 messages; got %d", len(files))
+	}
+
+	b, err := os.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "To: bob@example.com\r\n") {
+		t.Errorf("want the second message to bob; got %q", b)
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	s := NewSMTP(srv.Host, srv.Port, "", "", "Snippetbox <no-reply@example.com>")
+
+	m := Message{To: "Alice <alice@example.com>", Subject: "Hi", Body: "Hello\n.\nAlice"}
+	if err := s.Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("want 1 message; got %d", len(msgs))
+	}
+	if msgs[0].From != "no-reply@example.com" {
+		t.Errorf("want envelope sender no-reply@example.com; got %q", msgs[0].From)
+	}
+	if !slices.Equal(msgs[0].To, []string{"alice@example.com"}) {
+		t.Errorf("want envelope recipient alice@example.com; got %q", msgs[0].To)
+	}
+	data := string(msgs[0].Data)
+	if !strings.Contains(data, "To: Alice <alice@example.com>\r\n") {
+		t.Errorf("want the To header; got %q", data)
+	}
+	// a line holding a single dot must not end the data early
+	if !strings.HasSuffix(data, "\r\n\r\nHello\r\n.\r\nAlice\r\n") {
+		t.Errorf("want the body; got %q", data)
+	}
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	// a server accepting connections but never greeting
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	s := NewSMTP(addr.IP.String(), addr.Port, "", "", "no-reply@example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := s.Send(ctx, Message{To: "alice@example.com", Subject: "Hi", Body: "Hello"}); err == nil {
+		t.Fatal("want error from a silent server")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("want the deadline of the context to end the delivery; took %v", d)
+	}
+}
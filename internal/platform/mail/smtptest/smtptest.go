@@ -0,0 +1,161 @@
+// Package smtptest implements an in-process SMTP server for tests. The server
+// accepts every message and captures it instead of delivering it, so a test
+// sends mail with the real SMTP mailer and inspects what arrived.
+package smtptest
+
+import (
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Message is a message received by the server.
+type Message struct {
+	From string   // the envelope sender
+	To   []string // the envelope recipients
+	Data []byte   // the message with its headers, line endings are CRLF
+}
+
+// Server is the capturing SMTP server. It offers neither STARTTLS nor AUTH.
+type Server struct {
+	Host string
+	Port int
+
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewServer starts a server listening on a random port of the loopback
+// interface. It is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	s := Server{Host: addr.IP.String(), Port: addr.Port, ln: ln}
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+
+	return &s
+}
+
+// Addr returns the host and port of the server.
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := make([]Message, len(s.messages))
+	copy(msgs, s.messages)
+	return msgs
+}
+
+// Close stops the server and waits for the open connections to finish.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+// handle runs the SMTP conversation of a client.
+func (s *Server) handle(c *textproto.Conn) {
+	reply := func(code int, msg string) bool {
+		return c.PrintfLine("%d %s", code, msg) == nil
+	}
+
+	if !reply(220, "localhost smtptest") {
+		return
+	}
+
+	var m Message
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		var ok bool
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			ok = c.PrintfLine("250-localhost") == nil && reply(250, "8BITMIME")
+		case "HELO", "NOOP":
+			ok = reply(250, "OK")
+		case "RSET":
+			m = Message{}
+			ok = reply(250, "OK")
+		case "MAIL":
+			m = Message{From: address(arg, "FROM:")}
+			ok = reply(250, "OK")
+		case "RCPT":
+			m.To = append(m.To, address(arg, "TO:"))
+			ok = reply(250, "OK")
+		case "DATA":
+			if !reply(354, "End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			data, err := io.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+			// the dot reader normalizes the line endings to LF
+			m.Data = []byte(strings.ReplaceAll(string(data), "\n", "\r\n"))
+
+			s.mu.Lock()
+			s.messages = append(s.messages, m)
+			s.mu.Unlock()
+			m = Message{}
+			ok = reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			ok = reply(502, "Command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// address extracts the address from the argument of MAIL or RCPT, e.g.
+// "FROM:<alice@example.com>".
+func address(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	arg, _, _ = strings.Cut(strings.TrimSpace(arg), " ")
+	return strings.Trim(arg, "<>")
+}
@@ -0,0 +1,154 @@
+// Package markdown renders CommonMark documents with the GitHub Flavored
+// Markdown extensions to HTML which is safe to embed in a page.
+//
+// The rendered HTML is passed through an allow-list sanitizer before it is
+// returned, so raw HTML, scripts, event handlers, inline styles and unsafe
+// URLs in a document never reach the browser.
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/pkg/errors"
+	"github.com/tullo/snptx/internal/platform/highlight"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Renderer converts Markdown to sanitized HTML. Fenced code blocks are
+// highlighted by the snippet highlighter and headings get anchors.
+type Renderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+}
+
+// New constructs a Renderer using hl for fenced code blocks.
+func New(hl *highlight.Highlighter) *Renderer {
+	md := goldmark.New(
+		// the GitHub Flavored Markdown extensions with aligned table cells
+		// rendered as attributes instead of inline styles
+		goldmark.WithExtensions(
+			extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+			extension.Strikethrough,
+			extension.Linkify,
+			extension.TaskList,
+		),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		goldmark.WithRendererOptions(
+			renderer.WithNodeRenderers(util.Prioritized(&nodeRenderer{hl: hl}, 100)),
+		),
+	)
+
+	return &Renderer{md: md, policy: policy()}
+}
+
+// Render converts the Markdown document src to sanitized HTML.
+func (r *Renderer) Render(src string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := r.md.Convert([]byte(src), &buf); err != nil {
+		return "", errors.Wrap(err, "converting markdown")
+	}
+
+	// the sanitized output is safe to embed without escaping
+	return template.HTML(r.policy.SanitizeReader(&buf).String()), nil
+}
+
+// classRX matches the class names emitted by the highlighter and this
+// package, e.g. "chroma", "line" or "kd".
+var classRX = regexp.MustCompile(`^[a-z]+( [a-z]+)*$`)
+
+// idRX matches the heading ids generated from the heading text.
+var idRX = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+// policy returns the sanitizer policy. It extends the policy for user
+// generated content with the markup of highlighted code, heading anchors,
+// table cell alignment and task list checkboxes.
+func policy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+
+	p.AllowAttrs("class").Matching(classRX).OnElements("pre", "code", "span", "a")
+	p.AllowAttrs("id").Matching(idRX).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	return p
+}
+
+// nodeRenderer renders fenced code blocks through the highlighter and adds
+// anchor links to headings.
+type nodeRenderer struct {
+	hl *highlight.Highlighter
+}
+
+// RegisterFuncs implements renderer.NodeRenderer.
+func (r *nodeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+	reg.Register(ast.KindHeading, r.renderHeading)
+}
+
+func (r *nodeRenderer) renderFencedCodeBlock(w util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*ast.FencedCodeBlock)
+
+	var code bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(src))
+	}
+
+	language := highlight.Plaintext
+	if l := n.Language(src); l != nil {
+		language = string(l)
+	}
+
+	out, err := r.hl.Block(code.String(), language)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	_, _ = w.WriteString(string(out))
+
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *nodeRenderer) renderHeading(w util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.Heading)
+	tag := []byte{'h', byte('0' + n.Level)}
+
+	if !entering {
+		_, _ = w.WriteString("</")
+		_, _ = w.Write(tag)
+		_, _ = w.WriteString(">\n")
+		return ast.WalkContinue, nil
+	}
+
+	_ = w.WriteByte('<')
+	_, _ = w.Write(tag)
+	id, ok := n.AttributeString("id")
+	if ok {
+		_, _ = w.WriteString(` id="`)
+		_, _ = w.Write(util.EscapeHTML(id.([]byte)))
+		_, _ = w.WriteString(`"`)
+	}
+	_ = w.WriteByte('>')
+	if ok {
+		_, _ = w.WriteString(`<a class="anchor" href="#`)
+		_, _ = w.Write(util.EscapeHTML(id.([]byte)))
+		_, _ = w.WriteString(`">#</a>`)
+	}
+
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,82 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tullo/snptx/internal/platform/highlight"
+)
+
+func TestRender(t *testing.T) {
+	r := New(highlight.New("monokai", 16))
+
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "Heading Anchor",
+			src:  "# Deploy the app\n",
+			want: []string{`<h1 id="deploy-the-app"><a class="anchor" href="#deploy-the-app" rel="nofollow">#</a>Deploy the app</h1>`},
+		},
+		{
+			name: "Table",
+			src:  "| a | b |\n|:--|--:|\n| 1 | 2 |\n",
+			want: []string{`<th align="left">a</th>`, `<td align="right">2</td>`},
+		},
+		{
+			name: "Task List",
+			src:  "- [x] done\n- [ ] todo\n",
+			want: []string{`<input checked="" disabled="" type="checkbox"> done`, `<input disabled="" type="checkbox"> todo`},
+		},
+		{
+			name: "Fenced Code",
+			src:  "```go\nfunc main() {}\n```\n",
+			want: []string{`<pre class="chroma"><code>`, `<span class="kd">func</span>`},
+		},
+		{
+			name:    "Raw HTML",
+			src:     "<script>alert(1)</script>\n\n<img src=x onerror=alert(1)>\n",
+			notWant: []string{"<script", "onerror"},
+		},
+		{
+			name:    "JavaScript URL",
+			src:     "[click](javascript:alert(1))\n",
+			notWant: []string{"javascript:"},
+		},
+		{
+			name:    "Inline Style",
+			src:     "| a |\n|:-:|\n| 1 |\n",
+			want:    []string{`<th align="center">a</th>`},
+			notWant: []string{"style="},
+		},
+		{
+			name:    "Escaped Code",
+			src:     "```html\n<script>alert(1)</script>\n```\n",
+			want:    []string{`&lt;</span><span class="nt">script</span>`},
+			notWant: []string{"<script"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Render(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, w := range tt.want {
+				if !strings.Contains(string(got), w) {
+					t.Errorf("want %q to contain %q", got, w)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(string(got), w) {
+					t.Errorf("want %q to not contain %q", got, w)
+				}
+			}
+		})
+	}
+}
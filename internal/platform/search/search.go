@@ -0,0 +1,262 @@
+// Package search parses full-text search queries, translates them to the
+// tsquery syntax understood by the database and marks their matches in text.
+//
+// A query is a list of terms that must all match. A term is either a single
+// word, a word ending in '*' matching any word with that prefix, or a phrase
+// of words in double quotes matching the words in sequence. Words are
+// compared case-insensitively without stemming, the same way the 'simple'
+// text search configuration does.
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxTerms bounds the number of terms of a query. Additional terms are
+// ignored.
+const maxTerms = 16
+
+// Term is a single search term. A term with more than one word is a phrase.
+// Prefix applies to the last word of the term.
+type Term struct {
+	Words  []string
+	Prefix bool
+}
+
+// Query is a parsed search query. All of its terms must match.
+type Query []Term
+
+// Fragment is a piece of text which either matches a query term or not.
+type Fragment struct {
+	Text  string
+	Match bool
+}
+
+// Parse parses the search query s. Characters other than letters and digits
+// separate words, so they cannot be used to inject tsquery operators.
+func Parse(s string) Query {
+	var q Query
+	for i, part := range strings.Split(s, `"`) {
+		// odd parts are enclosed in quotes
+		if i%2 == 1 {
+			q = q.add(part, true)
+			continue
+		}
+		for _, f := range strings.Fields(part) {
+			q = q.add(f, false)
+		}
+	}
+
+	if len(q) > maxTerms {
+		q = q[:maxTerms]
+	}
+
+	return q
+}
+
+// add appends the term contained in s. Unquoted words which contain
+// separators, e.g. "key-value", are treated like a phrase.
+func (q Query) add(s string, quoted bool) Query {
+	var t Term
+	for _, tok := range tokenize(s) {
+		t.Words = append(t.Words, tok.word)
+	}
+	if len(t.Words) == 0 {
+		return q
+	}
+	t.Prefix = !quoted && strings.HasSuffix(s, "*")
+
+	return append(q, t)
+}
+
+// TSQuery returns the query in tsquery syntax, e.g. "snippet & (old <-> pond:*)".
+func (q Query) TSQuery() string {
+	terms := make([]string, len(q))
+	for i, t := range q {
+		s := strings.Join(t.Words, " <-> ")
+		if t.Prefix {
+			s += ":*"
+		}
+		if len(t.Words) > 1 {
+			s = "(" + s + ")"
+		}
+		terms[i] = s
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+// Match reports whether all terms of the query occur in text. An empty
+// query matches nothing.
+func (q Query) Match(text string) bool {
+	if len(q) == 0 {
+		return false
+	}
+
+	toks := tokenize(text)
+	for _, t := range q {
+		if len(t.find(toks)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Highlight splits text into fragments, marking the occurrences of the
+// query terms.
+func (q Query) Highlight(text string) []Fragment {
+	return q.fragments(text, tokenize(text), 0, len(text))
+}
+
+// Excerpt returns about width bytes of text surrounding the first match of
+// the query, split into fragments like Highlight. The excerpt starts and ends
+// at word boundaries and "…" marks text left out. If nothing matches, the
+// excerpt is taken from the start of text.
+func (q Query) Excerpt(text string, width int) []Fragment {
+	toks := tokenize(text)
+	if len(toks) == 0 {
+		return nil
+	}
+
+	first := len(text)
+	for _, t := range q {
+		if ms := t.find(toks); len(ms) > 0 {
+			first = min(first, toks[ms[0].from].start)
+		}
+	}
+	if first == len(text) {
+		first = toks[0].start
+	}
+
+	// begin at the first word within half the width before the match
+	start := first
+	for i := len(toks) - 1; i >= 0; i-- {
+		if toks[i].start < first && toks[i].start >= first-width/2 {
+			start = toks[i].start
+		}
+	}
+	if start == toks[0].start {
+		start = 0
+	}
+
+	// end at the last word within the width
+	end := start
+	for _, tok := range toks {
+		if tok.start >= start && (tok.end <= start+width || end == start) {
+			end = tok.end
+		}
+	}
+	if end == toks[len(toks)-1].end {
+		end = len(text)
+	}
+
+	fs := q.fragments(text, toks, start, end)
+	if start > 0 {
+		fs = append([]Fragment{{Text: "…"}}, fs...)
+	}
+	if end < len(text) {
+		fs = append(fs, Fragment{Text: "…"})
+	}
+
+	return fs
+}
+
+// fragments splits text[start:end] into fragments, marking the matches of
+// the query which lie completely within the range.
+func (q Query) fragments(text string, toks []token, start, end int) []Fragment {
+	marked := make([]bool, len(toks))
+	for _, t := range q {
+		for _, m := range t.find(toks) {
+			for i := m.from; i < m.to; i++ {
+				marked[i] = true
+			}
+		}
+	}
+
+	var fs []Fragment
+	emit := func(s string, match bool) {
+		if s == "" {
+			return
+		}
+		if n := len(fs); n > 0 && fs[n-1].Match == match {
+			fs[n-1].Text += s
+			return
+		}
+		fs = append(fs, Fragment{Text: s, Match: match})
+	}
+
+	pos := start
+	for i, tok := range toks {
+		if !marked[i] || tok.start < start || tok.end > end {
+			continue
+		}
+		// the separator between two marked words of a phrase is part
+		// of the match
+		gap := i > 0 && marked[i-1] && toks[i-1].end == pos
+		emit(text[pos:tok.start], gap)
+		emit(text[tok.start:tok.end], true)
+		pos = tok.end
+	}
+	emit(text[pos:end], false)
+
+	return fs
+}
+
+// match is a range of token indexes matched by a term.
+type match struct {
+	from, to int
+}
+
+// find returns the token ranges matching the term.
+func (t Term) find(toks []token) []match {
+	var ms []match
+	n := len(t.Words)
+	for i := 0; i+n <= len(toks); i++ {
+		ok := true
+		for j, w := range t.Words {
+			got := toks[i+j].word
+			if t.Prefix && j == n-1 {
+				ok = strings.HasPrefix(got, w)
+			} else {
+				ok = got == w
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			ms = append(ms, match{from: i, to: i + n})
+		}
+	}
+
+	return ms
+}
+
+// token is a lower cased word of a text and its byte offsets.
+type token struct {
+	word       string
+	start, end int
+}
+
+// tokenize splits s into words made up of letters and digits.
+func tokenize(s string) []token {
+	var toks []token
+	start := -1
+	for i, r := range s {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			toks = append(toks, token{word: strings.ToLower(s[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, token{word: strings.ToLower(s[start:]), start: start, end: len(s)})
+	}
+
+	return toks
+}
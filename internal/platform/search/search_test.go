@@ -0,0 +1,112 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"Empty", "  ", ""},
+		{"Words", "Old Pond", "old & pond"},
+		{"Prefix", "pon*", "pon:*"},
+		{"Phrase", `"silent pond" frog`, "(silent <-> pond) & frog"},
+		{"Separators", "key-value", "(key <-> value)"},
+		{"Operators", "a & !b | c:*", "a & b & c:*"},
+		{"Unterminated quote", `"old pond`, "(old <-> pond)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.query).TSQuery(); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	const text = "An old silent pond...\nA frog jumps into the pond"
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", false},
+		{"pond", true},
+		{"POND frog", true},
+		{"pond toad", false},
+		{"fro*", true},
+		{"fro", false},
+		{`"silent pond"`, true},
+		{`"pond silent"`, false},
+		{`"old sil*"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := Parse(tt.query).Match(text); got != tt.want {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got := Parse(`"silent pond" fro*`).Highlight("An old silent pond. A frog jumps")
+	want := []Fragment{
+		{Text: "An old "},
+		{Text: "silent pond", Match: true},
+		{Text: ". A "},
+		{Text: "frog", Match: true},
+		{Text: " jumps"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v; got %+v", want, got)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	const text = "one two three four five six seven eight nine ten"
+
+	tests := []struct {
+		name  string
+		query string
+		want  []Fragment
+	}{
+		{
+			name:  "Start",
+			query: "two",
+			want:  []Fragment{{Text: "one "}, {Text: "two", Match: true}, {Text: " three four"}, {Text: "…"}},
+		},
+		{
+			name:  "Middle",
+			query: "six",
+			want:  []Fragment{{Text: "…"}, {Text: "four five "}, {Text: "six", Match: true}, {Text: " seven"}, {Text: "…"}},
+		},
+		{
+			name:  "End",
+			query: "ten",
+			want:  []Fragment{{Text: "…"}, {Text: "nine "}, {Text: "ten", Match: true}},
+		},
+		{
+			name:  "No match",
+			query: "zero",
+			want:  []Fragment{{Text: "one two three four"}, {Text: "…"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.query).Excerpt(text, 20)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %+v; got %+v", tt.want, got)
+			}
+		})
+	}
+}
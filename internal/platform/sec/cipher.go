@@ -0,0 +1,58 @@
+package sec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+)
+
+// KeySize is the size of the keys used by Cipher (AES-256).
+const KeySize = 32
+
+// Cipher encrypts secrets stored in the database with AES-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher constructs a Cipher from a KeySize bytes long key.
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, errors.Errorf("key must be %d bytes long, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating block cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating GCM")
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts and authenticates the plaintext. The random nonce is
+// prepended to the ciphertext.
+func (c *Cipher) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a ciphertext created by Seal.
+func (c *Cipher) Open(ciphertext []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	plaintext, err := c.aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypting")
+	}
+	return plaintext, nil
+}
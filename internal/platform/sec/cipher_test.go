@@ -0,0 +1,50 @@
+package sec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("12345678901234567890")
+	sealed, err := c.Seal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, secret) {
+		t.Error("want encrypted secret; got plaintext")
+	}
+
+	opened, err := c.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, secret) {
+		t.Errorf("want %q; got %q", secret, opened)
+	}
+
+	// tampering is detected
+	sealed[len(sealed)-1] ^= 1
+	if _, err := c.Open(sealed); err == nil {
+		t.Error("want error for tampered ciphertext")
+	}
+
+	other, err := NewCipher(bytes.Repeat([]byte{8}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("want error for other key")
+	}
+
+	if _, err := NewCipher(key[:16]); err == nil {
+		t.Error("want error for short key")
+	}
+}
@@ -1,6 +1,10 @@
 package sec
 
-import "github.com/alexedwards/argon2id"
+import (
+	"fmt"
+
+	"github.com/alexedwards/argon2id"
+)
 
 // HashParams is the parameters for hashing passwords.
 type HashParams struct {
@@ -11,6 +15,29 @@ type HashParams struct {
 	KeyLength   uint32
 }
 
+// Config is the configuration of the password hashing. It is shared by snptx
+// and snptx-admin, so the hash report compares against the same defaults
+// snptx hashes with.
+type Config struct {
+	// Note: Changing the value of Parallelism - changes the hash output!
+	Memory      uint `conf:"default:131072"` // 128 * 1024 (KB) - memory used by the Argon2 algorithm
+	Iterations  uint `conf:"default:4"`      // number of passes over the memory
+	Parallelism uint `conf:"default:4"`      // number of threads to use on a machine with multiple cores
+	SaltLength  uint `conf:"default:16"`     // 16 bytes is recommended for password hashing
+	KeyLength   uint `conf:"default:32"`     // length of the generated password hash
+}
+
+// HashParams returns the parameters of the configuration.
+func (c Config) HashParams() HashParams {
+	return HashParams{
+		Memory:      uint32(c.Memory),
+		Iterations:  uint32(c.Iterations),
+		Parallelism: uint8(c.Parallelism),
+		SaltLength:  uint32(c.SaltLength),
+		KeyLength:   uint32(c.KeyLength),
+	}
+}
+
 // DefaultParams should generally be used for development/testing purposes
 // only. Custom parameters should be set for production applications depending on
 // available memory/CPU resources and business requirements.
@@ -31,3 +58,26 @@ func Params(h HashParams) *argon2id.Params {
 	}
 	return &params
 }
+
+// Outdated reports whether the hash was created with parameters other than
+// p, so the password should be hashed again with p.
+func Outdated(hash string, p *argon2id.Params) (bool, error) {
+	hp, err := HashParamsOf(hash)
+	if err != nil {
+		return false, err
+	}
+	return *hp != *p, nil
+}
+
+// FormatParams describes the parameters of a hash, e.g.
+// "m=65536,t=1,p=2,s=16,k=32" for memory, iterations, parallelism, salt and
+// key length.
+func FormatParams(p *argon2id.Params) string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d,s=%d,k=%d", p.Memory, p.Iterations, p.Parallelism, p.SaltLength, p.KeyLength)
+}
+
+// HashParamsOf returns the parameters the hash was created with.
+func HashParamsOf(hash string) (*argon2id.Params, error) {
+	hp, _, _, err := argon2id.DecodeHash(hash)
+	return hp, err
+}
@@ -0,0 +1,60 @@
+package sec
+
+import (
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+)
+
+func TestOutdated(t *testing.T) {
+	current := &argon2id.Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	tests := []struct {
+		name   string
+		params argon2id.Params
+		want   bool
+	}{
+		{"Current", *current, false},
+		{"Memory", argon2id.Params{Memory: 4 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"Iterations", argon2id.Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"Parallelism", argon2id.Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}, true},
+		{"Salt Length", argon2id.Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 8, KeyLength: 32}, true},
+		{"Key Length", argon2id.Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 16}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := argon2id.CreateHash("pa$$word", &tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Outdated(hash, current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want %t; got %t", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := Outdated("not a hash", current); err == nil {
+		t.Error("want error for invalid hash")
+	}
+}
+
+func TestFormatParams(t *testing.T) {
+	hash, err := argon2id.CreateHash("pa$$word", &argon2id.Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp, err := HashParamsOf(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := FormatParams(hp), "m=8192,t=2,p=1,s=16,k=32"; got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+}
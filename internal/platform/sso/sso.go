@@ -0,0 +1,194 @@
+// Package sso logs users in with an OpenID Connect provider. It implements
+// the authorization code flow with PKCE: the provider is discovered from its
+// issuer URL and the ID tokens are verified with the keys it publishes.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"slices"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrInvalidState occurs when the state returned by the provider is not
+	// the one of the login in progress, e.g. when the callback is forged.
+	ErrInvalidState = errors.New("sso: invalid state")
+
+	// ErrInvalidToken occurs when the provider returns no ID token, or one
+	// that fails verification.
+	ErrInvalidToken = errors.New("sso: invalid ID token")
+)
+
+// Config describes the client registered with the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // the callback of the application
+	Scopes       []string // requested in addition to "openid"
+}
+
+// Provider logs users in with an OpenID Connect provider.
+type Provider struct {
+	issuer   string
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// New discovers the provider at the issuer URL. The context is used to
+// fetch the signing keys of the provider later on as well, so it should not
+// be cancelled while the provider is in use.
+func New(ctx context.Context, cfg Config) (*Provider, error) {
+	p, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "discovering provider %q", cfg.Issuer)
+	}
+
+	return &Provider{
+		issuer: cfg.Issuer,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     p.Endpoint(),
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       append([]string{oidc.ScopeOpenID}, cfg.Scopes...),
+		},
+		verifier: p.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// Issuer returns the issuer URL of the provider.
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// Request holds the secrets of a login in progress. It is kept in the
+// session of the user until the provider redirects back to the application.
+type Request struct {
+	State    string // binds the callback to the session
+	Nonce    string // binds the ID token to the session
+	Verifier string // the PKCE code verifier
+	Prompt   string // optional, "login" makes the user log in again at the provider
+}
+
+// NewRequest generates the secrets of a new login.
+func NewRequest() (Request, error) {
+	state, err := randomString()
+	if err != nil {
+		return Request{}, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return Request{}, err
+	}
+
+	return Request{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// AuthCodeURL returns the URL of the provider the user is redirected to for
+// the login.
+func (p *Provider) AuthCodeURL(req Request) string {
+	opts := []oauth2.AuthCodeOption{
+		oidc.Nonce(req.Nonce),
+		oauth2.S256ChallengeOption(req.Verifier),
+	}
+	if req.Prompt != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", req.Prompt))
+	}
+	return p.oauth.AuthCodeURL(req.State, opts...)
+}
+
+// Identity is the user as asserted by the ID token.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	claims        map[string]any
+}
+
+// NewIdentity constructs the identity of the subject from the claims of its
+// ID token.
+func NewIdentity(subject string, claims map[string]any) *Identity {
+	id := Identity{Subject: subject, claims: claims}
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	id.Name, _ = claims["name"].(string)
+	return &id
+}
+
+// Values returns the values of a claim which is either a string or a list of
+// strings, e.g. the groups of the user. ok reports whether the ID token
+// carries the claim at all.
+func (id Identity) Values(claim string) (values []string, ok bool) {
+	switch v := id.claims[claim].(type) {
+	case string:
+		return []string{v}, true
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// HasValue reports whether a claim has the value.
+func (id Identity) HasValue(claim, value string) bool {
+	values, _ := id.Values(claim)
+	return slices.Contains(values, value)
+}
+
+// Exchange completes the login with the state and code the provider
+// redirected back with. It redeems the code for the tokens and returns the
+// identity of the verified ID token.
+func (p *Provider) Exchange(ctx context.Context, req Request, state, code string) (*Identity, error) {
+	if req.State == "" || subtle.ConstantTimeCompare([]byte(state), []byte(req.State)) != 1 {
+		return nil, ErrInvalidState
+	}
+
+	tok, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(req.Verifier))
+	if err != nil {
+		return nil, errors.Wrap(err, "redeeming code")
+	}
+
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	t, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	if subtle.ConstantTimeCompare([]byte(t.Nonce), []byte(req.Nonce)) != 1 {
+		return nil, errors.Wrap(ErrInvalidToken, "nonce mismatch")
+	}
+
+	var claims map[string]any
+	if err := t.Claims(&claims); err != nil {
+		return nil, errors.Wrap(err, "decoding claims")
+	}
+
+	return NewIdentity(t.Subject, claims), nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating random bytes")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,98 @@
+package sso_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/tullo/snptx/internal/platform/sso"
+	"github.com/tullo/snptx/internal/platform/sso/ssotest"
+)
+
+const redirectURL = "https://snptx.test/user/login/oidc/callback"
+
+func TestExchange(t *testing.T) {
+	idp := ssotest.NewProvider(t, ssotest.User{
+		Subject:       "248289761001",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+		Claims:        map[string]any{"groups": []string{"staff", "snptx-admins"}},
+	})
+
+	p, err := sso.New(t.Context(), idp.Config(redirectURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Issuer() != idp.URL {
+		t.Errorf("want issuer %q; got %q", idp.URL, p.Issuer())
+	}
+
+	// login runs the flow up to the callback and returns its state and code
+	login := func(t *testing.T) (sso.Request, string, string) {
+		req, err := sso.NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cb := idp.Authorize(t, p.AuthCodeURL(req))
+		if got := cb.Scheme + "://" + cb.Host + cb.Path; got != redirectURL {
+			t.Fatalf("want callback %q; got %q", redirectURL, got)
+		}
+		return req, cb.Query().Get("state"), cb.Query().Get("code")
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		req, state, code := login(t)
+
+		id, err := p.Exchange(t.Context(), req, state, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.Subject != "248289761001" || id.Email != "jane@example.com" || !id.EmailVerified || id.Name != "Jane Doe" {
+			t.Errorf("unexpected identity %+v", id)
+		}
+		if groups, ok := id.Values("groups"); !ok || !slices.Equal(groups, []string{"staff", "snptx-admins"}) {
+			t.Errorf("want groups %v; got %v", []string{"staff", "snptx-admins"}, groups)
+		}
+		if !id.HasValue("groups", "snptx-admins") || id.HasValue("roles", "snptx-admins") {
+			t.Error("unexpected claim values")
+		}
+
+		// the code is used once only
+		if _, err := p.Exchange(t.Context(), req, state, code); err == nil {
+			t.Error("want error redeeming the code twice")
+		}
+	})
+
+	t.Run("Invalid State", func(t *testing.T) {
+		req, _, code := login(t)
+
+		_, err := p.Exchange(t.Context(), req, "forged", code)
+		if !errors.Is(err, sso.ErrInvalidState) {
+			t.Errorf("want error %v; got %v", sso.ErrInvalidState, err)
+		}
+	})
+
+	t.Run("Invalid Nonce", func(t *testing.T) {
+		req, state, code := login(t)
+		req.Nonce = "replayed"
+
+		_, err := p.Exchange(t.Context(), req, state, code)
+		if !errors.Is(err, sso.ErrInvalidToken) {
+			t.Errorf("want error %v; got %v", sso.ErrInvalidToken, err)
+		}
+	})
+
+	t.Run("Invalid Verifier", func(t *testing.T) {
+		req, state, code := login(t)
+		other, err := sso.NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Verifier = other.Verifier
+
+		if _, err := p.Exchange(t.Context(), req, state, code); err == nil {
+			t.Error("want error redeeming the code with another verifier")
+		}
+	})
+}
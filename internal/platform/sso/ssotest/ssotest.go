@@ -0,0 +1,248 @@
+// Package ssotest implements an in-process OpenID Connect provider for tests.
+// The provider logs in its current user without asking, so a test follows
+// the redirect to the authorization endpoint with Authorize and continues
+// with the callback URL it returns.
+package ssotest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/coreos/go-oidc/v3/oidc/oidctest"
+	"github.com/tullo/snptx/internal/platform/sso"
+)
+
+const (
+	// ClientID and ClientSecret are the credentials of the only client.
+	ClientID     = "snptx"
+	ClientSecret = "client-secret"
+
+	keyID = "test-key"
+)
+
+// User is the user logged in by the provider.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Claims        map[string]any // further claims of the ID token, e.g. the groups
+}
+
+// grant is an issued authorization code.
+type grant struct {
+	user        User
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+// Provider is the fake OpenID Connect provider.
+type Provider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	user   User
+	grants map[string]grant
+}
+
+// NewProvider starts a provider logging in the user. It is closed when the
+// test ends.
+func NewProvider(t testing.TB, u User) *Provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Provider{
+		key:    key,
+		user:   u,
+		grants: make(map[string]grant),
+	}
+
+	// discovery and keys are served by the oidctest server
+	discovery := &oidctest.Server{
+		PublicKeys: []oidctest.PublicKey{
+			{PublicKey: key.Public(), KeyID: keyID, Algorithm: oidc.RS256},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /.well-known/openid-configuration", discovery)
+	mux.Handle("GET /keys", discovery)
+	mux.HandleFunc("GET /auth", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	p.Server = httptest.NewServer(mux)
+	discovery.SetIssuer(p.URL)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// Config returns the configuration of the client with the callback.
+func (p *Provider) Config(redirectURL string) sso.Config {
+	return sso.Config{
+		Issuer:       p.URL,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email", "profile"},
+	}
+}
+
+// SetUser changes the user logged in by the provider.
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = u
+}
+
+// Authorize requests the authorization URL the client redirected to and
+// returns the callback URL the provider redirects back to.
+func (p *Provider) Authorize(t testing.TB, authURL string) *url.URL {
+	t.Helper()
+
+	c := p.Client()
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	rs, err := c.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	if rs.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: want status %d; got %d", http.StatusFound, rs.StatusCode)
+	}
+	u, err := rs.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// authorize issues a code for the current user and redirects back to the
+// client at once.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("client_id") != ClientID:
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	case q.Get("response_type") != "code":
+		http.Error(w, "unsupported response type", http.StatusBadRequest)
+		return
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		http.Error(w, "PKCE is required", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+	p.mu.Lock()
+	p.grants[code] = grant{
+		user:        p.user,
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+	}
+	p.mu.Unlock()
+
+	u, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect URI", http.StatusBadRequest)
+		return
+	}
+	v := u.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	u.RawQuery = v.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// token redeems a code for an ID token, checking the client credentials and
+// the PKCE code verifier.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != ClientID || secret != ClientSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	// codes are used once only
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		tokenError(w, "unsupported_grant_type")
+		return
+	case !ok || g.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant")
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{}
+	for k, v := range g.user.Claims {
+		claims[k] = v
+	}
+	claims["iss"] = p.URL
+	claims["aud"] = ClientID
+	claims["sub"] = g.user.Subject
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	claims["nonce"] = g.nonce
+	claims["email"] = g.user.Email
+	claims["email_verified"] = g.user.EmailVerified
+	claims["name"] = g.user.Name
+
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     oidctest.SignIDToken(p.key, keyID, oidc.RS256, string(raw)),
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
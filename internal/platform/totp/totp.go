@@ -0,0 +1,117 @@
+// Package totp implements time-based one-time passwords as specified in
+// RFC 6238 with the parameters understood by all authenticator apps: HMAC-SHA1,
+// six digits and a period of 30 seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// Digits is the length of a code.
+	Digits = 6
+
+	// Period is the time a code is valid for.
+	Period = 30 * time.Second
+
+	// Skew is the number of periods before and after the current one whose
+	// codes are accepted too, to allow for clock drift and slow typing.
+	Skew = 1
+
+	secretSize = 20
+)
+
+// encoding is how secrets are shown to users, e.g. when typing them into an
+// authenticator app instead of scanning the QR code.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "generating secret")
+	}
+	return secret, nil
+}
+
+// Encode returns the base32 representation of the secret.
+func Encode(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Step returns the number of periods since the Unix epoch at t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of the secret for the step.
+func Code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	h := hmac.New(sha1.New, secret)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, bin%1_000_000)
+}
+
+// Validate checks the code against the codes of the steps around t. It
+// returns the step of the matching code, which must be remembered to reject
+// the code if it is used again.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(Code(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the key URI which authenticator apps read from QR codes.
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func URI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", Encode(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// QRCode renders the key URI as PNG image of the size in pixels.
+func QRCode(uri string, size int) ([]byte, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding QR code")
+	}
+	return png, nil
+}
@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret is the SHA-1 secret of the test vectors in RFC 6238 appendix B.
+var secret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// the last six digits of the eight digit codes in the RFC
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got := Code(secret, Step(time.Unix(tt.unix, 0)))
+		if got != tt.want {
+			t.Errorf("%d: want %s; got %s", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"Current", Code(secret, step), step, true},
+		{"Previous", Code(secret, step-1), step - 1, true},
+		{"Next", Code(secret, step+1), step + 1, true},
+		{"Too Old", Code(secret, step-2), 0, false},
+		{"Too Short", Code(secret, step)[:5], 0, false},
+		{"Empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(secret, tt.code, now)
+			if got != tt.wantStep || ok != tt.wantOK {
+				t.Errorf("want %d %t; got %d %t", tt.wantStep, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Snippetbox", "alice@example.com", secret)
+	want := "otpauth://totp/Snippetbox:alice@example.com?algorithm=SHA1&digits=6&issuer=Snippetbox&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if got != want {
+		t.Errorf("want %s; got %s", want, got)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	png, err := QRCode(URI("Snippetbox", "alice@example.com", secret), 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Errorf("want PNG image; got %q", strings.ToValidUTF8(string(png[:8]), "?"))
+	}
+}
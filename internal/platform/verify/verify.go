@@ -0,0 +1,78 @@
+// Package verify signs the links sent to users to verify their email
+// address. A link carries the user ID, the email address and an expiry time,
+// so nothing has to be stored until the link is followed.
+package verify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidToken occurs when a token has been tampered with or has expired.
+var ErrInvalidToken = errors.New("verify: invalid or expired token")
+
+// purpose is part of the signed data, so a signature made with the same
+// secret for anything else is never a valid verification token.
+const purpose = "email-verification"
+
+// Signer creates and checks verification tokens.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner constructs a Signer with the secret, which should be at least
+// 32 random bytes.
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// Sign returns a token verifying that the user owns the email address. It is
+// valid until exp.
+func (s *Signer) Sign(userID, email string, exp time.Time) string {
+	payload := strings.Join([]string{userID, email, strconv.FormatInt(exp.Unix(), 10)}, "\n")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+// Verify checks the signature and the expiry time of the token and returns
+// the user ID and email address it was signed for.
+func (s *Signer) Verify(token string, now time.Time) (userID, email string, err error) {
+	enc, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	if !hmac.Equal(mac, s.mac(string(payload))) {
+		return "", "", ErrInvalidToken
+	}
+
+	parts := strings.Split(string(payload), "\n")
+	if len(parts) != 3 {
+		return "", "", ErrInvalidToken
+	}
+	exp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || !time.Unix(exp, 0).After(now) {
+		return "", "", ErrInvalidToken
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func (s *Signer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(purpose + "\n" + payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,46 @@
+package verify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Now()
+	s := NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+	token := s.Sign("1", "alice@example.com", now.Add(time.Hour))
+
+	userID, email, err := s.Verify(token, now)
+	if err != nil {
+		t.Fatalf("want valid token; got %v", err)
+	}
+	if userID != "1" || email != "alice@example.com" {
+		t.Errorf("want 1 alice@example.com; got %s %s", userID, email)
+	}
+
+	enc, sig, _ := strings.Cut(token, ".")
+	forged := NewSigner([]byte("another secret")).Sign("1", "alice@example.com", now.Add(time.Hour))
+	other, _, _ := strings.Cut(s.Sign("1", "mallory@example.com", now.Add(time.Hour)), ".")
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+	}{
+		{"Expired", token, now.Add(time.Hour)},
+		{"Other Secret", forged, now},
+		{"Other Payload", other + "." + sig, now},
+		{"No Signature", enc, now},
+		{"Empty", "", now},
+		{"Invalid Encoding", "!!." + sig, now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.Verify(tt.token, tt.now); err != ErrInvalidToken {
+				t.Errorf("want %v; got %v", ErrInvalidToken, err)
+			}
+		})
+	}
+}
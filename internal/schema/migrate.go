@@ -9,9 +9,14 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ownerVersion is the migration adding the owner column to the snippets, the
+// next one makes the column mandatory.
+const ownerVersion = 5
+
 // Migrate attempts to bring the schema for db up to date with the migrations
-// defined in this package.
-func Migrate(connString string) error {
+// defined in this package. When the schema is brought past ownerVersion the
+// backfill, if any, runs in between to give the existing snippets an owner.
+func Migrate(connString string, backfill func() error) error {
 	var c cockroachdb.CockroachDb
 	driver, err := c.Open(connString + "&x-statement-timeout=10000") // 10 seconds
 	if err != nil {
@@ -28,6 +33,21 @@ func Migrate(connString string) error {
 		return errors.Wrap(err, "create migrate instance")
 	}
 
+	v, _, err := mig.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return errors.Wrap(err, "read schema version")
+	}
+	if v <= ownerVersion {
+		if err := mig.Migrate(ownerVersion); err != nil && err != migrate.ErrNoChange {
+			return err
+		}
+		if backfill != nil {
+			if err := backfill(); err != nil {
+				return errors.Wrap(err, "backfill snippet owners")
+			}
+		}
+	}
+
 	if err = mig.Up(); err != migrate.ErrNoChange {
 		return err
 	}
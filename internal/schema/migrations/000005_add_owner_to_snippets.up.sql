@@ -0,0 +1 @@
+ALTER TABLE snippets ADD COLUMN owner_id UUID;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_snippets_owner;
+ALTER TABLE snippets DROP CONSTRAINT IF EXISTS fk_snippets_owner;
+ALTER TABLE snippets ALTER COLUMN owner_id DROP NOT NULL;
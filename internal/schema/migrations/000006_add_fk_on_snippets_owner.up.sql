@@ -0,0 +1,3 @@
+ALTER TABLE snippets ALTER COLUMN owner_id SET NOT NULL;
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_owner FOREIGN KEY (owner_id) REFERENCES users (user_id);
+CREATE INDEX idx_snippets_owner ON snippets(owner_id);
@@ -0,0 +1 @@
+CREATE INDEX idx_snippets_visibility_created ON snippets(visibility, date_created);
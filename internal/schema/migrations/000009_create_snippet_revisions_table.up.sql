@@ -0,0 +1,12 @@
+CREATE TABLE snippet_revisions
+(
+    revision_id   UUID,
+    snippet_id    UUID NOT NULL REFERENCES snippets (snippet_id) ON DELETE CASCADE,
+    author_id     UUID NOT NULL REFERENCES users (user_id),
+    title         TEXT,
+    content       TEXT,
+    date_created  TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (revision_id)
+);
+
+CREATE INDEX idx_snippet_revisions_snippet ON snippet_revisions(snippet_id, date_created);
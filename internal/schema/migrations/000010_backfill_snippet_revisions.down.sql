@@ -0,0 +1 @@
+DELETE FROM snippet_revisions;
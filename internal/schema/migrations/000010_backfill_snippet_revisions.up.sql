@@ -0,0 +1,5 @@
+-- Record the current state of every snippet as its first revision.
+INSERT INTO snippet_revisions (revision_id, snippet_id, author_id, title, content, date_created)
+    SELECT gen_random_uuid(), snippet_id, owner_id, title, content, date_updated
+    FROM snippets
+    WHERE NOT EXISTS (SELECT true FROM snippet_revisions r WHERE r.snippet_id = snippets.snippet_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_snippets_title;
+DROP INDEX IF EXISTS idx_snippets_expires;
+DROP INDEX IF EXISTS idx_snippets_updated;
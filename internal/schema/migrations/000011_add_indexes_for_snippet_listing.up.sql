@@ -0,0 +1,3 @@
+CREATE INDEX idx_snippets_updated ON snippets(date_updated, snippet_id);
+CREATE INDEX idx_snippets_expires ON snippets(date_expires, snippet_id);
+CREATE INDEX idx_snippets_title ON snippets(title, snippet_id);
@@ -0,0 +1 @@
+CREATE INVERTED INDEX idx_snippets_search ON snippets(to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(content, '')));
@@ -0,0 +1,15 @@
+CREATE TABLE tags
+(
+    tag_id        UUID,
+    name          TEXT NOT NULL UNIQUE,
+    PRIMARY KEY (tag_id)
+);
+
+CREATE TABLE snippet_tags
+(
+    snippet_id    UUID NOT NULL REFERENCES snippets (snippet_id) ON DELETE CASCADE,
+    tag_id        UUID NOT NULL REFERENCES tags (tag_id) ON DELETE CASCADE,
+    PRIMARY KEY (snippet_id, tag_id)
+);
+
+CREATE INDEX idx_snippet_tags_tag ON snippet_tags(tag_id);
@@ -0,0 +1 @@
+ALTER TABLE snippets ADD COLUMN language TEXT NOT NULL DEFAULT 'plaintext';
@@ -0,0 +1,14 @@
+CREATE TABLE access_tokens
+(
+    token_id        UUID,
+    user_id         UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    name            TEXT NOT NULL,
+    token_hash      BYTEA NOT NULL UNIQUE,
+    scopes          TEXT[] NOT NULL,
+    date_expires    TIMESTAMP WITH TIME ZONE,
+    date_last_used  TIMESTAMP WITH TIME ZONE,
+    date_created    TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (token_id)
+);
+
+CREATE INDEX idx_access_tokens_user ON access_tokens(user_id, date_created);
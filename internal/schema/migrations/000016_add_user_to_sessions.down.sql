@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS sessions_user_idx;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_id;
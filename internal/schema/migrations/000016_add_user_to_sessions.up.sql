@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN user_id UUID NULL;
+
+CREATE INDEX sessions_user_idx ON sessions (user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE password_resets
+(
+    token_hash      BYTEA,
+    user_id         UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    date_expires    TIMESTAMP WITH TIME ZONE NOT NULL,
+    date_created    TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (token_hash)
+);
+
+CREATE INDEX idx_password_resets_user ON password_resets(user_id);
+CREATE INDEX idx_password_resets_expires ON password_resets(date_expires);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS date_verification_sent;
+
+ALTER TABLE users DROP COLUMN IF EXISTS date_verified;
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN date_verified TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE users ADD COLUMN date_verification_sent TIMESTAMP WITH TIME ZONE NULL;
+
+-- accounts created before verification was introduced are trusted
+UPDATE users SET date_verified = date_created;
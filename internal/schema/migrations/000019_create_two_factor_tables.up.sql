@@ -0,0 +1,17 @@
+CREATE TABLE user_totp
+(
+    user_id         UUID REFERENCES users (user_id) ON DELETE CASCADE,
+    secret          BYTEA NOT NULL, -- encrypted by the application
+    last_step       INT8 NOT NULL DEFAULT 0,
+    date_enabled    TIMESTAMP WITH TIME ZONE NULL,
+    date_created    TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (user_id)
+);
+
+CREATE TABLE recovery_codes
+(
+    user_id         UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    code_hash       BYTEA NOT NULL,
+    date_created    TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE login_failures
+(
+    failure_key     STRING NOT NULL, -- 'account:<email>' or 'ip:<address>'
+    failures        INT8 NOT NULL DEFAULT 0,
+    locked_until    TIMESTAMP WITH TIME ZONE NULL,
+    date_updated    TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (failure_key)
+);
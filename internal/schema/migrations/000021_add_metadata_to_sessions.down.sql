@@ -0,0 +1,4 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip;
+ALTER TABLE sessions DROP COLUMN IF EXISTS date_last_seen;
+ALTER TABLE sessions DROP COLUMN IF EXISTS date_created;
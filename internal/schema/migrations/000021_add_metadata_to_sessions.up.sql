@@ -0,0 +1,4 @@
+ALTER TABLE sessions ADD COLUMN date_created TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE sessions ADD COLUMN date_last_seen TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE sessions ADD COLUMN ip STRING NULL;
+ALTER TABLE sessions ADD COLUMN user_agent STRING NULL;
@@ -0,0 +1,17 @@
+-- audit events are appended only, they are deleted after the retention period
+CREATE TABLE audit_events
+(
+    event_id        UUID NOT NULL,
+    event_type      STRING NOT NULL,
+    actor_id        UUID NULL,   -- the user causing the event, NULL if anonymous
+    target_id       STRING NULL, -- the user or snippet affected by the event
+    ip              STRING NULL,
+    user_agent      STRING NULL,
+    details         JSONB NOT NULL DEFAULT '{}',
+    date_created    TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (event_id)
+);
+
+CREATE INDEX audit_events_date_created_idx ON audit_events (date_created);
+CREATE INDEX audit_events_actor_idx ON audit_events (actor_id, date_created);
+CREATE INDEX audit_events_target_idx ON audit_events (target_id, date_created);
@@ -0,0 +1,11 @@
+-- links the accounts of the users to their identities at OpenID Connect providers
+CREATE TABLE user_identities
+(
+    issuer          STRING NOT NULL,
+    subject         STRING NOT NULL, -- the stable ID of the user at the issuer
+    user_id         UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    date_created    TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_idx ON user_identities (user_id);
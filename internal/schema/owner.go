@@ -0,0 +1,45 @@
+package schema
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/snptx/internal/platform/database"
+)
+
+// BackfillOwner hands the snippets created before snippet ownership was
+// tracked over to the user with the given email. It returns the number of
+// snippets reassigned. The owner is only required, and has to exist, if
+// there are such snippets.
+func BackfillOwner(ctx context.Context, db *database.DB, email string) (int64, error) {
+	const q = `
+	UPDATE snippets
+		SET owner_id = (SELECT user_id FROM users WHERE email = $1)
+		WHERE owner_id IS NULL`
+
+	var n int64
+	if err := db.QueryRow(ctx, `SELECT count(*) FROM snippets WHERE owner_id IS NULL`).Scan(&n); err != nil {
+		return 0, errors.Wrap(err, "counting snippets without owner")
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if email == "" {
+		return 0, errors.Errorf("%d snippets have no owner, a snippet owner is required", n)
+	}
+
+	var exists bool
+	if err := db.QueryRow(ctx, `SELECT EXISTS(SELECT true FROM users WHERE email = $1)`, email).Scan(&exists); err != nil {
+		return 0, errors.Wrapf(err, "looking up snippet owner %q", email)
+	}
+	if !exists {
+		return 0, errors.Errorf("snippet owner %q does not exist", email)
+	}
+
+	tag, err := db.Exec(ctx, q, email)
+	if err != nil {
+		return 0, errors.Wrap(err, "reassigning snippets")
+	}
+
+	return tag.RowsAffected(), nil
+}
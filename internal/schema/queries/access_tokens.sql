@@ -0,0 +1,26 @@
+-- name: CreateAccessToken :exec
+INSERT INTO access_tokens
+  (token_id, user_id, name, token_hash, scopes, date_expires, date_created)
+  VALUES
+    ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListAccessTokens :many
+SELECT * FROM access_tokens
+  WHERE user_id = $1
+  ORDER BY date_created DESC;
+
+-- name: GetAccessTokenByHash :one
+SELECT t.token_id, t.user_id, t.scopes, t.date_expires, u.roles
+  FROM access_tokens t
+  JOIN users u ON u.user_id = t.user_id
+  WHERE t.token_hash = $1 AND u.active;
+
+-- name: TouchAccessToken :exec
+UPDATE access_tokens
+  SET date_last_used = @now
+  WHERE token_id = @token_id
+    AND (date_last_used IS NULL OR date_last_used < @now - INTERVAL '1 minute');
+
+-- name: DeleteAccessToken :execrows
+DELETE FROM access_tokens
+  WHERE token_id = $1 AND user_id = $2;
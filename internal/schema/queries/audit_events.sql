@@ -0,0 +1,22 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events
+  (event_id, event_type, actor_id, target_id, ip, user_agent, details, date_created)
+  VALUES
+    ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListAuditEvents :many
+SELECT event_id, event_type, actor_id, target_id, ip, user_agent, details, date_created FROM audit_events
+  WHERE (@event_type::STRING = '' OR event_type = @event_type::STRING)
+    AND (@user_id::STRING = '' OR actor_id::STRING = @user_id::STRING OR target_id = @user_id::STRING)
+    AND (date_created, event_id) < (@before::TIMESTAMPTZ, @before_id::UUID)
+  ORDER BY date_created DESC, event_id DESC
+  LIMIT @max_rows;
+
+-- name: DeleteExpiredAuditEvents :execrows
+DELETE FROM audit_events
+  WHERE event_id IN (
+    SELECT event_id FROM audit_events
+      WHERE date_created < @created_before::TIMESTAMPTZ
+      ORDER BY date_created
+      LIMIT @max_rows
+  );
@@ -0,0 +1,47 @@
+-- name: GetLoginLockout :one
+SELECT locked_until FROM login_failures
+  WHERE failure_key = ANY(@failure_keys::STRING[])
+    AND locked_until > @now::TIMESTAMPTZ
+  ORDER BY locked_until DESC
+  LIMIT 1;
+
+-- name: AttemptLogin :one
+INSERT INTO login_failures
+  (failure_key, failures, locked_until, date_updated)
+  VALUES
+    (@failure_key, 1, CASE WHEN @threshold::INT8 <= 1 THEN @now::TIMESTAMPTZ + @delay::INTERVAL END, @now)
+  ON CONFLICT (failure_key) DO UPDATE
+    SET failures = CASE
+          WHEN login_failures.date_updated < @window_start::TIMESTAMPTZ THEN 1
+          ELSE login_failures.failures + 1
+        END,
+        locked_until = CASE
+          WHEN login_failures.date_updated < @window_start::TIMESTAMPTZ THEN excluded.locked_until
+          WHEN login_failures.failures + 1 >= @threshold::INT8 THEN @now::TIMESTAMPTZ + LEAST(
+            @delay::INTERVAL * power(2, LEAST(login_failures.failures + 1 - @threshold::INT8, 30))::INT8,
+            @max_delay::INTERVAL)
+        END,
+        date_updated = excluded.date_updated
+    WHERE login_failures.locked_until IS NULL OR login_failures.locked_until <= @now::TIMESTAMPTZ
+  RETURNING failures, locked_until;
+
+-- name: ReleaseLoginAttempt :exec
+UPDATE login_failures
+  SET failures = failures - 1,
+      locked_until = CASE WHEN locked_until = @locked_until THEN NULL ELSE locked_until END
+  WHERE failure_key = @failure_key
+    AND failures > 0;
+
+-- name: DeleteLoginFailures :exec
+DELETE FROM login_failures
+  WHERE failure_key = $1;
+
+-- name: DeleteExpiredLoginFailures :execrows
+DELETE FROM login_failures
+  WHERE failure_key IN (
+    SELECT failure_key FROM login_failures
+      WHERE date_updated < current_timestamp - INTERVAL '1 day'
+        AND (locked_until IS NULL OR locked_until < current_timestamp)
+      ORDER BY date_updated
+      LIMIT @max_rows
+  );
@@ -0,0 +1,27 @@
+-- name: CreatePasswordReset :exec
+INSERT INTO password_resets
+  (token_hash, user_id, date_expires, date_created)
+  VALUES
+    ($1, $2, $3, $4);
+
+-- name: GetPasswordReset :one
+SELECT * FROM password_resets
+  WHERE token_hash = $1;
+
+-- name: ConsumePasswordReset :one
+DELETE FROM password_resets
+  WHERE token_hash = $1
+  RETURNING user_id, date_expires;
+
+-- name: DeleteUserPasswordResets :exec
+DELETE FROM password_resets
+  WHERE user_id = $1;
+
+-- name: DeleteExpiredPasswordResets :execrows
+DELETE FROM password_resets
+  WHERE token_hash IN (
+    SELECT token_hash FROM password_resets
+      WHERE date_expires < current_timestamp
+      ORDER BY date_expires
+      LIMIT @max_rows
+  );
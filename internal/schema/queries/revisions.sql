@@ -0,0 +1,21 @@
+-- name: CreateSnippetRevision :exec
+INSERT INTO snippet_revisions
+  (revision_id, snippet_id, author_id, title, content, date_created)
+  VALUES
+    ($1, $2, $3, $4, $5, $6);
+
+-- name: ListSnippetRevisions :many
+SELECT r.*, u.name AS author_name FROM snippet_revisions r
+  JOIN users u ON u.user_id = r.author_id
+  WHERE r.snippet_id = $1
+  ORDER BY r.date_created DESC;
+
+-- name: GetSnippetRevision :one
+SELECT r.*, u.name AS author_name FROM snippet_revisions r
+  JOIN users u ON u.user_id = r.author_id
+  WHERE r.snippet_id = $1 AND r.revision_id = $2;
+
+-- name: ReassignUserRevisions :execrows
+UPDATE snippet_revisions
+  SET author_id = (SELECT s.owner_id FROM snippets s WHERE s.snippet_id = snippet_revisions.snippet_id)
+  WHERE author_id = $1;
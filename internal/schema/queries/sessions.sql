@@ -0,0 +1,33 @@
+-- name: CommitSession :exec
+INSERT INTO sessions
+  (token, data, expiry, user_id, date_created, date_last_seen, ip, user_agent)
+  VALUES
+    ($1, $2, $3, $4, $5, $6, $7, $8)
+  ON CONFLICT (token) DO UPDATE SET data = excluded.data, expiry = excluded.expiry, user_id = excluded.user_id,
+    date_created = excluded.date_created, date_last_seen = excluded.date_last_seen, ip = excluded.ip, user_agent = excluded.user_agent;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions
+  WHERE token IN (
+    SELECT token FROM sessions
+      WHERE expiry < current_timestamp
+      ORDER BY expiry
+      LIMIT @max_rows
+  );
+
+-- name: DeleteUserSessions :execrows
+DELETE FROM sessions
+  WHERE user_id = @user_id::UUID;
+
+-- name: ListUserSessions :many
+SELECT token, date_created, date_last_seen, ip, user_agent FROM sessions
+  WHERE user_id = @user_id::UUID AND expiry > current_timestamp
+  ORDER BY date_last_seen DESC;
+
+-- name: DeleteUserSession :execrows
+DELETE FROM sessions
+  WHERE token = @token AND user_id = @user_id::UUID;
+
+-- name: DeleteOtherUserSessions :execrows
+DELETE FROM sessions
+  WHERE user_id = @user_id::UUID AND token <> @token;
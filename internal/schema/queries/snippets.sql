@@ -2,21 +2,20 @@
 SELECT * FROM snippets
   WHERE snippet_id = $1 LIMIT 1;
 
--- name: ListSnippets :many
+-- name: GetSnippetForUpdate :one
 SELECT * FROM snippets
-  ORDER BY title;
+  WHERE snippet_id = $1 LIMIT 1
+  FOR UPDATE;
 
--- name: ListLatestSnippets :many
+-- name: ListSnippets :many
 SELECT * FROM snippets
-	WHERE date_expires > NOW()
-	ORDER BY date_created DESC
-	LIMIT 10;
+  ORDER BY title;
 
 -- name: CreateSnippet :one
 INSERT INTO snippets
-  (snippet_id, title, content, date_expires, date_created, date_updated)
+  (snippet_id, owner_id, title, content, visibility, language, date_expires, date_created, date_updated)
   VALUES
-    ($1, $2, $3, $4, $5, $6)
+    ($1, $2, $3, $4, $5, $6, $7, $8, $9)
   RETURNING *;
 
 -- name: UpdateSnippet :exec
@@ -24,10 +23,40 @@ UPDATE snippets
   SET
     "title" = $2,
     "content" = $3,
-    "date_expires" = $4,
-    "date_updated" = $5
+    "visibility" = $4,
+    "language" = $5,
+    "date_expires" = $6,
+    "date_updated" = $7
   WHERE snippet_id = $1;
 
 -- name: DeleteSnippet :exec
 DELETE FROM snippets
   WHERE snippet_id = $1;
+
+-- name: SearchSnippets :many
+SELECT *,
+    ts_rank(to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(content, '')), to_tsquery('simple', @query::STRING))::FLOAT8 AS rank
+  FROM snippets
+  WHERE to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(content, '')) @@ to_tsquery('simple', @query::STRING)
+    AND (visibility = 'public' OR owner_id::STRING = @viewer_id::STRING)
+    AND date_expires > NOW()
+  ORDER BY rank DESC, date_created DESC
+  LIMIT @max_results;
+
+-- name: DeleteExpiredSnippets :execrows
+DELETE FROM snippets
+  WHERE snippet_id IN (
+    SELECT snippet_id FROM snippets
+      WHERE date_expires <= NOW()
+      ORDER BY date_expires
+      LIMIT @max_rows
+  );
+
+-- name: ReassignUserSnippets :execrows
+UPDATE snippets
+  SET owner_id = @successor_id
+  WHERE owner_id = @user_id;
+
+-- name: DeleteUserSnippets :execrows
+DELETE FROM snippets
+  WHERE owner_id = $1;
@@ -0,0 +1,40 @@
+-- name: UpsertTag :one
+INSERT INTO tags
+  (tag_id, name)
+  VALUES
+    ($1, $2)
+  ON CONFLICT (name) DO UPDATE SET name = excluded.name
+  RETURNING tag_id;
+
+-- name: AddSnippetTag :exec
+INSERT INTO snippet_tags
+  (snippet_id, tag_id)
+  VALUES
+    ($1, $2)
+  ON CONFLICT DO NOTHING;
+
+-- name: DeleteSnippetTags :exec
+DELETE FROM snippet_tags
+  WHERE snippet_id = $1;
+
+-- name: ListSnippetTags :many
+SELECT t.name FROM tags t
+  JOIN snippet_tags st ON st.tag_id = t.tag_id
+  WHERE st.snippet_id = $1
+  ORDER BY t.name;
+
+-- name: ListTagsOfSnippets :many
+SELECT st.snippet_id, t.name FROM tags t
+  JOIN snippet_tags st ON st.tag_id = t.tag_id
+  WHERE st.snippet_id = ANY(@snippet_ids::UUID[])
+  ORDER BY t.name;
+
+-- name: SuggestTags :many
+SELECT t.name FROM tags t
+  JOIN snippet_tags st ON st.tag_id = t.tag_id
+  JOIN snippets s ON s.snippet_id = st.snippet_id
+  WHERE t.name LIKE @pattern::STRING
+    AND (s.visibility = 'public' OR s.owner_id::STRING = @viewer_id::STRING)
+  GROUP BY t.name
+  ORDER BY count(*) DESC, t.name
+  LIMIT @max_results;
@@ -0,0 +1,46 @@
+-- name: CreateTOTP :exec
+INSERT INTO user_totp
+  (user_id, secret, date_created)
+  VALUES
+    ($1, $2, $3);
+
+-- name: GetTOTP :one
+SELECT * FROM user_totp
+  WHERE user_id = $1;
+
+-- name: CountTOTP :one
+SELECT count(*) FROM user_totp;
+
+-- name: EnableTOTP :execrows
+UPDATE user_totp
+  SET date_enabled = @now, last_step = @step
+  WHERE user_id = @user_id AND date_enabled IS NULL;
+
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+  SET last_step = @step
+  WHERE user_id = @user_id
+    AND date_enabled IS NOT NULL
+    AND last_step < @step;
+
+-- name: DeleteTOTP :execrows
+DELETE FROM user_totp
+  WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes
+  (user_id, code_hash, date_created)
+  VALUES
+    ($1, $2, $3);
+
+-- name: CountRecoveryCodes :one
+SELECT count(*) FROM recovery_codes
+  WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+DELETE FROM recovery_codes
+  WHERE user_id = $1 AND code_hash = $2;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+  WHERE user_id = $1;
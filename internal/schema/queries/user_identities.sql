@@ -0,0 +1,9 @@
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities
+  (issuer, subject, user_id, date_created)
+  VALUES
+    ($1, $2, $3, $4);
+
+-- name: GetUserIdentity :one
+SELECT user_id FROM user_identities
+  WHERE issuer = $1 AND subject = $2;
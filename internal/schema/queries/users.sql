@@ -2,6 +2,11 @@
 SELECT * FROM users
   WHERE "user_id" = $1;
 
+-- name: GetUserForUpdate :one
+SELECT * FROM users
+  WHERE "user_id" = $1
+  FOR UPDATE;
+
 -- name: GetUserByEmail :one
 SELECT * FROM users
   WHERE email = $1;
@@ -22,9 +27,13 @@ UPDATE users
   SET
     "name" = $2,
     "email" = $3,
-    "roles" = $4,
-    "password_hash" = $5,
-    "date_updated" = $6
+    "active" = $4,
+    "roles" = $5,
+    "password_hash" = $6,
+    "date_updated" = $7,
+    -- a changed email address has to be verified again
+    "date_verified" = CASE WHEN "email" = $3 THEN "date_verified" END,
+    "date_verification_sent" = CASE WHEN "email" = $3 THEN "date_verification_sent" END
   WHERE user_id = $1;
 
 -- name: ChangePassword :exec
@@ -46,3 +55,26 @@ SELECT EXISTS(
   WHERE
     "user_id" = $1
 );
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+  SET
+    "date_verified" = COALESCE("date_verified", @now::TIMESTAMPTZ)
+  WHERE
+    "user_id" = @user_id AND "email" = @email;
+
+-- name: RecordVerificationSent :execrows
+UPDATE users
+  SET
+    "date_verification_sent" = @now
+  WHERE
+    "user_id" = @user_id
+    AND "date_verified" IS NULL
+    AND ("date_verification_sent" IS NULL OR "date_verification_sent" <= @sent_before);
+
+-- name: RehashPassword :execrows
+UPDATE users
+  SET
+    "password_hash" = @new_hash
+  WHERE
+    "user_id" = @user_id AND "password_hash" = @old_hash;
@@ -83,7 +83,7 @@ func NewUnit(t *testing.T, ctx context.Context) (*database.DB, func()) {
 		t.Fatal(fmt.Errorf("database connection error: %w", err))
 	}
 
-	err = schema.Migrate(connstr)
+	err = schema.Migrate(connstr, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -4,7 +4,10 @@ import (
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 	"unicode/utf8"
+
+	"github.com/google/uuid"
 )
 
 // EmailRX is a regular expression for sanity check the email address format.
@@ -15,6 +18,11 @@ import (
 // https://html.spec.whatwg.org/multipage/input.html#valid-e-mail-address
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// TagRX is a regular expression for normalized tags: words of letters and
+// digits joined by '.', '_', '+' or '-', optionally followed by '+' as in
+// "c++".
+var TagRX = regexp.MustCompile(`^[\p{L}\p{N}]+([._+-]+[\p{L}\p{N}]+)*[+]*$`)
+
 type Validator struct {
 	NonFieldErrors []string
 	FieldErrors    map[string]string
@@ -64,6 +72,23 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+// DateLayout is the format of dates submitted by HTML date inputs.
+const DateLayout = "2006-01-02"
+
+// IsDate returns true if the value is a date in the format of DateLayout,
+// e.g. "2024-05-31".
+func IsDate(value string) bool {
+	_, err := time.Parse(DateLayout, value)
+	return err == nil
+}
+
+// IsUUID returns true if the value is a UUID, the form of all IDs stored in
+// the database.
+func IsUUID(value string) bool {
+	_, err := uuid.Parse(value)
+	return err == nil
+}
+
 func Equals(a, b string) bool {
 	return strings.EqualFold(a, b)
 }
@@ -5,4 +5,74 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// suggest existing tags for the last tag typed into a tag input
+var tagInput = document.querySelector("input[name='tags'][list]");
+if (tagInput) {
+	var suggestions = document.getElementById(tagInput.getAttribute("list"));
+	tagInput.addEventListener("input", function () {
+		var tags = tagInput.value.split(",");
+		var prefix = tags.pop().trim();
+		var head = tags.length > 0 ? tags.join(",") + ", " : "";
+
+		suggestions.replaceChildren();
+		if (prefix == "") {
+			return;
+		}
+
+		fetch("/tags?q=" + encodeURIComponent(prefix))
+			.then(function (res) { return res.ok ? res.json() : []; })
+			.then(function (names) {
+				suggestions.replaceChildren();
+				for (var i = 0; i < names.length; i++) {
+					var option = document.createElement("option");
+					option.value = head + names[i];
+					suggestions.appendChild(option);
+				}
+			});
+	});
+}
+
+// highlight the lines of a snippet selected by the URL fragment, e.g. #L12
+// or #L12-L20. Shift-clicking a line number extends the selection.
+var snippetLines = document.querySelectorAll(".chroma .line");
+if (snippetLines.length > 0) {
+	var selectLines = function () {
+		var m = window.location.hash.match(/^#L(\d+)(?:-L(\d+))?$/);
+		for (var i = 0; i < snippetLines.length; i++) {
+			snippetLines[i].classList.remove("hl");
+		}
+		if (!m) {
+			return;
+		}
+
+		var from = parseInt(m[1], 10);
+		var to = m[2] ? parseInt(m[2], 10) : from;
+		if (to < from) {
+			var t = from; from = to; to = t;
+		}
+		if (from < 1 || from > snippetLines.length) {
+			return;
+		}
+		for (var n = from; n <= to && n <= snippetLines.length; n++) {
+			snippetLines[n - 1].classList.add("hl");
+		}
+		snippetLines[from - 1].scrollIntoView();
+	};
+
+	document.addEventListener("click", function (e) {
+		var link = e.target.closest(".chroma a.lnlinks");
+		if (!link || !e.shiftKey) {
+			return;
+		}
+		var m = window.location.hash.match(/^#L(\d+)/);
+		if (m) {
+			e.preventDefault();
+			window.location.hash = "#L" + m[1] + "-" + link.getAttribute("href").substring(1);
+		}
+	});
+
+	window.addEventListener("hashchange", selectLines);
+	selectLines();
+}